@@ -3,14 +3,33 @@ package jqp
 import (
 	"errors"
 	"reflect"
+	"sync"
 )
 
-func unmarshal(src interface{}, rv reflect.Value) (err error) {
-	typ := rv.Type()
-	if typ.Kind() != reflect.Struct {
-		return errors.New("jqp/unmarshal: value must be pointer to a struct")
+// fieldQuery is the compiled query from the tag of a struct field
+type fieldQuery struct {
+	index  int
+	name   string
+	filter *Filter
+}
+
+// structQueries holds the compiled field queries of a struct type
+type structQueries struct {
+	fields []fieldQuery
+	err    error
+}
+
+// queryCache maps struct types to their compiled field queries such
+// that tags are only lexed and parsed once per type.
+var queryCache sync.Map // map[reflect.Type]*structQueries
+
+// cachedQueries returns the compiled field queries for the struct type
+func cachedQueries(typ reflect.Type) ([]fieldQuery, error) {
+	if sq, ok := queryCache.Load(typ); ok {
+		return sq.(*structQueries).fields, sq.(*structQueries).err
 	}
 
+	sq := &structQueries{}
 	for i := 0; i < typ.NumField(); i++ {
 		sf := typ.Field(i)
 		q, hasTag := sf.Tag.Lookup("jqp")
@@ -18,46 +37,75 @@ func unmarshal(src interface{}, rv reflect.Value) (err error) {
 			continue //no tag
 		}
 
-		fv := rv.Field(i)
+		f, err := Compile(q)
+		if err != nil {
+			sq = &structQueries{err: errors.New("jqp/unmarshal: invalid query on field '" + sf.Name + "': " + err.Error())}
+			break
+		}
+
+		sq.fields = append(sq.fields, fieldQuery{index: i, name: sf.Name, filter: f})
+	}
+
+	actual, _ := queryCache.LoadOrStore(typ, sq)
+	return actual.(*structQueries).fields, actual.(*structQueries).err
+}
+
+func unmarshal(src interface{}, rv reflect.Value) (err error) {
+	typ := rv.Type()
+	if typ.Kind() != reflect.Struct {
+		return errors.New("jqp/unmarshal: value must be pointer to a struct")
+	}
+
+	fields, err := cachedQueries(typ)
+	if err != nil {
+		return err
+	}
+
+	for _, fq := range fields {
+		fv := rv.Field(fq.index)
 		if !fv.CanSet() {
-			return errors.New("jqp/unmarshal: field '" + sf.Name + "' cannot be set, must be exported")
+			return errors.New("jqp/unmarshal: field '" + fq.name + "' cannot be set, must be exported")
 		}
 
-		// @TODo have and handle query errors
-		src = Query(q, src)
-		qv := reflect.ValueOf(src)
+		res, err := fq.filter.Run(src)
+		if err != nil {
+			return err
+		}
+
+		if res == nil {
+			continue // null leaves the field untouched
+		}
+
+		qv := reflect.ValueOf(res)
 
 		switch fv.Kind() {
 		case reflect.Struct:
-			err = unmarshal(src, fv)
+			err = unmarshal(res, fv)
 			if err != nil {
-				return
+				return err
 			}
 		case reflect.Slice: //@TODO how about arrays?
 
 			//
 
-			srcs, ok := src.([]interface{})
+			srcs, ok := res.([]interface{})
 			if !ok {
-				return errors.New("jqp/unmarshal: query resulted in a '" + qv.Type().String() + "' but the field '" + sf.Name + "' is of type " + fv.Kind().String())
+				return errors.New("jqp/unmarshal: query resulted in a '" + qv.Type().String() + "' but the field '" + fq.name + "' is of type " + fv.Kind().String())
 			}
 
 			fv.Set(reflect.MakeSlice(fv.Type(), len(srcs), len(srcs)))
 			for j := 0; j < len(srcs); j++ {
-				err = unmarshal(srcs[i], fv.Index(j))
+				err = unmarshal(srcs[j], fv.Index(j))
 				if err != nil {
-					return
+					return err
 				}
 			}
 
-			// _ = srcs
-			// panic("not implemented")
-
 		// @TODO handle slice of: structs, basic types
 		// @TODO handle other kinds: https://godoc.org/reflect#Kind
 		default:
 			if !qv.Type().AssignableTo(fv.Type()) {
-				return errors.New("jqp/unmarshal: query resulted in a '" + qv.Type().String() + "' but it is not assignable to field '" + sf.Name + "' of type " + fv.Kind().String())
+				return errors.New("jqp/unmarshal: query resulted in a '" + qv.Type().String() + "' but it is not assignable to field '" + fq.name + "' of type " + fv.Kind().String())
 			}
 
 			// finally, set the field
@@ -69,7 +117,8 @@ func unmarshal(src interface{}, rv reflect.Value) (err error) {
 }
 
 // Unmarshal will read data from 'src' into the value pointed to by 'v' using
-// the queries in its field tags.
+// the queries in its field tags. The queries are compiled once per struct
+// type and reused for every call.
 func Unmarshal(src interface{}, v interface{}) (err error) {
 
 	// as seen on: https://golang.org/src/encoding/json/decode.go?s=4043:4091#L170
@@ -47,3 +47,69 @@ func TestNestedStructUnmarshal(t *testing.T) {
 		t.Fatalf("unmarshal didn't yield correct value, got: %v", v.B.C.Bar)
 	}
 }
+
+func TestNullFieldUnmarshal(t *testing.T) {
+	src := map[string]interface{}{"foo": nil, "bar": true}
+	type A struct {
+		Foo string `jqp:"$.foo"`
+		Bar bool   `jqp:"$.bar"`
+	}
+
+	v := A{Foo: "keep"}
+	if err := jqp.Unmarshal(src, &v); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	if v.Foo != "keep" || v.Bar != true {
+		t.Fatalf("unmarshal didn't yield correct value, got: %#v", v)
+	}
+}
+
+func TestMultiFieldUnmarshal(t *testing.T) {
+	src := map[string]interface{}{"foo": "a", "bar": "b"}
+	type A struct {
+		Foo string `jqp:"$.foo"`
+		Bar string `jqp:"$.bar"`
+	}
+
+	for i := 0; i < 2; i++ {
+		v := A{}
+		if err := jqp.Unmarshal(src, &v); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+
+		if v.Foo != "a" || v.Bar != "b" {
+			t.Fatalf("unmarshal didn't yield correct value, got: %#v", v)
+		}
+	}
+}
+
+func TestFallbackFieldUnmarshal(t *testing.T) {
+	src := map[string]interface{}{"target": map[string]interface{}{"value": "a"}}
+	type A struct {
+		Value string `jqp:"$.detail.value // $.target.value"`
+		Kind  string `jqp:"if has(\"detail\") then \"detail\" else \"target\" end"`
+	}
+
+	v := A{}
+	if err := jqp.Unmarshal(src, &v); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	if v.Value != "a" || v.Kind != "target" {
+		t.Fatalf("unmarshal didn't yield correct value, got: %#v", v)
+	}
+}
+
+func TestInvalidTagUnmarshal(t *testing.T) {
+	type A struct {
+		Foo string `jqp:"$."`
+	}
+
+	for i := 0; i < 2; i++ {
+		err := jqp.Unmarshal("foo", &A{})
+		if err == nil || err.Error() != "jqp/unmarshal: invalid query on field 'Foo': jqp/parser: expected identifier after dot, got: 2:EOF at position 2, expression so far: <var $>" {
+			t.Fatalf("expected invalid query error, got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package jqp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/advanderveer/jqp/token"
+	"github.com/advanderveer/jqp/value"
+)
+
+// ModuleLoader loads the source of the modules that are imported or
+// included by a query, as in 'import "lib/util" as util;'. The path is
+// passed as written in the directive, modules import their own
+// dependencies with paths relative to the same root. Loaders should
+// return ErrModuleNotFound if there is no module at the path.
+type ModuleLoader interface {
+	LoadModule(path string) (string, error)
+}
+
+// ErrModuleNotFound is returned by the loaders of this package when
+// there is no module at a path
+var ErrModuleNotFound = errors.New("module not found")
+
+// ModuleError is returned when a module cannot be loaded or compiled.
+// Errors in the source of the module are of type *token.LexError or
+// *ParseError, their positions refer to the source of the module.
+type ModuleError struct {
+	Path string // path of the module as written in the directive
+	Err  error  // the reason the module could not be loaded
+}
+
+func (e *ModuleError) Error() string {
+	return "jqp: module " + strconv.Quote(e.Path) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the reason the module could not be loaded
+func (e *ModuleError) Unwrap() error { return e.Err }
+
+// MapLoader loads modules from memory, the keys are the paths as
+// written in the directives and the values are the source of the
+// modules.
+type MapLoader map[string]string
+
+// LoadModule returns the source of the module at the path
+func (l MapLoader) LoadModule(path string) (string, error) {
+	src, ok := l[path]
+	if !ok {
+		return "", ErrModuleNotFound
+	}
+
+	return src, nil
+}
+
+// ReadFileFS is a file system that can read files by their slash
+// separated path, such as an embed.FS
+type ReadFileFS interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// FSLoader loads modules from a file system, such as an embed.FS. The
+// module "lib/util" is read from "lib/util.jq", or else from
+// "lib/util/util.jq" like jq does. Paths must be relative and cannot
+// leave the root of the file system.
+func FSLoader(fsys ReadFileFS) ModuleLoader {
+	return fsLoader{fsys}
+}
+
+// DirLoader loads modules from the files in the directory 'dir' on
+// disk, see FSLoader.
+func DirLoader(dir string) ModuleLoader {
+	return fsLoader{dirFS(dir)}
+}
+
+type fsLoader struct{ fsys ReadFileFS }
+
+// LoadModule reads the first file that exists for the path
+func (l fsLoader) LoadModule(p string) (string, error) {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.New("invalid module path, it must be relative and clean")
+	}
+
+	for _, name := range []string{p + ".jq", p + "/" + path.Base(p) + ".jq"} {
+		data, err := l.fsys.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		return string(data), nil
+	}
+
+	return "", ErrModuleNotFound
+}
+
+// dirFS reads files from a directory on disk
+type dirFS string
+
+func (dir dirFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(dir), filepath.FromSlash(name)))
+}
+
+// WithModuleLoader loads the modules that the query imports or includes
+// with 'l'. Modules are loaded once when the query is compiled, as are
+// modules whose path is piped into the modulemeta builtin as a string
+// literal. Without a loader queries cannot have import or include
+// directives.
+func WithModuleLoader(l ModuleLoader) Option {
+	return func(f *Filter) { f.loader = l }
+}
+
+// modules compiles the modules of a single query, every module is
+// loaded at most once
+type modules struct {
+	loader     ModuleLoader
+	bigNumbers bool
+	loaded     map[string]*value.Module
+	loading    map[string]bool
+}
+
+func newModules(loader ModuleLoader, bigNumbers bool) *modules {
+	return &modules{
+		loader:     loader,
+		bigNumbers: bigNumbers,
+		loaded:     map[string]*value.Module{},
+		loading:    map[string]bool{},
+	}
+}
+
+// deps loads the modules of the directives
+func (ms *modules) deps(deps []dependency) ([]*value.Dependency, error) {
+	res := make([]*value.Dependency, 0, len(deps))
+	for _, dep := range deps {
+		if ms.loader == nil {
+			return nil, &ParseError{Tok: dep.tok, Msg: "cannot import modules without a module loader"}
+		}
+
+		meta, err := constMeta(dep.tok, dep.meta)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := ms.load(dep.path)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, &value.Dependency{Path: dep.path, Alias: dep.alias, Meta: meta, Module: m})
+	}
+
+	return res, nil
+}
+
+// load compiles the module at the path and the modules it depends on
+func (ms *modules) load(path string) (*value.Module, error) {
+	if m, ok := ms.loaded[path]; ok {
+		return m, nil
+	}
+
+	if ms.loading[path] {
+		return nil, &ModuleError{Path: path, Err: errors.New("import cycle")}
+	}
+
+	ms.loading[path] = true
+	defer delete(ms.loading, path)
+
+	src, err := ms.loader.LoadModule(path)
+	if err != nil {
+		return nil, &ModuleError{Path: path, Err: err}
+	}
+
+	tokens, err := token.Lex(src)
+	if err != nil {
+		return nil, &ModuleError{Path: path, Err: err}
+	}
+
+	prog, err := parseModule(tokens, ms.bigNumbers)
+	if err != nil {
+		return nil, &ModuleError{Path: path, Err: err}
+	}
+
+	m, err := ms.module(prog)
+	if err != nil {
+		var merr *ModuleError
+		if !errors.As(err, &merr) {
+			err = &ModuleError{Path: path, Err: err}
+		}
+
+		return nil, err
+	}
+
+	ms.loaded[path] = m
+	return m, nil
+}
+
+// module compiles the directives of a parsed module
+func (ms *modules) module(prog *program) (m *value.Module, err error) {
+	m = &value.Module{Funcs: prog.fns}
+	if prog.meta != nil {
+		if m.Meta, err = constMeta(token.Token{Pos: prog.meta.Pos}, prog.meta); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.Deps, err = ms.deps(prog.deps); err != nil {
+		return nil, err
+	}
+
+	if err = ms.metas(prog.metas); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// metas loads the modules that are piped into modulemeta, such that the
+// builtin can describe them without loading them when it is run
+func (ms *modules) metas(metas []dependency) error {
+	for _, meta := range metas {
+		if ms.loader == nil {
+			return &ParseError{Tok: meta.tok, Msg: "cannot load modules without a module loader"}
+		}
+
+		if _, err := ms.load(meta.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// constMeta evaluates the metadata of a directive, which must be an
+// object that doesn't depend on its input
+func constMeta(tok token.Token, mc *value.MapConstruct) (value.Map, error) {
+	if mc == nil {
+		return nil, nil
+	}
+
+	out, err := value.Collect(value.Context{}, mc)
+	if err != nil || len(out) != 1 {
+		return nil, &ParseError{Tok: tok, Expr: mc, Msg: "module metadata must be constant"}
+	}
+
+	return out[0].(value.Map), nil
+}
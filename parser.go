@@ -1,6 +1,8 @@
 package jqp
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -8,9 +10,94 @@ import (
 	"github.com/advanderveer/jqp/value"
 )
 
-// Parse the scanned tokens into an expression
-func Parse(input []token.Token) value.Expr {
-	return (&parser{input}).expr()
+// ParseError is returned when the tokens do not form a valid
+// expression. It carries the offending token and the expression
+// that was parsed up to that point, if any.
+type ParseError struct {
+	Tok  token.Token // the offending token
+	Expr value.Expr  // expression parsed so far, may be nil
+	Msg  string      // description of the problem
+}
+
+func (e *ParseError) Error() string {
+	s := "jqp/parser: " + e.Msg + " at position " + strconv.Itoa(e.Tok.Pos)
+	if e.Expr != nil {
+		s += ", expression so far: " + Format(e.Expr)
+	}
+
+	return s
+}
+
+// Parse the scanned tokens into an expression. Queries that import or
+// include modules can only be compiled with a ModuleLoader, see Compile.
+func Parse(input []token.Token) (value.Expr, error) {
+	prog, err := parse(input, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prog.deps) > 0 {
+		return nil, &ParseError{Tok: prog.deps[0].tok, Msg: "cannot import modules without a module loader"}
+	}
+
+	return prog.expr, nil
+}
+
+// program is a parsed query or module: the directives at its start and
+// either the expression of a query or the definitions of a module
+type program struct {
+	meta  *value.MapConstruct
+	deps  []dependency
+	metas []dependency // modules whose path is piped into modulemeta
+	expr  value.Expr
+	fns   []*value.FuncDef
+}
+
+// dependency is an import or include directive
+type dependency struct {
+	tok   token.Token
+	path  string
+	alias string
+	meta  *value.MapConstruct
+}
+
+// parse the tokens of a query, in the big number mode float literals and
+// int literals that overflow are parsed as decimals instead of floats
+func parse(input []token.Token, bigNumbers bool) (*program, error) {
+	p := &parser{rem: input, bigNumbers: bigNumbers}
+	prog, err := p.header()
+	if err != nil {
+		return nil, err
+	}
+
+	if prog.expr, err = p.expr(bpLowest); err != nil {
+		return nil, err
+	}
+
+	prog.metas = p.metas
+
+	if tok := p.peek(); tok.Type != token.EOF {
+		return nil, p.errorf(tok, prog.expr, "unexpected token '"+tok.String()+"'")
+	}
+
+	return prog, nil
+}
+
+// parseModule parses the tokens of a module, which only holds
+// function definitions after its directives
+func parseModule(input []token.Token, bigNumbers bool) (*program, error) {
+	p := &parser{rem: input, bigNumbers: bigNumbers}
+	prog, err := p.header()
+	if err != nil {
+		return nil, err
+	}
+
+	if prog.fns, err = p.funcDefs(); err != nil {
+		return nil, err
+	}
+
+	prog.metas = p.metas
+	return prog, nil
 }
 
 // Format an expression in an unambiguous form for debugging.
@@ -20,20 +107,114 @@ func Format(e interface{}) string {
 		return "<int " + e.String() + ">"
 	case value.Float:
 		return "<float " + e.String() + ">"
+	case value.Decimal:
+		return "<decimal " + e.String() + ">"
 	case value.String:
 		return "<string " + e.String() + ">"
+	case value.Bool:
+		return "<bool " + e.String() + ">"
+	case value.Null:
+		return "<null>"
 	case value.Var:
 		return "<var " + e.String() + ">"
+	case *value.VarRef:
+		return Format(e.Name)
 	case []value.Expr:
 		var s []string
 		for _, ee := range e {
 			s = append(s, Format(ee))
 		}
 		return strings.Join(s, ", ")
+	case *value.Invoke:
+		if len(e.Args) == 0 {
+			return "<func " + e.Name + ">"
+		}
+
+		var s []string
+		for _, arg := range e.Args {
+			s = append(s, Format(arg))
+		}
+		return "(" + e.Name + "(" + strings.Join(s, "; ") + "))"
 	case *value.Call:
 		return "(" + Format(e.Func) + "(" + Format(e.Args) + "))"
 	case *value.Unary:
 		return "(" + e.Op.String() + " " + Format(e.Right) + ")"
+	case *value.Pipe:
+		return "(" + Format(e.Left) + " | " + Format(e.Right) + ")"
+	case *value.Comma:
+		return "(" + Format(e.Left) + " , " + Format(e.Right) + ")"
+	case *value.Iterate:
+		return "(" + Format(e.Left) + "[])"
+	case *value.Slice:
+		var from, to string
+		if e.From != nil {
+			from = Format(e.From)
+		}
+		if e.To != nil {
+			to = Format(e.To)
+		}
+		return "(" + Format(e.Left) + "[" + from + ":" + to + "])"
+	case *value.Interpolate:
+		var s []string
+		for _, part := range e.Parts {
+			s = append(s, Format(part))
+		}
+		return "(" + strings.Join(s, " ~ ") + ")"
+	case *value.ArrayConstruct:
+		if e.Elem == nil {
+			return "[]"
+		}
+		return "[" + Format(e.Elem) + "]"
+	case *value.MapConstruct:
+		var s []string
+		for _, ent := range e.Entries {
+			s = append(s, Format(ent.Key)+": "+Format(ent.Value))
+		}
+		return "{" + strings.Join(s, ", ") + "}"
+	case *value.If:
+		return "(if " + Format(e.Cond) + " then " + Format(e.Then) + " else " + Format(e.Else) + " end)"
+	case *value.Try:
+		if e.Catch == nil {
+			return "(try " + Format(e.Body) + ")"
+		}
+		return "(try " + Format(e.Body) + " catch " + Format(e.Catch) + ")"
+	case *value.Define:
+		return "(" + Format(e.Func) + " " + Format(e.Expr) + ")"
+	case *value.FuncDef:
+		s := "def " + e.Name
+		if len(e.Params) > 0 {
+			s += "(" + strings.Join(e.Params, "; ") + ")"
+		}
+		return s + ": " + Format(e.Body) + ";"
+	case *value.Reduce:
+		return "(reduce " + Format(e.Source) + " as " + Format(e.Pattern) + " (" + Format(e.Init) + "; " + Format(e.Update) + "))"
+	case *value.Foreach:
+		s := "(foreach " + Format(e.Source) + " as " + Format(e.Pattern) + " (" + Format(e.Init) + "; " + Format(e.Update)
+		if e.Extract != nil {
+			s += "; " + Format(e.Extract)
+		}
+		return s + "))"
+	case *value.Bind:
+		return "(" + Format(e.Source) + " as " + Format(e.Pattern) + " | " + Format(e.Body) + ")"
+	case *value.ArrayPattern:
+		var s []string
+		for _, elem := range e.Elems {
+			s = append(s, Format(elem))
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	case *value.MapPattern:
+		var s []string
+		for _, ent := range e.Entries {
+			switch {
+			case ent.Value == nil:
+				s = append(s, Format(ent.Var))
+			case ent.Var != "":
+				s = append(s, Format(ent.Var)+": "+Format(ent.Value))
+			default:
+				s = append(s, Format(ent.Key)+": "+Format(ent.Value))
+			}
+		}
+		return "{" + strings.Join(s, ", ") + "}"
 	case *value.Binary:
 		if e.Op == token.LBrack {
 			return "(" + Format(e.Left) + "[" + Format(e.Right) + "])"
@@ -41,22 +222,28 @@ func Format(e interface{}) string {
 
 		return "(" + Format(e.Left) + " " + e.Op.String() + " " + Format(e.Right) + ")"
 	default:
-		panic("not able to format provided type as expression")
+		return fmt.Sprintf("<unknown %T>", e)
 	}
 }
 
 type parser struct {
-	rem []token.Token
+	rem        []token.Token
+	bigNumbers bool
+	metas      []dependency
 }
 
-func (p *parser) next() (tok token.Token) {
+func (p *parser) errorf(tok token.Token, expr value.Expr, msg string) error {
+	return &ParseError{Tok: tok, Expr: expr, Msg: msg}
+}
+
+func (p *parser) next() (tok token.Token, err error) {
 	tok = p.peek()
 	if tok.Type != token.EOF {
 		p.rem = p.rem[1:]
 	}
 
 	if tok.Type == token.Illegal {
-		panic("jqp/parser: illegal token")
+		return tok, p.errorf(tok, nil, "illegal token")
 	}
 
 	return
@@ -69,176 +256,1149 @@ func (p *parser) peek() token.Token {
 	return p.rem[0]
 }
 
-// expr
-//	[x] operand
-//	[x] operand op expr
-func (p *parser) expr() value.Expr {
-	tok := p.next()
-	expr := p.operand(tok)
+// expect consumes the next token and errors if it is not of the given type
+func (p *parser) expect(tt token.TokenType, expr value.Expr) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
 
-	peeked := p.peek()
-	switch peeked.Type {
-	case token.EOF, token.RParen, token.RBrack, token.Comma:
-		return expr
+	if tok.Type != tt {
+		return p.errorf(tok, expr, "expected '"+tt.String()+"', found: "+tok.String())
 	}
 
-	if peeked.Type.IsOperator() {
+	return nil
+}
+
+// binding powers of the operators, from the loosest to the tightest
+const (
+	bpLowest  = iota
+	bpPipe    // |
+	bpComma   // ,
+	bpAlt     // //
+	bpAssign  // = |= += -= *= /= %= //=
+	bpOr      // or
+	bpAnd     // and
+	bpCompare // == != < <= > >=
+	bpSum     // + -
+	bpProduct // * / %
+	bpPrefix  // - !
+	bpPostfix // . [] ()
+)
+
+// associativity of infix operators
+const (
+	assocLeft = iota
+	assocRight
+	assocNone
+)
+
+// infixOps holds the binding power and associativity of every token
+// that can appear after an operand
+var infixOps = map[token.TokenType]struct{ bp, assoc int }{
+	token.Pipe:      {bpPipe, assocRight},
+	token.Comma:     {bpComma, assocLeft},
+	token.Alt:       {bpAlt, assocRight},
+	token.Assign:    {bpAssign, assocNone},
+	token.Update:    {bpAssign, assocNone},
+	token.AddAssign: {bpAssign, assocNone},
+	token.SubAssign: {bpAssign, assocNone},
+	token.MulAssign: {bpAssign, assocNone},
+	token.QuoAssign: {bpAssign, assocNone},
+	token.RemAssign: {bpAssign, assocNone},
+	token.AltAssign: {bpAssign, assocNone},
+	token.Or:        {bpOr, assocLeft},
+	token.And:       {bpAnd, assocLeft},
+	token.Equal:     {bpCompare, assocNone},
+	token.NotEqual:  {bpCompare, assocNone},
+	token.LT:        {bpCompare, assocNone},
+	token.LTE:       {bpCompare, assocNone},
+	token.GT:        {bpCompare, assocNone},
+	token.GTE:       {bpCompare, assocNone},
+	token.Add:       {bpSum, assocLeft},
+	token.Sub:       {bpSum, assocLeft},
+	token.Mul:       {bpProduct, assocLeft},
+	token.Quo:       {bpProduct, assocLeft},
+	token.Rem:       {bpProduct, assocLeft},
+	token.Dot:       {bpPostfix, assocLeft},
+	token.LBrack:    {bpPostfix, assocLeft},
+	token.LParen:    {bpPostfix, assocLeft},
+	token.As:        {bpPostfix, assocLeft},
+	token.Question:  {bpPostfix, assocLeft},
+}
+
+// expr parses an expression using top down operator precedence: it
+// keeps consuming infix and postfix operators for as long as they bind
+// tighter than 'minBP'.
+func (p *parser) expr(minBP int) (value.Expr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	left, err := p.prefix(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		peeked := p.peek()
+		op, ok := infixOps[peeked.Type]
+		if !ok || op.bp <= minBP {
+			return left, nil
+		}
+
 		p.next()
-		return &value.Binary{
-			Left:  expr,
-			Op:    peeked.Type,
-			Right: p.expr(),
+		if left, err = p.infix(peeked, left, op.bp, op.assoc); err != nil {
+			return nil, err
 		}
 	}
-
-	panic("jqp/parser: unexpected token '" + p.peek().String() + "', expression so far: " + Format(expr))
 }
 
-// operand
-//	[x] op Expr
+// prefix parses the expression that starts with 'tok'
+//
+//	[x] - expr
+//	[x] ! expr
 //	[x] literal
-//	[x] operand [ Expr ]...
-//	[x] operand ( )...
-//	[x] operand . Ident ...
-func (p *parser) operand(tok token.Token) value.Expr {
-
-	// if its an operator, return as unary with the right
-	// set by parsing the remaining tokens as an expression
-	if tok.Type.IsOperator() {
+func (p *parser) prefix(tok token.Token) (value.Expr, error) {
+	switch tok.Type {
+	case token.Sub, token.Not:
+		right, err := p.expr(bpPrefix)
+		if err != nil {
+			return nil, err
+		}
+
 		return &value.Unary{
 			Op:    tok.Type,
-			Right: p.expr(),
-		}
+			Right: right,
+			Pos:   tok.Pos,
+		}, nil
+	default:
+		return p.literal(tok)
 	}
+}
 
-	// else, the operand is a literal or grouped expression
-	var expr value.Expr
+// infix parses the remainder of an expression that has 'left' as its
+// left operand and the operator 'tok'
+//
+//	[x] expr | expr
+//	[x] expr , expr
+//	[x] expr op expr
+//	[x] expr . Ident
+//	[x] expr [ ... ]
+//	[x] expr ( ... )
+//	[x] expr as pattern | expr
+//	[x] expr ?
+func (p *parser) infix(tok token.Token, left value.Expr, bp, assoc int) (value.Expr, error) {
 	switch tok.Type {
-	case token.Int, token.Float, token.String, token.Ident, token.LParen:
-		expr = p.literal(tok)
+	case token.Dot:
+		return p.field(tok, left)
+	case token.LBrack:
+		return p.index(tok, left)
+	case token.LParen:
+		return p.call(tok, left)
+	case token.As:
+		return p.bind(tok, left)
+	case token.Question:
+		return &value.Try{Body: left, Pos: tok.Pos}, nil
 	}
 
-	// check if the current operant has an index, call or field operator
-	// following it. If so keep adding operator expresisons until it is
-	// stable.
-	last := expr
-	for {
-		expr = p.index(last)
-		expr = p.call(expr)
-		expr = p.field(expr)
-		if last == expr {
-			return expr
+	// right associative operators allow operators of the same binding
+	// power to be parsed as part of the right side
+	rbp := bp
+	if assoc == assocRight {
+		rbp--
+	}
+
+	right, err := p.expr(rbp)
+	if err != nil {
+		return nil, err
+	}
+
+	var expr value.Expr
+	switch tok.Type {
+	case token.Pipe:
+		p.moduleMeta(tok, left, right)
+		expr = &value.Pipe{
+			Left:  left,
+			Right: right,
+			Pos:   tok.Pos,
 		}
+	case token.Comma:
+		expr = &value.Comma{
+			Left:  left,
+			Right: right,
+			Pos:   tok.Pos,
+		}
+	default:
+		expr = &value.Binary{
+			Left:  left,
+			Op:    tok.Type,
+			Right: right,
+			Pos:   tok.Pos,
+		}
+	}
 
-		last = expr
+	// non associative operators cannot be followed by an operator
+	// of the same binding power
+	if assoc == assocNone {
+		if next := p.peek(); infixOps[next.Type].bp == bp {
+			return nil, p.errorf(next, expr, "operator '"+next.Type.String()+"' is not associative with '"+tok.Type.String()+"'")
+		}
 	}
+
+	return expr, nil
 }
 
-func (p *parser) index(expr value.Expr) value.Expr {
-	for p.peek().Type == token.LBrack {
+// index
+//
+//	[x] [ ]
+//	[x] [ expr ]
+//	[x] [ expr : expr ]
+//	[x] [ expr : ]
+//	[x] [ : expr ]
+func (p *parser) index(tok token.Token, expr value.Expr) (value.Expr, error) {
+	if p.peek().Type == token.RBrack {
 		p.next()
-		index := p.expr()
-		tok := p.next()
-		if tok.Type != token.RBrack {
-			panic("jqp/parser: expected right bracket, found: " + tok.String())
+		return &value.Iterate{
+			Left: expr,
+			Pos:  tok.Pos,
+		}, nil
+	}
+
+	var index value.Expr
+	var err error
+	if p.peek().Type != token.Colon {
+		if index, err = p.expr(bpLowest); err != nil {
+			return nil, err
 		}
+	}
 
-		expr = &value.Binary{
-			Op:    token.LBrack,
-			Left:  expr,
-			Right: index,
+	if p.peek().Type == token.Colon {
+		p.next()
+
+		var to value.Expr
+		if p.peek().Type != token.RBrack {
+			if to, err = p.expr(bpLowest); err != nil {
+				return nil, err
+			}
 		}
+
+		if err = p.expect(token.RBrack, expr); err != nil {
+			return nil, err
+		}
+
+		return &value.Slice{
+			Left: expr,
+			From: index,
+			To:   to,
+			Pos:  tok.Pos,
+		}, nil
 	}
 
-	return expr
+	if err = p.expect(token.RBrack, expr); err != nil {
+		return nil, err
+	}
+
+	return &value.Binary{
+		Op:    token.LBrack,
+		Left:  expr,
+		Right: index,
+		Pos:   tok.Pos,
+	}, nil
 }
 
-func (p *parser) field(expr value.Expr) value.Expr {
-	for p.peek().Type == token.Dot {
-		p.next() //the dot
+// field
+//
+//	[x] . Ident
+//	[x] .keyword
+func (p *parser) field(dot token.Token, expr value.Expr) (value.Expr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
 
-		tok := p.next()
-		switch tok.Type {
-		case token.Ident:
-			expr = &value.Binary{
-				Op:    token.Dot,
-				Left:  expr,
-				Right: value.String(tok.Text),
+	if !isFieldName(dot, tok) {
+		return nil, p.errorf(tok, expr, "expected identifier after dot, got: "+tok.String())
+	}
+
+	return &value.Binary{
+		Op:    token.Dot,
+		Left:  expr,
+		Right: value.String(tok.Text),
+		Pos:   dot.Pos,
+	}, nil
+}
+
+// bind parses the variable binding of the source expression, the body
+// extends as far to the right as possible
+//
+//	[x] expr as pattern | expr
+func (p *parser) bind(tok token.Token, source value.Expr) (value.Expr, error) {
+	pattern, err := p.pattern(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(token.Pipe, source); err != nil {
+		return nil, err
+	}
+
+	body, err := p.expr(bpLowest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &value.Bind{
+		Source:  source,
+		Pattern: pattern,
+		Body:    body,
+		Pos:     tok.Pos,
+	}, nil
+}
+
+// pattern destructures a value into variables, 'expr' is the expression
+// that is reported when the pattern is invalid
+//
+//	[x] $var
+//	[x] [ pattern, ... ]
+//	[x] { $var, ... }
+//	[x] { $var : pattern, ... }
+//	[x] { Ident : pattern, ... }
+//	[x] { keyword : pattern, ... }
+//	[x] { string : pattern, ... }
+//	[x] { ( expr ) : pattern, ... }
+func (p *parser) pattern(expr value.Expr) (value.Pattern, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tok.Type == token.Ident && strings.HasPrefix(tok.Text, "$"):
+		if len(tok.Text) == 1 {
+			return nil, p.errorf(tok, expr, "input cannot be bound as variable")
+		}
+
+		return value.Var(tok.Text), nil
+	case tok.Type == token.LBrack:
+		return p.arrayPattern(tok, expr)
+	case tok.Type == token.LBrace:
+		return p.mapPattern(tok, expr)
+	}
+
+	return nil, p.errorf(tok, expr, "expected variable, array or object pattern, got: "+tok.String())
+}
+
+// array destructuring
+//
+//	[x] [ pattern, ... ]
+func (p *parser) arrayPattern(tok token.Token, expr value.Expr) (value.Pattern, error) {
+	ap := &value.ArrayPattern{Pos: tok.Pos}
+	for {
+		elem, err := p.pattern(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		ap.Elems = append(ap.Elems, elem)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch end.Type {
+		case token.RBrack:
+			return ap, nil
+		case token.Comma:
+		default:
+			return nil, p.errorf(end, expr, "expected ',' or ']', found: "+end.String())
+		}
+	}
+}
+
+// object destructuring, the key of a $var entry is the name of the
+// variable which is also bound to the value
+//
+//	[x] { $var, ... }
+//	[x] { $var : pattern, ... }
+//	[x] { key : pattern, ... }
+func (p *parser) mapPattern(tok token.Token, expr value.Expr) (value.Pattern, error) {
+	mp := &value.MapPattern{Pos: tok.Pos}
+	for {
+		ktok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry value.MapPatternEntry
+		switch {
+		case ktok.Type == token.Ident && strings.HasPrefix(ktok.Text, "$"):
+			if len(ktok.Text) == 1 {
+				return nil, p.errorf(ktok, expr, "input cannot be bound as variable")
+			}
+
+			entry.Key = value.String(ktok.Text[1:])
+			entry.Var = value.Var(ktok.Text)
+		case ktok.Type == token.Ident, ktok.Type.IsKeyword(), ktok.Type == token.String:
+			entry.Key = value.String(ktok.Text)
+		case ktok.Type == token.LParen:
+			if entry.Key, err = p.expr(bpLowest); err != nil {
+				return nil, err
+			}
+
+			if err = p.expect(token.RParen, expr); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf(ktok, expr, "unexpected object pattern key, got: "+ktok.String())
+		}
+
+		// only a $var entry can leave out the pattern of its value
+		if entry.Var == "" || p.peek().Type == token.Colon {
+			if err = p.expect(token.Colon, expr); err != nil {
+				return nil, err
+			}
+
+			if entry.Value, err = p.pattern(expr); err != nil {
+				return nil, err
 			}
+		}
 
+		mp.Entries = append(mp.Entries, entry)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch end.Type {
+		case token.RBrace:
+			return mp, nil
+		case token.Comma:
 		default:
-			panic("expected identifier after dot, got: " + tok.String())
+			return nil, p.errorf(end, expr, "expected ',' or '}', found: "+end.String())
 		}
 	}
+}
 
-	return expr
+// isFieldName reports whether 'tok' can be the name of a field after the
+// dot, keywords can only be a field name if there is no space in between
+// such that '. and .a' is not parsed as a field.
+func isFieldName(dot, tok token.Token) bool {
+	return tok.Type == token.Ident || (tok.Type.IsKeyword() && tok.Pos == dot.Pos+1)
 }
 
-// call
-//  [x] ()
-//  [x] (x, ...)
-func (p *parser) call(expr value.Expr) value.Expr {
-	for p.peek().Type == token.LParen {
+// call parses arguments that are separated by single commas, as such
+// the arguments themselves bind tighter than the comma operator
+//
+//	[x] ()
+//	[x] (x, ...)
+func (p *parser) call(tok token.Token, expr value.Expr) (value.Expr, error) {
+	call := &value.Call{Func: expr, Pos: tok.Pos}
+	if p.peek().Type == token.RParen {
 		p.next()
+		return call, nil
+	}
 
-		// start parsing arguments
-		var args []value.Expr
-		for {
-			peeked := p.peek()
-			if peeked.Type == token.RParen {
-				p.next()
-				break
-			} else if p.peek().Type == token.Comma {
-				p.next()
-				continue
-			}
+	for {
+		if peeked := p.peek(); peeked.Type == token.EOF {
+			return nil, p.errorf(peeked, expr, "unterminated call arguments")
+		} else if peeked.Type == token.Comma || peeked.Type == token.RParen {
+			return nil, p.errorf(peeked, expr, "expected call argument, found: "+peeked.String())
+		}
 
-			args = append(args, p.expr())
+		arg, err := p.expr(bpComma)
+		if err != nil {
+			return nil, err
 		}
 
-		expr = &value.Call{
-			Func: expr,
-			Args: args,
+		call.Args = append(call.Args, arg)
+		end := p.peek()
+		switch end.Type {
+		case token.RParen:
+			p.next()
+			return call, nil
+		case token.Comma:
+			p.next()
+		case token.EOF:
+			return nil, p.errorf(end, expr, "unterminated call arguments")
+		default:
+			return nil, p.errorf(end, expr, "expected ',' or ')', found: "+end.String())
 		}
 	}
-
-	return expr
 }
 
 // literal
-//  [x] var
-// 	[x] string
-// 	[x] int
+//
+//	[x] $var
+//	[x] function
+//	[x] string
+//	[x] int
 //	[x] float
-//  [x] '(' expr ')'
-func (p *parser) literal(tok token.Token) value.Expr {
+//	[x] true, false
+//	[x] null
+//	[x] '(' expr ')'
+//	[x] '.' as the input
+//	[x] '.' Ident as a field of the input
+//	[x] '"' ... '"' as string interpolation
+//	[x] '[' expr ']' as array construction
+//	[x] '{' ... '}' as object construction
+//	[x] if ... then ... elif ... else ... end
+//	[x] try expr catch expr
+//	[x] reduce term as pattern ( expr ; expr )
+//	[x] foreach term as pattern ( expr ; expr ; expr )
+//	[x] '..' as recurse
+//	[x] def name: expr; expr
+func (p *parser) literal(tok token.Token) (value.Expr, error) {
 	switch tok.Type {
+	case token.Dot:
+		if isFieldName(tok, p.peek()) {
+			return p.field(tok, value.Input)
+		}
+
+		return value.Input, nil
 	case token.Ident:
-		return value.Var(tok.Text)
+		if tok.Text == string(value.Input) {
+			return value.Input, nil
+		} else if strings.HasPrefix(tok.Text, "$") {
+			return &value.VarRef{Name: value.Var(tok.Text), Pos: tok.Pos}, nil
+		}
+
+		return p.invoke(tok)
+	case token.True:
+		return value.Bool(true), nil
+	case token.False:
+		return value.Bool(false), nil
+	case token.Null:
+		return value.Null{}, nil
 	case token.String:
-		return value.String(tok.Text)
+		return value.String(tok.Text), nil
 	case token.Int:
 		i64, err := strconv.ParseInt(tok.Text, 10, 64)
-		if err != nil {
-			panic("jqp/parser: couldn't parse literal token as int: " + err.Error())
+		if errors.Is(err, strconv.ErrRange) {
+			return p.literal(token.Token{Type: token.Float, Text: tok.Text, Pos: tok.Pos})
+		} else if err != nil {
+			return nil, p.errorf(tok, nil, "couldn't parse literal token as int: "+err.Error())
 		}
 
-		return value.Int(i64)
+		return value.Int(i64), nil
 	case token.Float:
-		f64, err := strconv.ParseFloat(tok.Text, 64)
+		if p.bigNumbers {
+			d, err := value.ParseDecimal(tok.Text)
+			if err != nil {
+				return nil, p.errorf(tok, nil, "couldn't parse literal token as decimal: "+strings.TrimPrefix(err.Error(), "jqp/value: "))
+			}
+
+			return d, nil
+		}
+
+		f, err := value.ParseFloat(tok.Text)
 		if err != nil {
-			panic("jqp/parser: couldn't parse literal token as float: " + err.Error())
+			return nil, p.errorf(tok, nil, "couldn't parse literal token as float: "+err.Error())
 		}
 
-		return value.Float(f64)
+		return f, nil
 	case token.LParen:
-		expr := p.expr()
-		tok := p.next()
-		if tok.Type != token.RParen {
-			panic("jqp/parser: expected right parentheses, found: " + tok.String())
+		expr, err := p.expr(bpLowest)
+		if err != nil {
+			return nil, err
 		}
-		return expr
+
+		if err = p.expect(token.RParen, expr); err != nil {
+			return nil, err
+		}
+
+		return expr, nil
+	case token.InterpBeg:
+		return p.interpolate(tok)
+	case token.LBrack:
+		return p.array(tok)
+	case token.LBrace:
+		return p.object(tok)
+	case token.If:
+		return p.conditional(tok)
+	case token.Try:
+		return p.try(tok)
+	case token.Reduce, token.Foreach:
+		return p.fold(tok)
+	case token.DotDot:
+		return &value.Invoke{Name: "recurse", Pos: tok.Pos}, nil
+	case token.Def:
+		return p.define(tok)
+	}
+
+	return nil, p.errorf(tok, nil, "unexpected token in literal, got: "+tok.String())
+}
+
+// conditional, the else branch is optional and defaults to the input.
+// An elif is parsed as a conditional in the else branch.
+//
+//	[x] if expr then expr end
+//	[x] if expr then expr else expr end
+//	[x] if expr then expr elif expr then expr ... end
+func (p *parser) conditional(tok token.Token) (value.Expr, error) {
+	cond, err := p.expr(bpLowest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(token.Then, cond); err != nil {
+		return nil, err
+	}
+
+	then, err := p.expr(bpLowest)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &value.If{Cond: cond, Then: then, Else: value.Input, Pos: tok.Pos}
+	end, err := p.next()
+	if err != nil {
+		return nil, err
 	}
 
-	panic("unexpected token in literal, got: " + tok.String())
+	switch end.Type {
+	case token.Elif:
+		if e.Else, err = p.conditional(end); err != nil {
+			return nil, err
+		}
+	case token.Else:
+		if e.Else, err = p.expr(bpLowest); err != nil {
+			return nil, err
+		}
+
+		if err = p.expect(token.End, e); err != nil {
+			return nil, err
+		}
+	case token.End:
+	default:
+		return nil, p.errorf(end, e, "expected 'elif', 'else' or 'end', found: "+end.String())
+	}
+
+	return e, nil
+}
+
+// try, the body and the catch bind as tight as a prefix operator such
+// that 'try .a catch . | length' pipes the outcome of the try
+//
+//	[x] try expr
+//	[x] try expr catch expr
+func (p *parser) try(tok token.Token) (value.Expr, error) {
+	body, err := p.expr(bpPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &value.Try{Body: body, Pos: tok.Pos}
+	if p.peek().Type != token.Catch {
+		return t, nil
+	}
+
+	p.next()
+	if t.Catch, err = p.expr(bpPrefix); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// term parses an operand and its postfix operators, except for 'as'
+// such that it can be the source of reduce and foreach
+func (p *parser) term() (value.Expr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.literal(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		peeked := p.peek()
+		if peeked.Type == token.As || infixOps[peeked.Type].bp != bpPostfix {
+			return expr, nil
+		}
+
+		p.next()
+		if expr, err = p.infix(peeked, expr, bpPostfix, assocLeft); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fold parses reduce and foreach, the arguments are separated by
+// semicolons and the extract of foreach is optional
+//
+//	[x] reduce term as pattern ( expr ; expr )
+//	[x] foreach term as pattern ( expr ; expr )
+//	[x] foreach term as pattern ( expr ; expr ; expr )
+func (p *parser) fold(tok token.Token) (value.Expr, error) {
+	source, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(token.As, source); err != nil {
+		return nil, err
+	}
+
+	pattern, err := p.pattern(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(token.LParen, source); err != nil {
+		return nil, err
+	}
+
+	var args []value.Expr
+	for {
+		arg, err := p.expr(bpLowest)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if end.Type == token.RParen {
+			break
+		} else if end.Type != token.Semicolon {
+			return nil, p.errorf(end, arg, "expected ';' or ')', found: "+end.String())
+		}
+	}
+
+	switch {
+	case tok.Type == token.Reduce && len(args) == 2:
+		return &value.Reduce{
+			Source:  source,
+			Pattern: pattern,
+			Init:    args[0],
+			Update:  args[1],
+			Pos:     tok.Pos,
+		}, nil
+	case tok.Type == token.Foreach && (len(args) == 2 || len(args) == 3):
+		fe := &value.Foreach{
+			Source:  source,
+			Pattern: pattern,
+			Init:    args[0],
+			Update:  args[1],
+			Pos:     tok.Pos,
+		}
+
+		if len(args) == 3 {
+			fe.Extract = args[2]
+		}
+
+		return fe, nil
+	default:
+		return nil, p.errorf(tok, source, tok.Type.String()+" doesn't take "+strconv.Itoa(len(args))+" arguments")
+	}
+}
+
+// header parses the directives at the start of a query or module, the
+// metadata are constant objects
+//
+//	[x] module { ... } ;
+//	[x] import string as Ident ;
+//	[x] import string as Ident { ... } ;
+//	[x] include string ;
+//	[x] include string { ... } ;
+func (p *parser) header() (*program, error) {
+	prog := &program{}
+	if p.peek().Type == token.Module {
+		p.next()
+		meta, err := p.meta()
+		if err != nil {
+			return nil, err
+		} else if meta == nil {
+			return nil, p.errorf(p.peek(), nil, "expected module metadata, found: "+p.peek().String())
+		}
+
+		prog.meta = meta
+		if err = p.expect(token.Semicolon, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for p.peek().Type == token.Import || p.peek().Type == token.Include {
+		tok, _ := p.next()
+		path, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Type != token.String {
+			return nil, p.errorf(path, nil, "expected module path, found: "+path.String())
+		}
+
+		dep := dependency{tok: tok, path: path.Text}
+		if tok.Type == token.Import {
+			if err = p.expect(token.As, nil); err != nil {
+				return nil, err
+			}
+
+			alias, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if alias.Type != token.Ident || strings.HasPrefix(alias.Text, "$") || strings.Contains(alias.Text, "::") {
+				return nil, p.errorf(alias, nil, "expected module name, found: "+alias.String())
+			}
+
+			dep.alias = alias.Text
+		}
+
+		if dep.meta, err = p.meta(); err != nil {
+			return nil, err
+		}
+
+		if err = p.expect(token.Semicolon, nil); err != nil {
+			return nil, err
+		}
+
+		prog.deps = append(prog.deps, dep)
+	}
+
+	return prog, nil
+}
+
+// meta parses the optional metadata object of a directive
+func (p *parser) meta() (*value.MapConstruct, error) {
+	if p.peek().Type != token.LBrace {
+		return nil, nil
+	}
+
+	tok, _ := p.next()
+	obj, err := p.object(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.(*value.MapConstruct), nil
+}
+
+// define parses a function definition and the expression that follows
+// it, in which the function is defined
+//
+//	[x] def name: expr; expr
+func (p *parser) define(tok token.Token) (value.Expr, error) {
+	fn, err := p.funcDef(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.expr(bpLowest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &value.Define{Func: fn, Expr: expr}, nil
+}
+
+// funcDef parses a function definition, the parameters are separated
+// by semicolons. Parameters that start with a '$' take values, the
+// others take filters.
+//
+//	[x] def name: expr;
+//	[x] def name(param; ...): expr;
+func (p *parser) funcDef(tok token.Token) (*value.FuncDef, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if name.Type != token.Ident || strings.HasPrefix(name.Text, "$") {
+		return nil, p.errorf(name, nil, "expected function name, got: "+name.String())
+	}
+
+	fn := &value.FuncDef{Name: name.Text, Pos: tok.Pos}
+	if p.peek().Type == token.LParen {
+		p.next()
+		for {
+			param, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if param.Type != token.Ident || param.Text == "$" {
+				return nil, p.errorf(param, nil, "expected parameter name, got: "+param.String())
+			}
+
+			fn.Params = append(fn.Params, param.Text)
+			end, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+
+			if end.Type == token.RParen {
+				break
+			} else if end.Type != token.Semicolon {
+				return nil, p.errorf(end, nil, "expected ';' or ')', found: "+end.String())
+			}
+		}
+	}
+
+	if err = p.expect(token.Colon, nil); err != nil {
+		return nil, err
+	}
+
+	if fn.Body, err = p.expr(bpLowest); err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(token.Semicolon, fn.Body); err != nil {
+		return nil, err
+	}
+
+	return fn, nil
+}
+
+// funcDefs parses definitions until the end of the input, such as
+// a library of functions
+//
+//	[x] def name: expr; ...
+func (p *parser) funcDefs() ([]*value.FuncDef, error) {
+	var fns []*value.FuncDef
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.Type {
+		case token.EOF:
+			return fns, nil
+		case token.Def:
+			fn, err := p.funcDef(tok)
+			if err != nil {
+				return nil, err
+			}
+
+			fns = append(fns, fn)
+		default:
+			return nil, p.errorf(tok, nil, "expected function definition, got: "+tok.String())
+		}
+	}
+}
+
+// objectValue parses the value of an object entry, it can be a pipe but
+// not a comma as that separates the entries
+//
+//	[x] { key: expr | expr | ... }
+func (p *parser) objectValue() (value.Expr, error) {
+	left, err := p.expr(bpComma)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.Type != token.Pipe {
+		return left, nil
+	}
+
+	p.next()
+	right, err := p.objectValue()
+	if err != nil {
+		return nil, err
+	}
+
+	p.moduleMeta(tok, left, right)
+	return &value.Pipe{Left: left, Right: right, Pos: tok.Pos}, nil
+}
+
+// moduleMeta records the path of a module that is piped into the
+// modulemeta builtin as a string literal, as in '"lib/util" | modulemeta',
+// such that the module is loaded when the query is compiled
+func (p *parser) moduleMeta(tok token.Token, left, right value.Expr) {
+	path, ok := left.(value.String)
+	if !ok {
+		return
+	}
+
+	for {
+		rp, ok := right.(*value.Pipe)
+		if !ok {
+			break
+		}
+
+		right = rp.Left
+	}
+
+	if inv, ok := right.(*value.Invoke); ok && inv.Name == "modulemeta" && len(inv.Args) == 0 {
+		p.metas = append(p.metas, dependency{tok: tok, path: string(path)})
+	}
+}
+
+// function invocation, the arguments are separated by semicolons
+// such that they can be any expression
+//
+//	[x] Ident
+//	[x] Ident ( expr ; ... )
+func (p *parser) invoke(tok token.Token) (value.Expr, error) {
+	inv := &value.Invoke{Name: tok.Text, Pos: tok.Pos}
+	if p.peek().Type != token.LParen {
+		return inv, nil
+	}
+
+	p.next()
+	for {
+		arg, err := p.expr(bpLowest)
+		if err != nil {
+			return nil, err
+		}
+
+		inv.Args = append(inv.Args, arg)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch end.Type {
+		case token.RParen:
+			return inv, nil
+		case token.Semicolon:
+		default:
+			return nil, p.errorf(end, inv, "expected ';' or ')', found: "+end.String())
+		}
+	}
+}
+
+// string interpolation
+//
+//	[x] "...\( expr )...\( expr )..."
+func (p *parser) interpolate(tok token.Token) (value.Expr, error) {
+	ip := &value.Interpolate{Parts: []value.Expr{value.String(tok.Text)}, Pos: tok.Pos}
+	for {
+		expr, err := p.expr(bpLowest)
+		if err != nil {
+			return nil, err
+		}
+
+		ip.Parts = append(ip.Parts, expr)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch end.Type {
+		case token.InterpMid:
+			ip.Parts = append(ip.Parts, value.String(end.Text))
+		case token.InterpEnd:
+			ip.Parts = append(ip.Parts, value.String(end.Text))
+			return ip, nil
+		default:
+			return nil, p.errorf(end, ip, "expected end of interpolation, found: "+end.String())
+		}
+	}
+}
+
+// array construction
+//
+//	[x] [ ]
+//	[x] [ expr ]
+func (p *parser) array(tok token.Token) (value.Expr, error) {
+	ac := &value.ArrayConstruct{Pos: tok.Pos}
+	if p.peek().Type == token.RBrack {
+		p.next()
+		return ac, nil
+	}
+
+	elem, err := p.expr(bpLowest)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.Elem = elem
+	if err = p.expect(token.RBrack, ac); err != nil {
+		return nil, err
+	}
+
+	return ac, nil
+}
+
+// object construction, the values bind tighter than the comma that
+// separates the entries
+//
+//	[x] { }
+//	[x] { Ident : expr, ... }
+//	[x] { keyword : expr, ... }
+//	[x] { string : expr, ... }
+//	[x] { ( expr ) : expr, ... }
+//	[x] { $var, ... } as { var: $var }
+//	[x] { Ident, ... } as { Ident: .Ident }
+func (p *parser) object(tok token.Token) (value.Expr, error) {
+	mc := &value.MapConstruct{Pos: tok.Pos}
+	for {
+		ktok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if ktok.Type == token.RBrace && len(mc.Entries) == 0 {
+			return mc, nil
+		}
+
+		var entry value.MapEntry
+		switch {
+		case ktok.Type == token.Ident && strings.HasPrefix(ktok.Text, "$"):
+			if len(ktok.Text) == 1 {
+				return nil, p.errorf(ktok, mc, "input cannot be used as object key shorthand")
+			}
+
+			entry.Key = value.String(ktok.Text[1:])
+			entry.Value = &value.VarRef{Name: value.Var(ktok.Text), Pos: ktok.Pos}
+		case ktok.Type == token.Ident, ktok.Type.IsKeyword():
+			entry.Key = value.String(ktok.Text)
+			entry.Value = &value.Binary{
+				Op:    token.Dot,
+				Left:  value.Input,
+				Right: entry.Key,
+				Pos:   ktok.Pos,
+			}
+		case ktok.Type == token.String:
+			entry.Key = value.String(ktok.Text)
+		case ktok.Type == token.LParen:
+			if entry.Key, err = p.expr(bpLowest); err != nil {
+				return nil, err
+			}
+
+			if err = p.expect(token.RParen, mc); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf(ktok, mc, "unexpected object key, got: "+ktok.String())
+		}
+
+		// the value is optional for the shorthands that already set it
+		if entry.Value == nil || p.peek().Type == token.Colon {
+			if err = p.expect(token.Colon, mc); err != nil {
+				return nil, err
+			}
+
+			if entry.Value, err = p.objectValue(); err != nil {
+				return nil, err
+			}
+		}
+
+		mc.Entries = append(mc.Entries, entry)
+		end, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch end.Type {
+		case token.RBrace:
+			return mc, nil
+		case token.Comma:
+		default:
+			return nil, p.errorf(end, mc, "expected ',' or '}', found: "+end.String())
+		}
+	}
 }
@@ -15,10 +15,15 @@ func TestExprFormating(t *testing.T) {
 		tokens []token.Token
 		expr   string
 	}{
+		{[]token.Token{
+			{Type: token.Ident, Text: "$foo"},
+			{Type: token.EOF},
+		}, `<var $foo>`},
+
 		{[]token.Token{
 			{Type: token.Ident, Text: "foo"},
 			{Type: token.EOF},
-		}, `<var foo>`},
+		}, `<func foo>`},
 
 		{[]token.Token{
 			{Type: token.String, Text: "foo"},
@@ -35,6 +40,15 @@ func TestExprFormating(t *testing.T) {
 			{Type: token.EOF},
 		}, `<float 1.5E+00>`},
 
+		{[]token.Token{
+			{Type: token.True},
+			{Type: token.Comma},
+			{Type: token.False},
+			{Type: token.Comma},
+			{Type: token.Null},
+			{Type: token.EOF},
+		}, `((<bool true> , <bool false>) , <null>)`},
+
 		{[]token.Token{
 			{Type: token.Not},
 			{Type: token.Int, Text: "1"},
@@ -48,12 +62,65 @@ func TestExprFormating(t *testing.T) {
 			{Type: token.EOF},
 		}, `(<int 1> + <int 2>)`},
 
-		// {[]token.Token{
-		// 	{Type: token.Int, Text: "1"},
-		// 	{Type: token.Comma},
-		// 	{Type: token.Int, Text: "2"},
-		// 	{Type: token.EOF},
-		// }, `(<int 1> , <int 2>)`},
+		{[]token.Token{
+			{Type: token.Int, Text: "1"},
+			{Type: token.Comma},
+			{Type: token.Int, Text: "2"},
+			{Type: token.EOF},
+		}, `(<int 1> , <int 2>)`},
+
+		// comma binds tighter than pipe: 1, 2 | $, 3
+		{[]token.Token{
+			{Type: token.Int, Text: "1"},
+			{Type: token.Comma},
+			{Type: token.Int, Text: "2"},
+			{Type: token.Pipe},
+			{Type: token.Ident, Text: "$"},
+			{Type: token.Comma},
+			{Type: token.Int, Text: "3"},
+			{Type: token.EOF},
+		}, `((<int 1> , <int 2>) | (<var $> , <int 3>))`},
+
+		// comma in an index: $[0, 1]
+		{[]token.Token{
+			{Type: token.Ident, Text: "$"},
+			{Type: token.LBrack},
+			{Type: token.Int, Text: "0"},
+			{Type: token.Comma},
+			{Type: token.Int, Text: "1"},
+			{Type: token.RBrack},
+			{Type: token.EOF},
+		}, `(<var $>[(<int 0> , <int 1>)])`},
+
+		// iteration: $.foo[]
+		{[]token.Token{
+			{Type: token.Ident, Text: "$"},
+			{Type: token.Dot},
+			{Type: token.Ident, Text: "foo"},
+			{Type: token.LBrack},
+			{Type: token.RBrack},
+			{Type: token.EOF},
+		}, `((<var $> . <string foo>)[])`},
+
+		// slicing: $[1:3][:-2][2:]
+		{[]token.Token{
+			{Type: token.Ident, Text: "$"},
+			{Type: token.LBrack},
+			{Type: token.Int, Text: "1"},
+			{Type: token.Colon},
+			{Type: token.Int, Text: "3"},
+			{Type: token.RBrack},
+			{Type: token.LBrack},
+			{Type: token.Colon},
+			{Type: token.Sub},
+			{Type: token.Int, Text: "2"},
+			{Type: token.RBrack},
+			{Type: token.LBrack},
+			{Type: token.Int, Text: "2"},
+			{Type: token.Colon},
+			{Type: token.RBrack},
+			{Type: token.EOF},
+		}, `(((<var $>[<int 1>:<int 3>])[:(- <int 2>)])[<int 2>:])`},
 
 		// deep indexing: $[foo][bar][rab]
 		{[]token.Token{
@@ -183,15 +250,48 @@ func TestExprFormating(t *testing.T) {
 			{Type: token.String, Text: "arg1"},
 			{Type: token.RParen},
 		}, `((<var $> . <string foo>)(<string arg1>))`},
+
+		// pipelines: $.foo | $[0] | $
+		{[]token.Token{
+			{Type: token.Ident, Text: "$"},
+			{Type: token.Dot},
+			{Type: token.Ident, Text: "foo"},
+			{Type: token.Pipe},
+			{Type: token.Ident, Text: "$"},
+			{Type: token.LBrack},
+			{Type: token.Int, Text: "0"},
+			{Type: token.RBrack},
+			{Type: token.Pipe},
+			{Type: token.Ident, Text: "$"},
+		}, `((<var $> . <string foo>) | ((<var $>[<int 0>]) | <var $>))`},
+
+		// pipe has the lowest precedence: $ + 1 | $
+		{[]token.Token{
+			{Type: token.Ident, Text: "$"},
+			{Type: token.Add},
+			{Type: token.Int, Text: "1"},
+			{Type: token.Pipe},
+			{Type: token.Ident, Text: "$"},
+		}, `((<var $> + <int 1>) | <var $>)`},
+
+		// pipe in grouping and jq style input: (.foo | .) + 1
+		{[]token.Token{
+			{Type: token.LParen},
+			{Type: token.Dot},
+			{Type: token.Ident, Text: "foo"},
+			{Type: token.Pipe},
+			{Type: token.Dot},
+			{Type: token.RParen},
+			{Type: token.Add},
+			{Type: token.Int, Text: "1"},
+		}, `(((<var $> . <string foo>) | <var $>) + <int 1>)`},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			defer func() {
-				if r := recover(); r != nil {
-					t.Fatalf("panic while parsing tokens '%s':\n\t %v", c.tokens, r)
-				}
-			}()
+			res, err := jqp.Parse(c.tokens)
+			if err != nil {
+				t.Fatalf("failed to parse tokens '%s': %v", c.tokens, err)
+			}
 
-			res := jqp.Parse(c.tokens)
 			if jqp.Format(res) != c.expr {
 				t.Fatalf("tokens '%s' should result in expr: \n\t %s got: \n\t %s", c.tokens, c.expr, jqp.Format(res))
 			}
@@ -310,16 +410,177 @@ func TestExprParsing(t *testing.T) {
 			Right: value.String("foobar")}},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			defer func() {
-				if r := recover(); r != nil {
-					t.Fatalf("panic: %v", r)
-				}
-			}()
+			res, err := jqp.Parse(c.tokens)
+			if err != nil {
+				t.Fatalf("failed to parse tokens '%s': %v", c.tokens, err)
+			}
 
-			res := jqp.Parse(c.tokens)
 			if !reflect.DeepEqual(res, c.expr) {
 				t.Fatalf("tokens '%s' should result in expr: \n\t %v got: \n\t %v", c.tokens, jqp.Format(c.expr), jqp.Format(res))
 			}
 		})
 	}
 }
+
+func TestExprPrecedence(t *testing.T) {
+	for i, c := range []struct {
+		query string
+		expr  string
+	}{
+		// associativity
+		{`1 - 2 - 3`, `((<int 1> - <int 2>) - <int 3>)`},
+		{`1 / 2 * 3 % 4`, `(((<int 1> / <int 2>) * <int 3>) % <int 4>)`},
+		{`1, 2, 3`, `((<int 1> , <int 2>) , <int 3>)`},
+		{`1 | 2 | 3`, `(<int 1> | (<int 2> | <int 3>))`},
+		{`1 // 2 // 3`, `(<int 1> // (<int 2> // <int 3>))`},
+		{`1 and 2 and 3`, `((<int 1> and <int 2>) and <int 3>)`},
+		{`1 or 2 or 3`, `((<int 1> or <int 2>) or <int 3>)`},
+
+		// binding power
+		{`1 + 2 * 3`, `(<int 1> + (<int 2> * <int 3>))`},
+		{`1 * 2 + 3`, `((<int 1> * <int 2>) + <int 3>)`},
+		{`(1 + 2) * 3`, `((<int 1> + <int 2>) * <int 3>)`},
+		{`1 + 2 > 3 - 4`, `((<int 1> + <int 2>) > (<int 3> - <int 4>))`},
+		{`1 > 2 and 3 < 4 or 5 == 6`, `(((<int 1> > <int 2>) and (<int 3> < <int 4>)) or (<int 5> == <int 6>))`},
+		{`1 or 2 and 3`, `(<int 1> or (<int 2> and <int 3>))`},
+		{`1 or 2 // 3`, `((<int 1> or <int 2>) // <int 3>)`},
+		{`1 // 2, 3`, `((<int 1> // <int 2>) , <int 3>)`},
+		{`1, 2 | 3, 4`, `((<int 1> , <int 2>) | (<int 3> , <int 4>))`},
+
+		// prefix and postfix operators
+		{`-1 + 2`, `((- <int 1>) + <int 2>)`},
+		{`-$.a * 2`, `((- (<var $> . <string a>)) * <int 2>)`},
+		{`!$.a == 1`, `((! (<var $> . <string a>)) == <int 1>)`},
+		{`1 - -2`, `(<int 1> - (- <int 2>))`},
+		{`$.a[0].b($ + 1, 2)`, `((((<var $> . <string a>)[<int 0>]) . <string b>)((<var $> + <int 1>), <int 2>))`},
+		{`$.a[1:2 + 3]`, `((<var $> . <string a>)[<int 1>:(<int 2> + <int 3>)])`},
+		{`$[$ | 1]`, `(<var $>[(<var $> | <int 1>)])`},
+		{`.a[] + 1`, `(((<var $> . <string a>)[]) + <int 1>)`},
+
+		// functions
+		{`map(.a + 1) | length`, `((map(((<var $> . <string a>) + <int 1>))) | <func length>)`},
+		{`limit(1 + 1; .[] | 1, 2)`, `(limit((<int 1> + <int 1>); ((<var $>[]) | (<int 1> , <int 2>))))`},
+		{`not and -length`, `(<func not> and (- <func length>))`},
+
+		// construction
+		{`[1, 2 | 3]`, `[((<int 1> , <int 2>) | <int 3>)]`},
+		{`[] + [.a][0]`, `([] + ([(<var $> . <string a>)][<int 0>]))`},
+		{`{a: 1, "b": 2 + 3, (.c): (4 | 5)}`, `{<string a>: <int 1>, <string b>: (<int 2> + <int 3>), (<var $> . <string c>): (<int 4> | <int 5>)}`},
+		{`{a: .x | .y, b: 1 | 2 | 3}`, `{<string a>: ((<var $> . <string x>) | (<var $> . <string y>)), <string b>: (<int 1> | (<int 2> | <int 3>))}`},
+		{`{a, $b, if: 1}`, `{<string a>: (<var $> . <string a>), <string b>: <var $b>, <string if>: <int 1>}`},
+
+		// assignment
+		{`.a = 1, 2`, `(((<var $> . <string a>) = <int 1>) , <int 2>)`},
+		{`.a |= . + 1 | .b`, `(((<var $> . <string a>) |= (<var $> + <int 1>)) | (<var $> . <string b>))`},
+		{`.a // .b += 1 or 2`, `((<var $> . <string a>) // ((<var $> . <string b>) += (<int 1> or <int 2>)))`},
+
+		// variable binding
+		{`.a as $x | $x + 1, 2`, `((<var $> . <string a>) as <var $x> | ((<var $x> + <int 1>) , <int 2>))`},
+		{`1 + .a as $x | $x`, `(<int 1> + ((<var $> . <string a>) as <var $x> | <var $x>))`},
+		{`(1, 2) as [$a, {b: $b, $c: [$d]}] | $a`, `((<int 1> , <int 2>) as [<var $a>, {<string b>: <var $b>, <var $c>: [<var $d>]}] | <var $a>)`},
+		{`. as {$a, "b": $b, (.c): $c, if: $d} | [$a]`, `(<var $> as {<var $a>, <string b>: <var $b>, (<var $> . <string c>): <var $c>, <string if>: <var $d>} | [<var $a>])`},
+
+		// conditionals and errors
+		{`if .a then 1 elif .b then 2 else 3 end + 1`, `((if (<var $> . <string a>) then <int 1> else (if (<var $> . <string b>) then <int 2> else <int 3> end) end) + <int 1>)`},
+		{`if . then 1, 2 end | 3`, `((if <var $> then (<int 1> , <int 2>) else <var $> end) | <int 3>)`},
+		{`try .a catch . | length`, `((try (<var $> . <string a>) catch <var $>) | <func length>)`},
+		{`try error("x") + 1`, `((try (error(<string x>))) + <int 1>)`},
+		{`.a.b? // 1, .c[]?`, `(((try ((<var $> . <string a>) . <string b>)) // <int 1>) , (try ((<var $> . <string c>)[])))`},
+		{`.if.then, . and .end`, `(((<var $> . <string if>) . <string then>) , (<var $> and (<var $> . <string end>)))`},
+
+		// reduce, foreach and recursion
+		{`reduce .[] as $x (0; . + $x) | . * 2`, `((reduce (<var $>[]) as <var $x> (<int 0>; (<var $> + <var $x>))) | (<var $> * <int 2>))`},
+		{`foreach .a[1:] as [$x] (0, 1; . + $x; [$x, .])`, `(foreach ((<var $> . <string a>)[<int 1>:]) as [<var $x>] ((<int 0> , <int 1>); (<var $> + <var $x>); [(<var $x> , <var $>)]))`},
+		{`foreach .[] as $x (0; 1)`, `(foreach (<var $>[]) as <var $x> (<int 0>; <int 1>))`},
+		{`..|.a?, ..[0]`, `(<func recurse> | ((try (<var $> . <string a>)) , (<func recurse>[<int 0>])))`},
+
+		// function definitions
+		{`def inc: . + 1; map(inc) | length`, `(def inc: (<var $> + <int 1>); ((map(<func inc>)) | <func length>))`},
+		{`def f(g; $x): def h: g; h + $x; f(.a; 1), 2`, `(def f(g; $x): (def h: <func g>; (<func h> + <var $x>)); ((f((<var $> . <string a>); <int 1>)) , <int 2>))`},
+		{`1 + def f: 2; f`, `(<int 1> + (def f: <int 2>; <func f>))`},
+
+		// string interpolation
+		{`"a\(.b | 1)c\(2, 3)" + "d"`, `((<string a> ~ ((<var $> . <string b>) | <int 1>) ~ <string c> ~ (<int 2> , <int 3>) ~ <string >) + <string d>)`},
+		{`"\("\(1)")"`, `(<string > ~ (<string > ~ <int 1> ~ <string >) ~ <string >)`},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tokens, err := token.Lex(c.query)
+			if err != nil {
+				t.Fatalf("failed to lex '%s': %v", c.query, err)
+			}
+
+			res, err := jqp.Parse(tokens)
+			if err != nil {
+				t.Fatalf("failed to parse '%s': %v", c.query, err)
+			}
+
+			if jqp.Format(res) != c.expr {
+				t.Fatalf("query '%s' should result in expr: \n\t %s got: \n\t %s", c.query, c.expr, jqp.Format(res))
+			}
+		})
+	}
+}
+
+func TestExprParsingErrors(t *testing.T) {
+	for i, c := range []struct {
+		query string
+		err   string
+	}{
+		{`1 < 2 < 3`, "jqp/parser: operator '<' is not associative with '<' at position 6, expression so far: (<int 1> < <int 2>)"},
+		{`1 == 2 != 3`, "jqp/parser: operator '!=' is not associative with '==' at position 7, expression so far: (<int 1> == <int 2>)"},
+		{`* 1`, "jqp/parser: unexpected token in literal, got: 0:* at position 0"},
+		{`$(1, `, "jqp/parser: unterminated call arguments at position 5, expression so far: <var $>"},
+		{`$(1`, "jqp/parser: unterminated call arguments at position 3, expression so far: <var $>"},
+		{`$.f(1 2)`, "jqp/parser: expected ',' or ')', found: 6:Int(2) at position 6, expression so far: (<var $> . <string f>)"},
+		{`$.f(,,)`, "jqp/parser: expected call argument, found: 4:, at position 4, expression so far: (<var $> . <string f>)"},
+		{`$.f(, 1)`, "jqp/parser: expected call argument, found: 4:, at position 4, expression so far: (<var $> . <string f>)"},
+		{`$.f(1,)`, "jqp/parser: expected call argument, found: 6:) at position 6, expression so far: (<var $> . <string f>)"},
+		{`$.f(1,, 2)`, "jqp/parser: expected call argument, found: 6:, at position 6, expression so far: (<var $> . <string f>)"},
+		{`$[1`, "jqp/parser: expected ']', found: 3:EOF at position 3, expression so far: <var $>"},
+		{`[1, 2`, "jqp/parser: expected ']', found: 5:EOF at position 5, expression so far: [(<int 1> , <int 2>)]"},
+		{`{a: 1 b: 2}`, "jqp/parser: expected ',' or '}', found: 6:Ident(b) at position 6, expression so far: {<string a>: <int 1>}"},
+		{`{"a"}`, "jqp/parser: expected ':', found: 4:} at position 4, expression so far: {}"},
+		{`{$}`, "jqp/parser: input cannot be used as object key shorthand at position 1, expression so far: {}"},
+		{`map(1, 2`, "jqp/parser: expected ';' or ')', found: 8:EOF at position 8, expression so far: (map((<int 1> , <int 2>)))"},
+		{`{1: 2}`, "jqp/parser: unexpected object key, got: 1:Int(1) at position 1, expression so far: {}"},
+		{`.a = .b = 1`, "jqp/parser: operator '=' is not associative with '=' at position 8, expression so far: ((<var $> . <string a>) = (<var $> . <string b>))"},
+		{`. as $`, "jqp/parser: input cannot be bound as variable at position 5, expression so far: <var $>"},
+		{`. as 1 | 2`, "jqp/parser: expected variable, array or object pattern, got: 5:Int(1) at position 5, expression so far: <var $>"},
+		{`. as $x`, "jqp/parser: expected '|', found: 7:EOF at position 7, expression so far: <var $>"},
+		{`. as [$a $b] | 1`, "jqp/parser: expected ',' or ']', found: 9:Ident($b) at position 9, expression so far: <var $>"},
+		{`. as {a} | 1`, "jqp/parser: expected ':', found: 7:} at position 7, expression so far: <var $>"},
+		{`. as {1: $a} | 1`, "jqp/parser: unexpected object pattern key, got: 6:Int(1) at position 6, expression so far: <var $>"},
+		{`if 1 2`, "jqp/parser: expected 'then', found: 5:Int(2) at position 5, expression so far: <int 1>"},
+		{`if 1 then 2`, "jqp/parser: expected 'elif', 'else' or 'end', found: 11:EOF at position 11, expression so far: (if <int 1> then <int 2> else <var $> end)"},
+		{`if 1 then 2 else 3`, "jqp/parser: expected 'end', found: 18:EOF at position 18, expression so far: (if <int 1> then <int 2> else <int 3> end)"},
+		{`try 1 catch`, "jqp/parser: unexpected token in literal, got: 11:EOF at position 11"},
+		{`. then`, "jqp/parser: unexpected token '2:then' at position 2, expression so far: <var $>"},
+		{`reduce .a 1`, "jqp/parser: expected 'as', found: 10:Int(1) at position 10, expression so far: (<var $> . <string a>)"},
+		{`reduce . as $x 0`, "jqp/parser: expected '(', found: 15:Int(0) at position 15, expression so far: <var $>"},
+		{`reduce . as $x (0; 1; 2)`, "jqp/parser: reduce doesn't take 3 arguments at position 0, expression so far: <var $>"},
+		{`foreach . as $x (0)`, "jqp/parser: foreach doesn't take 1 arguments at position 0, expression so far: <var $>"},
+		{`foreach . as $x (0, 1`, "jqp/parser: expected ';' or ')', found: 21:EOF at position 21, expression so far: (<int 0> , <int 1>)"},
+		{`def $f: 1; 2`, "jqp/parser: expected function name, got: 4:Ident($f) at position 4"},
+		{`def f(1): 1; 2`, "jqp/parser: expected parameter name, got: 6:Int(1) at position 6"},
+		{`def f(a, b): 1; 2`, "jqp/parser: expected ';' or ')', found: 7:, at position 7"},
+		{`def f 1; 2`, "jqp/parser: expected ':', found: 6:Int(1) at position 6"},
+		{`def f: 1 2`, "jqp/parser: expected ';', found: 9:Int(2) at position 9, expression so far: <int 1>"},
+		{`def f: 1;`, "jqp/parser: unexpected token in literal, got: 9:EOF at position 9"},
+		{`import "a" as a; 1`, "jqp/parser: cannot import modules without a module loader at position 0"},
+		{`import a as a; 1`, "jqp/parser: expected module path, found: 7:Ident(a) at position 7"},
+		{`import "a"; 1`, "jqp/parser: expected 'as', found: 10:; at position 10"},
+		{`import "a" as $a; 1`, "jqp/parser: expected module name, found: 14:Ident($a) at position 14"},
+		{`include "a" 1`, "jqp/parser: expected ';', found: 12:Int(1) at position 12"},
+		{`module 1; 1`, "jqp/parser: expected module metadata, found: 7:Int(1) at position 7"},
+		{`1 | include "a";`, "jqp/parser: unexpected token in literal, got: 4:include at position 4"},
+		{`"a\(1 2)"`, "jqp/parser: expected end of interpolation, found: 6:Int(2) at position 6, expression so far: (<string a> ~ <int 1>)"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tokens, _ := token.Lex(c.query)
+			_, err := jqp.Parse(tokens)
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("parsing '%s' should fail with: \n\t%s got: \n\t%v", c.query, c.err, err)
+			}
+		})
+	}
+}
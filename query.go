@@ -1,14 +1,472 @@
 package jqp
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
 	"github.com/advanderveer/jqp/token"
 	"github.com/advanderveer/jqp/value"
 )
 
-func Query(q string, v interface{}) interface{} {
-	tokens := token.Lex(q)                         // lex
-	expr := Parse(tokens)                          // parse
-	return value.ToNative(expr.Eval(value.Context{ // eval
-		Decl: map[value.Var]value.Value{"$": value.FromNative(v, false)},
-	}))
+// Filter is a compiled query. It is immutable and can safely be
+// run concurrently from multiple goroutines.
+type Filter struct {
+	q    string
+	expr value.Expr
+
+	bigNumbers bool
+	vars       map[string]interface{}
+	decl       map[value.Var]value.Value
+	deps       []*value.Dependency
+	imported   value.Context // context with the functions of deps in scope
+	loader     ModuleLoader
+	modules    map[string]*value.Module // loaded modules by their path
+	goFuncs    []goFunc
+	funcs      map[string]value.BuiltinFunc
+	limits     value.Limits
+}
+
+// Option configures how a query is compiled and run
+type Option func(f *Filter)
+
+// WithBigNumbers enables the big number mode. Number literals in the query
+// and numbers in the input that don't fit an int64 or float64 are kept as
+// a value.Decimal, and all arithmetic is done exactly through math/big.
+// Results that are integers fitting an int64 are narrowed to an int, other
+// decimals are returned as a json.Number that holds their exact text.
+// Numbers from a json.Number keep their text until they are changed.
+func WithBigNumbers() Option {
+	return func(f *Filter) { f.bigNumbers = true }
+}
+
+// WithVar declares the external variable '$name' as 'v' in the query, like
+// the --arg and --argjson flags of jq. The name is given without the '$'.
+// The value is converted when the query is compiled.
+func WithVar(name string, v interface{}) Option {
+	return func(f *Filter) {
+		if f.vars == nil {
+			f.vars = map[string]interface{}{}
+		}
+
+		f.vars[strings.TrimPrefix(name, "$")] = v
+	}
+}
+
+// WithLimits bounds the resources that every run of the query can use,
+// see value.Limits. A run that exceeds a limit fails with a
+// *value.LimitError, which the query itself cannot catch.
+func WithLimits(limits value.Limits) Option {
+	return func(f *Filter) { f.limits = limits }
+}
+
+// WithFunc registers the Go function 'fn' as the function 'name' with
+// 'arity' arguments in the query, it shadows a builtin with the same name
+// and arity. The function must be one of:
+//
+//	func(in interface{}, args []interface{}) (interface{}, error)
+//	func(in value.Value, args []value.Value) (value.Value, error)
+//
+// The first receives its input and arguments converted to native values,
+// as Run returns them, and its result is converted as the input of Run.
+// The arguments are evaluated against the input, and the function is
+// called for every combination of their outputs. The function is checked
+// when the query is compiled.
+func WithFunc(name string, arity int, fn interface{}) Option {
+	return func(f *Filter) { f.goFuncs = append(f.goFuncs, goFunc{name, arity, fn}) }
+}
+
+// goFunc is a Go function that is registered with WithFunc
+type goFunc struct {
+	name  string
+	arity int
+	fn    interface{}
+}
+
+// builtin adapts the Go function to a builtin of the filter
+func (gf goFunc) builtin(f *Filter) (value.BuiltinFunc, error) {
+	if !isFuncName(gf.name) {
+		return nil, errors.New("name is not an identifier")
+	} else if gf.arity < 0 {
+		return nil, errors.New("arity is negative")
+	}
+
+	switch fn := gf.fn.(type) {
+	case func(in value.Value, args []value.Value) (value.Value, error):
+		return value.GoFunc(fn), nil
+	case func(in interface{}, args []interface{}) (interface{}, error):
+		return value.GoFunc(func(in value.Value, args []value.Value) (value.Value, error) {
+			nin, err := f.toNative(in)
+			if err != nil {
+				return nil, err
+			}
+
+			nargs := make([]interface{}, len(args))
+			for i, arg := range args {
+				if nargs[i], err = f.toNative(arg); err != nil {
+					return nil, err
+				}
+			}
+
+			res, err := fn(nin, nargs)
+			if err != nil {
+				return nil, err
+			}
+
+			return f.fromNative(res, false)
+		}), nil
+	case nil:
+		return nil, errors.New("function is nil")
+	default:
+		return nil, errors.New("unsupported function type " + reflect.TypeOf(gf.fn).String())
+	}
+}
+
+// isFuncName reports whether the name lexes as a single identifier that
+// queries can invoke, variables and names of imported modules are not
+func isFuncName(name string) bool {
+	tokens, err := token.Lex(name)
+	if err != nil || len(tokens) != 2 || tokens[0].Type != token.Ident || tokens[0].Text != name {
+		return false
+	}
+
+	return !strings.HasPrefix(name, "$") && !strings.Contains(name, "::")
+}
+
+// WithDefs defines the functions of 'defs' in the query, as if their
+// definitions were written in front of it. The same definitions can be
+// shared by many queries without being parsed again.
+func WithDefs(defs *Defs) Option {
+	return func(f *Filter) {
+		f.deps = append(f.deps, &value.Dependency{Module: defs.mod})
+		f.addModules(defs.modules)
+	}
+}
+
+// addModules adds the loaded modules that modulemeta can describe
+func (f *Filter) addModules(modules map[string]*value.Module) {
+	if f.modules == nil {
+		f.modules = make(map[string]*value.Module, len(modules))
+	}
+
+	for path, m := range modules {
+		f.modules[path] = m
+	}
+}
+
+// Compile lexes and parses the query 'q' into a filter that can be
+// run many times against different inputs. Modules that the query
+// imports or includes are loaded with the loader of WithModuleLoader.
+// Errors are of type *token.LexError, *ParseError or *ModuleError.
+func Compile(q string, opts ...Option) (*Filter, error) {
+	f := &Filter{q: q}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	tokens, err := token.Lex(q) // lex
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := parse(tokens, f.bigNumbers) // parse
+	if err != nil {
+		return nil, err
+	}
+
+	ms := newModules(f.loader, f.bigNumbers)
+	deps, err := ms.deps(prog.deps)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ms.metas(prog.metas); err != nil {
+		return nil, err
+	}
+
+	f.expr, f.deps = prog.expr, append(f.deps, deps...)
+	f.addModules(ms.loaded)
+	f.imported = value.Context{}.Import(f.deps...)
+
+	f.decl = make(map[value.Var]value.Value, len(f.vars))
+	for name, v := range f.vars {
+		if f.decl[value.Var("$"+name)], err = f.fromNative(v, false); err != nil {
+			return nil, errors.New("jqp: invalid value for variable $" + name + ": " + err.Error())
+		}
+	}
+
+	f.funcs = make(map[string]value.BuiltinFunc, len(f.goFuncs))
+	for _, gf := range f.goFuncs {
+		key := gf.name + "/" + strconv.Itoa(gf.arity)
+		if f.funcs[key], err = gf.builtin(f); err != nil {
+			return nil, errors.New("jqp: invalid function " + key + ": " + err.Error())
+		}
+	}
+
+	return f, nil
+}
+
+// Defs are compiled function definitions, such as 'def inc: . + 1;', that
+// can be used in other queries with WithDefs. They are immutable and can
+// safely be shared between goroutines.
+type Defs struct {
+	src     string
+	mod     *value.Module
+	modules map[string]*value.Module // loaded modules by their path
+}
+
+// CompileDefs lexes and parses the function definitions in 'src', which
+// may only hold definitions after its directives, like a module. Later
+// definitions can invoke earlier ones, the definitions passed with
+// WithDefs and those of the modules it imports or includes. Errors are
+// of type *token.LexError, *ParseError or *ModuleError.
+func CompileDefs(src string, opts ...Option) (*Defs, error) {
+	f := &Filter{q: src}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	tokens, err := token.Lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := parseModule(tokens, f.bigNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := newModules(f.loader, f.bigNumbers)
+	mod, err := ms.module(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	mod.Deps = append(f.deps, mod.Deps...)
+	f.addModules(ms.loaded)
+	return &Defs{src: src, mod: mod, modules: f.modules}, nil
+}
+
+// String returns the source text of the definitions
+func (d *Defs) String() string { return d.src }
+
+// MustCompile is like Compile but panics if the query cannot be
+// compiled. It simplifies the initialization of global filters.
+func MustCompile(q string, opts ...Option) *Filter {
+	f, err := Compile(q, opts...)
+	if err != nil {
+		panic("jqp: Compile(" + q + "): " + err.Error())
+	}
+
+	return f
+}
+
+// String returns the source text of the query
+func (f *Filter) String() string { return f.q }
+
+// errFirst stops the evaluation once the first output is found
+var errFirst = errors.New("jqp: first output found")
+
+// context declares the external variables and 'in' as the input, with
+// the functions of the modules in scope. The evaluation is limited by
+// a budget if the filter has limits or 'ctx' can be canceled.
+func (f *Filter) context(ctx context.Context, in value.Value) value.Context {
+	decl := make(map[value.Var]value.Value, len(f.decl)+1)
+	for name, v := range f.decl {
+		decl[name] = v
+	}
+
+	decl[value.Input] = in
+	vctx := f.imported
+	vctx.Decl, vctx.BigNumbers, vctx.Funcs, vctx.Modules = decl, f.bigNumbers, f.funcs, f.modules
+	if ctx.Done() != nil || f.limits != (value.Limits{}) {
+		vctx.Budget = value.NewBudget(ctx, f.limits)
+	}
+
+	return vctx
+}
+
+// eval the filter with 'v' as its input, passing each output to emit
+func (f *Filter) eval(ctx context.Context, v interface{}, emit value.Emit) error {
+	in, err := f.fromNative(v, false)
+	if err != nil {
+		return err
+	}
+
+	vctx := f.context(ctx, in)
+	return f.expr.Eval(vctx, func(v value.Value) error {
+		if err := vctx.Budget.Output(); err != nil {
+			return err
+		}
+
+		return emit(v)
+	})
+}
+
+// fromNative converts an input of the filter, or a native value that
+// is passed to it, to a value
+func (f *Filter) fromNative(v interface{}, port bool) (value.Value, error) {
+	if f.bigNumbers {
+		return value.FromNativeAs(v, port, value.BigNumbers)
+	}
+
+	return value.FromNative(v, port)
+}
+
+// toNative converts an output of the filter to a native value
+func (f *Filter) toNative(v value.Value) (interface{}, error) {
+	if f.bigNumbers {
+		return value.ToNativeAs(v, value.BigNumbers)
+	}
+
+	return value.ToNative(v)
+}
+
+// Run evaluates the filter with 'v' as its input and returns its first
+// output, or nil if the filter didn't output anything. Evaluation stops
+// after the first output. Evaluation errors are of type *value.EvalError,
+// or *value.LimitError if the filter has limits that were exceeded.
+func (f *Filter) Run(v interface{}) (interface{}, error) {
+	return f.RunContext(context.Background(), v)
+}
+
+// RunContext is like Run but stops the evaluation with the error of
+// 'ctx' once it is done, such as context.Canceled.
+func (f *Filter) RunContext(ctx context.Context, v interface{}) (interface{}, error) {
+	var out value.Value
+	if err := f.eval(ctx, v, func(v value.Value) error {
+		out = v
+		return errFirst
+	}); err != nil && err != errFirst {
+		return nil, err
+	}
+
+	if out == nil {
+		return nil, nil
+	}
+
+	return f.toNative(out)
+}
+
+// RunAll evaluates the filter with 'v' as its input and returns all of
+// its outputs. Evaluation errors are of type *value.EvalError, or
+// *value.LimitError if the filter has limits that were exceeded.
+func (f *Filter) RunAll(v interface{}) ([]interface{}, error) {
+	return f.RunAllContext(context.Background(), v)
+}
+
+// RunAllContext is like RunAll but stops the evaluation with the error
+// of 'ctx' once it is done, such as context.Canceled.
+func (f *Filter) RunAllContext(ctx context.Context, v interface{}) ([]interface{}, error) {
+	var res []interface{}
+	if err := f.eval(ctx, v, func(v value.Value) error {
+		nv, err := f.toNative(v)
+		if err != nil {
+			return err
+		}
+
+		res = append(res, nv)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// paths evaluates the filter as a path expression with 'root' as its
+// input, the root is read through ports such that it can be written
+func (f *Filter) paths(root interface{}) (value.Value, []value.Array, error) {
+	in, err := f.fromNative(root, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths, err := value.CollectPaths(f.context(context.Background(), in), f.expr)
+
+	return in, paths, err
+}
+
+// Set evaluates the filter as a path expression, such as '.a[0].b', with
+// 'root' as its input and sets the value at every path to 'v'. Unlike the
+// update operators, which return modified copies, the maps, slices, structs
+// and JS objects of 'root' are modified in place: structs and arrays must
+// be reached through a pointer and the parents of the paths must exist.
+func (f *Filter) Set(root interface{}, v interface{}) error {
+	in, paths, err := f.paths(root)
+	if err != nil {
+		return err
+	}
+
+	nv, err := f.fromNative(v, true)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err = value.WritePath(in, path, nv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete evaluates the filter as a path expression with 'root' as its input
+// and deletes the fields at every path from 'root' in place. Elements of
+// slices cannot be deleted as that would change their length.
+func (f *Filter) Delete(root interface{}) error {
+	in, paths, err := f.paths(root)
+	if err != nil {
+		return err
+	}
+
+	return value.RemovePaths(in, paths)
+}
+
+// Query compiles and runs the query 'q' with 'v' as its input and
+// returns its first output. Errors are of type *token.LexError,
+// *ParseError or *value.EvalError depending on the stage that failed.
+// Use Compile when the same query is run many times.
+func Query(q string, v interface{}) (interface{}, error) {
+	f, err := Compile(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Run(v)
+}
+
+// QueryAll is like Query but returns every output of the query
+func QueryAll(q string, v interface{}) ([]interface{}, error) {
+	f, err := Compile(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.RunAll(v)
+}
+
+// Set compiles the query 'q' and sets the value at every path it outputs
+// inside of 'root' to 'newValue', see Filter.Set.
+func Set(q string, root interface{}, newValue interface{}) error {
+	f, err := Compile(q)
+	if err != nil {
+		return err
+	}
+
+	return f.Set(root, newValue)
+}
+
+// Delete compiles the query 'q' and deletes the field at every path it
+// outputs from 'root', see Filter.Delete.
+func Delete(q string, root interface{}) error {
+	f, err := Compile(q)
+	if err != nil {
+		return err
+	}
+
+	return f.Delete(root)
 }
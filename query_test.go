@@ -1,12 +1,25 @@
 package jqp_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/advanderveer/jqp"
+	"github.com/advanderveer/jqp/token"
+	"github.com/advanderveer/jqp/value"
 )
 
 func TestNativeQuery(t *testing.T) {
@@ -35,9 +48,17 @@ func TestNativeQuery(t *testing.T) {
 		{[]interface{}{map[string]interface{}{
 			"foo": func(args ...interface{}) interface{} { return func(args ...interface{}) interface{} { return "bar" } },
 		}}, `$[0].foo()()`, "bar"},
+
+		{[]interface{}{1.0, math.NaN(), -1.0, math.Inf(-1)}, `[.[1] < .[3], .[1] < .[1], .[1] == .[0], .[1] == .[1], (sort | .[1:]), (unique | length), min == .[1]]`, []interface{}{
+			true, false, false, true, []interface{}{math.Inf(-1), -1.0, 1.0}, 4, true,
+		}},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			res := jqp.Query(c.query, c.v)
+			res, err := jqp.Query(c.query, c.v)
+			if err != nil {
+				t.Fatalf("query '%s' failed: %v", c.query, err)
+			}
+
 			if !reflect.DeepEqual(res, c.result) {
 				t.Fatalf("query '%s' on '%#v' gave '%#v', expected: '%s'", c.query, c.v, res, c.result)
 			}
@@ -53,6 +74,11 @@ func TestJSONQuery(t *testing.T) {
 	}{
 		{`{"foo": "bar"}`, "$.foo", "bar"},
 		{`{"foo": [3,4]}`, "$.foo[0]", 3.0},
+		{`{"items": [{"name": "a"}]}`, "$.items | $[0] | $.name", "a"},
+		{`{"items": [{"name": "a"}]}`, ".items[0] | .name", "a"},
+		{`{"foo": {"bar": 2}}`, "($.foo | $.bar) + 1", 3},
+		{`{"foo": 2}`, "$.foo | $ + $", 4},
+		{`{"foo": [1, 2]}`, "$.foo | .[1]", 2.0},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			var v interface{}
@@ -61,10 +87,1390 @@ func TestJSONQuery(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			res := jqp.Query(c.query, v)
+			res, err := jqp.Query(c.query, v)
+			if err != nil {
+				t.Fatalf("query '%s' failed: %v", c.query, err)
+			}
+
 			if !reflect.DeepEqual(res, c.result) {
 				t.Fatalf("query '%s' on '%s' gave '%#v', expected: '%s'", c.query, c.json, res, c.result)
 			}
 		})
 	}
 }
+
+func TestQueryErrors(t *testing.T) {
+	for i, c := range []struct {
+		v     interface{}
+		query string
+		err   string
+	}{
+		{nil, `$ @`, "jqp/token: unrecognized character: '@' at position 2"},
+		{nil, `$.`, "jqp/parser: expected identifier after dot, got: 2:EOF at position 2, expression so far: <var $>"},
+		{nil, `($`, "jqp/parser: expected ')', found: 2:EOF at position 2, expression so far: <var $>"},
+		{nil, `$)`, "jqp/parser: unexpected token '1:)' at position 1, expression so far: <var $>"},
+		{make(chan int), `$`, "jqp/value: cant convert this type from native: chan int"},
+		{struct{ A int }{}, `$.B`, "jqp/value: object doesn't have key: B at position 1"},
+		{[]string{"a"}, `$[1]`, "jqp/value: index out of range: 1 at position 1"},
+		{map[string]interface{}{}, `$.foo`, "jqp/value: object doesn't have key: foo at position 1"},
+		{[]interface{}{1}, `$[1]`, "jqp/value: index out of range: 1 at position 1"},
+		{[]interface{}{1}, `$[-2.5]`, "jqp/value: index out of range: -2 at position 1"},
+		{struct{ A int }{}, `$[0]`, "jqp/value: cannot index object with value of type 'int' at position 1"},
+		{[]string{"a"}, `$.a`, "jqp/value: cannot index array with value of type 'string' at position 1"},
+		{map[string]interface{}{}, `$[0]`, "jqp/value: cannot index object with value of type 'int' at position 1"},
+		{[]interface{}{1}, `$.a`, "jqp/value: cannot index array with value of type 'string' at position 1"},
+		{nil, `"a" | .[0]`, "jqp/value: cannot index string with value of type 'int' at position 7"},
+		{nil, `null | .[true]`, "jqp/value: cannot index null with value of type 'bool' at position 8"},
+		{1, `$ + 'a'`, "jqp/value: cannot apply '+' to values of type 'int' and 'string' at position 2"},
+		{1, `$ / 0, 1`, "jqp/value: division by zero at position 2"},
+		{1, `$ % 0`, "jqp/value: division by zero at position 2"},
+		{1, `'x' * 1e300`, "jqp/value: repeated string would be longer than 268435456 bytes at position 4"},
+		{1, `'x' * 1e18`, "jqp/value: repeated string would be longer than 268435456 bytes at position 4"},
+		{1, `'x' * 1e10`, "jqp/value: repeated string would be longer than 268435456 bytes at position 4"},
+		{1, `'x' * (1e308 * 10 - 1e308 * 10)`, "jqp/value: cannot repeat string a NaN number of times at position 4"},
+		{1, `'ab' * 134217729`, "jqp/value: repeated string would be longer than 268435456 bytes at position 5"},
+		{1, `1e20 % 0.5`, "jqp/value: division by zero at position 5"},
+		{1.5, `$ / 0.0`, "jqp/value: division by zero at position 2"},
+		{1, `true - false`, "jqp/value: cannot apply '-' to values of type 'bool' at position 5"},
+		{1, `'a' - 1`, "jqp/value: cannot apply '-' to values of type 'string' and 'int' at position 4"},
+		{1, `$()`, "jqp/value: value of type 'int' is not callable at position 1"},
+		{1, `a`, "jqp/value: function not defined: a/0 at position 0"},
+		{1, `$a`, "jqp/value: var not declared in context: $a at position 0"},
+		{1, `{$b}`, "jqp/value: var not declared in context: $b at position 1"},
+		{1, `1 as $x | path($x)`, "jqp/value: invalid path expression with result 1 at position 15"},
+		{nil, `map(1; 2)`, "jqp/value: function not defined: map/2 at position 0"},
+		{nil, `true | length`, "jqp/value: value of type 'bool' has no length at position 7"},
+		{nil, `{} | has(0)`, "jqp/value: cannot check whether object has a key of type 'int' at position 5"},
+		{nil, `error("custom")`, "jqp/value: custom at position 0"},
+		{nil, `{a: 1} | error`, `jqp/value: {"a":1} (not a string) at position 9`},
+		{nil, `'abc' | tonumber`, `jqp/value: cannot parse "abc" as number at position 8`},
+		{nil, `'nan' | tonumber`, `jqp/value: cannot parse "nan" as number at position 8`},
+		{nil, `'inf' | tonumber`, `jqp/value: cannot parse "inf" as number at position 8`},
+		{nil, `'Infinity' | tonumber`, `jqp/value: cannot parse "Infinity" as number at position 13`},
+		{nil, `'  1  ' | tonumber`, `jqp/value: cannot parse "  1  " as number at position 10`},
+		{nil, `'0x10' | tonumber`, `jqp/value: cannot parse "0x10" as number at position 9`},
+		{nil, `[limit(-1; 1, 2)]`, "jqp/value: limit must not be negative at position 1"},
+		{nil, `[1] | map(. + 'a')`, "jqp/value: cannot apply '+' to values of type 'int' and 'string' at position 12"},
+		{nil, `1 | split(",")`, "jqp/value: split input must be a string, got value of type 'int' at position 4"},
+		{nil, `"a" | split(1)`, "jqp/value: split argument must be a string, got value of type 'int' at position 6"},
+		{nil, `"a" | startswith(1)`, "jqp/value: startswith argument must be a string, got value of type 'int' at position 6"},
+		{nil, `[[]] | join(",")`, "jqp/value: cannot join value of type 'array' at position 7"},
+		{nil, `[-1] | implode`, "jqp/value: cannot implode invalid codepoint: -1 at position 7"},
+		{nil, `1 | test("a")`, "jqp/value: value of type 'int' cannot be matched, as it is not a string at position 4"},
+		{nil, `"a" | test("(")`, "jqp/value: invalid regular expression \"(\": error parsing regexp: missing closing ): `(` at position 6"},
+		{nil, `"a" | test("a"; "q")`, `jqp/value: "q" is not a valid modifier string at position 6`},
+		{nil, `"a" | sub("a"; 1)`, "jqp/value: sub replacement must be a string, got value of type 'int' at position 6"},
+		{nil, `"\({} | length + "a")"`, "jqp/value: cannot apply '+' to values of type 'int' and 'string' at position 15"},
+		{nil, `{} | sort`, "jqp/value: sort input must be an array, got value of type 'map' at position 5"},
+		{nil, `[1, 2] | flatten(-1)`, "jqp/value: flatten depth must not be negative at position 9"},
+		{nil, `[1] | contains("a")`, "jqp/value: array and string cannot have their containment checked at position 6"},
+		{nil, `1 | to_entries`, "jqp/value: cannot convert value of type 'int' to entries at position 4"},
+		{nil, `[1] | from_entries`, "jqp/value: cannot use value of type 'int' as an entry at position 6"},
+		{nil, `path(1)`, "jqp/value: invalid path expression with result 1 at position 0"},
+		{nil, `path(.a + 1)`, "jqp/value: invalid path expression with result 1 at position 8"},
+		{nil, `.a + 1 |= 2`, "jqp/value: invalid path expression with result 1 at position 3"},
+		{nil, `[1] | .a = 1`, "jqp/value: cannot index array with value of type 'string' at position 6"},
+		{nil, `{} | .[0] = 1`, "jqp/value: cannot index object with value of type 'int' at position 6"},
+		{nil, `[] | .[-1] = 1`, "jqp/value: out of bounds negative array index at position 11"},
+		{nil, `[] | .[1e300] = 1`, "jqp/value: array index too large, it must be at most 67108863 at position 14"},
+		{nil, `[] | .[1e9] = 1`, "jqp/value: array index too large, it must be at most 67108863 at position 12"},
+		{nil, `[] | .[1e308 * 10 - 1e308 * 10] = 1`, "jqp/value: array index too large, it must be at most 67108863 at position 32"},
+		{nil, `getpath("a")`, "jqp/value: path must be an array, got value of type 'string' at position 0"},
+		{nil, `[1] | .[:1] = 1`, "jqp/value: a slice of an array can only be assigned another array at position 12"},
+		{nil, `.a |= error("x")`, "jqp/value: x at position 6"},
+		{nil, `{a: 1} | .a += "x"`, "jqp/value: cannot apply '+' to values of type 'int' and 'string' at position 12"},
+		{nil, `[1] | map(.) |= 1`, "jqp/value: invalid path expression with result [1] at position 6"},
+		{nil, `1 as [$a] | $a`, "jqp/value: cannot index number with value of type 'int' at position 5"},
+		{nil, `{} as {(1): $a} | $a`, "jqp/value: cannot index object with value of type 'int' at position 6"},
+		{nil, `[] as {$a} | $a`, "jqp/value: cannot index array with value of type 'string' at position 6"},
+		{nil, `1 as $x | $y`, "jqp/value: var not declared in context: $y at position 10"},
+		{nil, `try error("x") catch error(. + "y")`, "jqp/value: xy at position 21"},
+		{nil, `path(try error("x") catch .)`, `jqp/value: invalid path expression with result "x" at position 5`},
+		{nil, `if error("c") then 1 end`, "jqp/value: c at position 3"},
+		{nil, `reduce (1, 2) as $x (0; . + "a")`, "jqp/value: cannot apply '+' to values of type 'int' and 'string' at position 26"},
+		{nil, `[foreach 1 as $x (0; error("f"))]`, "jqp/value: f at position 21"},
+		{nil, `[1] | walk(error)`, "jqp/value: 1 (not a string) at position 11"},
+		{nil, `[recurse(error("r"))]`, "jqp/value: r at position 9"},
+		{nil, `def f: g; f`, "jqp/value: function not defined: g/0 at position 7"},
+		{nil, `def f(x): x; f`, "jqp/value: function not defined: f/0 at position 13"},
+		{nil, `def f: .a; [1] | f`, "jqp/value: cannot index array with value of type 'string' at position 7"},
+		{nil, `def f($x): $x; f(error("v"))`, "jqp/value: v at position 17"},
+		{1, `$[]`, "jqp/value: cannot iterate over value of type 'int' at position 1"},
+		{1, `$[1:]`, "jqp/value: cannot slice value of type 'int' at position 1"},
+		{[]interface{}{1}, `$['a':]`, "jqp/value: non integer slice bound at position 1"},
+		{func(args ...interface{}) interface{} { return nil }, `$ == 1`, "jqp/value: cannot compare value of type 'func' at position 2"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := jqp.Query(c.query, c.v)
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("query '%s' should fail with: \n\t%s got: \n\t%v", c.query, c.err, err)
+			}
+		})
+	}
+}
+
+type testAddress struct {
+	City   string `json:"city"`
+	Zip    string `json:"-"`
+	street string
+}
+
+type testMeta struct {
+	Tags map[string]int `json:"tags"`
+}
+
+type testUser struct {
+	*testMeta
+	Name      string
+	Addresses []*testAddress `json:"addresses,omitempty"`
+	Scores    [3]float64
+	Extra     interface{}
+	Friend    *testUser
+}
+
+func TestStructQuery(t *testing.T) {
+	addr := &testAddress{City: "Amsterdam", Zip: "1000", street: "Dam"}
+	user := testUser{
+		testMeta:  &testMeta{Tags: map[string]int{"b": 2, "a": 1}},
+		Name:      "Ada",
+		Addresses: []*testAddress{addr},
+		Scores:    [3]float64{1, 2, 3},
+		Extra:     []interface{}{"x"},
+	}
+
+	for i, c := range []struct {
+		query   string
+		results []interface{}
+	}{
+		{`$.Name, $.addresses[0].city, $.Extra[0]`, []interface{}{"Ada", "Amsterdam", "x"}},
+		{`$.tags.a, $.tags["b"], [$.tags[]]`, []interface{}{1, 2, []interface{}{1, 2}}},
+		{`$.Scores[1:], $.Scores[-1], $.Friend, $.Friend.Name`, []interface{}{[]float64{2, 3}, 3.0, nil, nil}},
+		{`$[]`, []interface{}{[]interface{}{"x"}, nil, "Ada", [3]float64{1, 2, 3}, []*testAddress{addr}, map[string]int{"a": 1, "b": 2}}},
+		{`$.addresses[0], {c: $.addresses[].city}`, []interface{}{*addr, map[string]interface{}{"c": "Amsterdam"}}},
+		{`$.addresses[0].Zip`, nil},
+		{`keys, tostring, ([$] | tostring)`, []interface{}{
+			[]interface{}{"Extra", "Friend", "Name", "Scores", "addresses", "tags"},
+			`{"Extra":["x"],"Friend":null,"Name":"Ada","Scores":[1,2,3],"addresses":[{"city":"Amsterdam"}],"tags":{"a":1,"b":2}}`,
+			`[{"Extra":["x"],"Friend":null,"Name":"Ada","Scores":[1,2,3],"addresses":[{"city":"Amsterdam"}],"tags":{"a":1,"b":2}}]`,
+		}},
+		{`$.Scores | to_entries[1], with_entries(.value += 1)`, []interface{}{
+			map[string]interface{}{"key": 1, "value": 2.0}, map[string]interface{}{"0": 2, "1": 3, "2": 4},
+		}},
+		{`$.addresses[0].street`, nil},
+		{`$.addresses[0][0]`, nil},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := jqp.QueryAll(c.query, &user)
+			if c.results == nil {
+				if err == nil {
+					t.Fatalf("query '%s' should fail, got: %#v", c.query, res)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("query '%s' failed: %v", c.query, err)
+			}
+
+			if !reflect.DeepEqual(res, c.results) {
+				t.Fatalf("query '%s' gave '%#v', expected: '%#v'", c.query, res, c.results)
+			}
+		})
+	}
+
+	// empty omitempty fields are listed by keys and rendered alike
+	res, err := jqp.QueryAll(`(keys | index("addresses") != null), (tostring | contains('"addresses":null'))`, testUser{})
+	if err != nil || !reflect.DeepEqual(res, []interface{}{true, true}) {
+		t.Fatalf("expected keys and tostring to agree on empty fields, got: %v (%v)", res, err)
+	}
+}
+
+func TestBigNumbers(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"id": 12345678901234567890, "price": 10.10, "qty": 3}`))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		query string
+		big   []interface{}
+		exp   []interface{}
+	}{
+		{`$.id, $.price, $.qty`,
+			[]interface{}{json.Number("12345678901234567890"), json.Number("10.10"), 3},
+			[]interface{}{1.2345678901234567e19, 10.1, 3}},
+		{`$.id + 1, $.price * $.qty, $.qty / 3`,
+			[]interface{}{json.Number("12345678901234567891"), json.Number("30.3"), 1},
+			[]interface{}{1.2345678901234567e19, 30.299999999999997, 1}},
+		{`0.1 + 0.2, 0.1 + 0.2 == 0.3, 1.5 * 2`,
+			[]interface{}{json.Number("0.3"), true, 3},
+			[]interface{}{0.30000000000000004, false, 3}},
+		{`9223372036854775807 + 1, 1 / 3, -0.5`,
+			[]interface{}{json.Number("9223372036854775808"), json.Number("0.3333333333333333333333333333333333"), json.Number("-0.5")},
+			[]interface{}{9223372036854775808.0, 0.3333333333333333, -0.5}},
+		{`9223372036854775807 - -1, 4294967296 * 4294967296`,
+			[]interface{}{json.Number("9223372036854775808"), json.Number("18446744073709551616")},
+			[]interface{}{9223372036854775808.0, 18446744073709551616.0}},
+		{`$.id > 12345678901234567889, 1e17 * 1`,
+			[]interface{}{true, 100000000000000000},
+			[]interface{}{false, 1e17}},
+		{`. as $v | [1, 2, 3] | .[0.5:$v.qty / 2], .[$v.price / 10:]`,
+			[]interface{}{[]interface{}{1}, []interface{}{2, 3}},
+			[]interface{}{[]interface{}{1}, []interface{}{2, 3}}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := jqp.MustCompile(c.query, jqp.WithBigNumbers()).RunAll(v)
+			if err != nil || !reflect.DeepEqual(res, c.big) {
+				t.Fatalf("query '%s' in big number mode gave '%#v' (%v), expected: '%#v'", c.query, res, err, c.big)
+			}
+
+			res, err = jqp.QueryAll(c.query, v)
+			if err != nil || !reflect.DeepEqual(res, c.exp) {
+				t.Fatalf("query '%s' gave '%#v' (%v), expected: '%#v'", c.query, res, err, c.exp)
+			}
+		})
+	}
+
+	// elements of ports are converted in the mode of the filter when read
+	ports := struct{ U []uint64 }{[]uint64{math.MaxUint64}}
+	res, err := jqp.MustCompile(`.U[0], .U[0] + 1`, jqp.WithBigNumbers()).RunAll(ports)
+	if exp := []interface{}{json.Number("18446744073709551615"), json.Number("18446744073709551616")}; err != nil || !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected port elements to be decimals, got: %#v (%v)", res, err)
+	}
+
+	res, err = jqp.QueryAll(`.U[0]`, ports)
+	if exp := []interface{}{18446744073709551615.0}; err != nil || !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected port elements to be floats, got: %#v (%v)", res, err)
+	}
+}
+
+func TestQueryErrorTypes(t *testing.T) {
+	_, err := jqp.Query(`$ @`, nil)
+	if _, ok := err.(*token.LexError); !ok {
+		t.Fatalf("expected lex error, got: %T", err)
+	}
+
+	_, err = jqp.Query(`$.`, nil)
+	if _, ok := err.(*jqp.ParseError); !ok {
+		t.Fatalf("expected parse error, got: %T", err)
+	}
+
+	for _, q := range []string{`1 + 1e9999999`, `1e99999999999999999999`} {
+		_, err = jqp.Compile(q, jqp.WithBigNumbers())
+		if perr, ok := err.(*jqp.ParseError); !ok || perr.Tok.Pos != strings.Index(q, "1e") {
+			t.Fatalf("expected parse error at the decimal literal of '%s', got: %T %v", q, err, err)
+		}
+	}
+
+	_, err = jqp.Query(`$.foo.bar`, map[string]interface{}{"foo": 1})
+	eerr, ok := err.(*value.EvalError)
+	if !ok {
+		t.Fatalf("expected eval error, got: %T", err)
+	}
+
+	if eerr.Pos != 5 || jqp.Format(eerr.Expr) != "((<var $> . <string foo>) . <string bar>)" {
+		t.Fatalf("expected eval error to carry position and expression, got: %d %s", eerr.Pos, jqp.Format(eerr.Expr))
+	}
+}
+
+func TestCompiledQuery(t *testing.T) {
+	f, err := jqp.Compile(`$.foo + 1`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	if f.String() != `$.foo + 1` {
+		t.Fatalf("expected filter to return its source, got: %s", f)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := f.Run(map[string]interface{}{"foo": i})
+			if err != nil || res != i+1 {
+				t.Errorf("unexpected result for run %d: %v (%v)", i, res, err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	_, err = jqp.Compile(`$.`)
+	if _, ok := err.(*jqp.ParseError); !ok {
+		t.Fatalf("expected parse error, got: %T", err)
+	}
+}
+
+func TestMustCompile(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected MustCompile to panic on invalid query")
+		}
+	}()
+
+	jqp.MustCompile(`$ @`)
+}
+
+func TestQueryAll(t *testing.T) {
+	for i, c := range []struct {
+		json    string
+		query   string
+		results []interface{}
+	}{
+		{`{"a": 1, "b": 2}`, "$.a, $.b", []interface{}{1.0, 2.0}},
+		{`[1, 2, 3]`, "$[2, 0]", []interface{}{3.0, 1.0}},
+		{`[1, 2]`, "($[0], $[1]) | $ + 10", []interface{}{11, 12}},
+		{`[1, 2]`, "($[0], $[1]) + (10, 20)", []interface{}{11, 12, 21, 22}},
+		{`[[1]]`, "$[0]", []interface{}{[]interface{}{1.0}}},
+		{`{"a": "x"}`, "$.a, $.a + 'y', 'z'", []interface{}{"x", "xy", "z"}},
+		{`{"items": [{"n": 1}, {"n": 2}]}`, "$.items[].n", []interface{}{1.0, 2.0}},
+		{`{"items": [{"n": 1}, {"n": 2}]}`, "$.items[] | $.n + 1", []interface{}{2, 3}},
+		{`{"b": 2, "a": 1}`, "$[]", []interface{}{1.0, 2.0}},
+		{`[[1, 2], [3]]`, "$[][]", []interface{}{1.0, 2.0, 3.0}},
+		{`[1, 2, 3, 4, 5]`, "$[1:3]", []interface{}{[]interface{}{2.0, 3.0}}},
+		{`[1, 2, 3, 4, 5]`, "$[-2:]", []interface{}{[]interface{}{4.0, 5.0}}},
+		{`[1, 2, 3, 4, 5]`, "$[1.5:], $[:length / 2], $[-1.5:], $[0.5:1e300]", []interface{}{
+			[]interface{}{2.0, 3.0, 4.0, 5.0}, []interface{}{1.0, 2.0}, []interface{}{4.0, 5.0}, []interface{}{1.0, 2.0, 3.0, 4.0, 5.0},
+		}},
+		{`"abcde"`, "$[1.5:3.5], $[:-0.5]", []interface{}{"bc", "abcd"}},
+		{`[1, 2, 3, 4, 5]`, "$[:2], $[3:1], $[:10]", []interface{}{[]interface{}{1.0, 2.0}, []interface{}{}, []interface{}{1.0, 2.0, 3.0, 4.0, 5.0}}},
+		{`[1, 2, 3]`, "$[-1]", []interface{}{3.0}},
+		{`"abcdé"`, "$[1:3], $[-2:], $[:-4]", []interface{}{"bc", "dé", "a"}},
+		{`{"a": true, "b": false, "c": null}`, "$[]", []interface{}{true, false, nil}},
+		{`null`, "true, false, null", []interface{}{true, false, nil}},
+		{`{"a": null}`, "$.a.b, $.a[0], $.a[1:]", []interface{}{nil, nil, nil}},
+		{`{"detail": {"count": 2}}`, "$.detail.count > 0", []interface{}{true}},
+		{`[1, 2]`, "$[0] == 1, $[0] != 1, $[0] < 1, $[0] <= 1, $[0] > 1, $[0] >= 1", []interface{}{true, false, false, true, false, true}},
+		{`[1, "a"]`, "$[0] < $[1], 'b' > 'a', $ == $, null < false", []interface{}{true, true, true, true}},
+		{`{"a": [1, {"b": 2}]}`, "$.a == $.a, $.a[1] == $.a[0]", []interface{}{true, false}},
+		{`[1, 2, 3]`, "$[] > 1", []interface{}{false, true, true}},
+		{`null`, "true and false, true or false, (false, true) and true, (true, false) or false", []interface{}{false, true, false, true, true, false}},
+		{`null`, "1 and 'x', null or false, not, !1, !null", []interface{}{true, false, true, false, true}},
+		{`[0, 1]`, "$[] | ($ > 0 | not)", []interface{}{true, false}},
+		{`{"a": 1, "b": 0}`, "$.a > 0 and $.b < 1, $.a + 1 == 2 or false", []interface{}{true, true}},
+		{`null`, "1 + 2 * 3, (1 + 2) * 3, 7 - 2 - 1, 7 % 4, 7.5 % 2, 6 / 3, 1 / 2, 2.5 * 2", []interface{}{7, 9, 4, 3, 1, 2, 0.5, 5}},
+		{`null`, "null + 1, 'a' + null, null + null, -(1 - 3)", []interface{}{1, "a", nil, 2}},
+		{`null`, "9223372036854775807 + 1, 9223372036854775807 - -1, 4294967296 * 4294967296, -9223372036854775807 - 2, -(-9223372036854775807 - 1)",
+			[]interface{}{9223372036854775808.0, 9223372036854775808.0, 18446744073709551616.0, -9223372036854775809.0, 9223372036854775808.0}},
+		{`null`, "9223372036854775806 + 1, -9223372036854775807 - 1, 3037000499 * 3037000499, -1 * 9223372036854775807",
+			[]interface{}{9223372036854775807, -9223372036854775808, 9223372030926249001, -9223372036854775807}},
+		{`null`, "1e20 % 3, -1e20 % 3, -7.5 % 2, 5 % 1e30", []interface{}{1, -1, -1, 5}},
+		{`{"a": [1, 2, 3, 2], "b": [2]}`, "$.a - $.b, $.a + $.b", []interface{}{[]interface{}{1.0, 3.0}, []interface{}{1.0, 2.0, 3.0, 2.0, 2.0}}},
+		{`{"a": {"x": {"y": 1}, "z": 1}, "b": {"x": {"w": 2}}}`, "$.a + $.b, $.a * $.b", []interface{}{
+			map[string]interface{}{"x": map[string]interface{}{"w": 2.0}, "z": 1.0},
+			map[string]interface{}{"x": map[string]interface{}{"y": 1.0, "w": 2.0}, "z": 1.0},
+		}},
+		{`null`, "'ab' * 3, 2 * 'x', 'ab' * 0", []interface{}{"ababab", "xx", nil}},
+		{`{"x": {"y": 1}}`, "{a: .x | .y, b: .x | keys}", []interface{}{map[string]interface{}{"a": 1.0, "b": []interface{}{"y"}}}},
+		{`null`, "'' * 1e300, 'x' * -1e300, 'x' * 0.5", []interface{}{"", nil, "x"}},
+		{`null`, "'a, b, c' / ', ', '' / ','", []interface{}{[]interface{}{"a", "b", "c"}, []interface{}{}}},
+		{`[1, 2]`, "[$[] + 1], [], [$[] | $ > 5]", []interface{}{[]interface{}{2, 3}, []interface{}{}, []interface{}{false, false}}},
+		{`[[1], {"a": [2]}]`, "[$[]]", []interface{}{[]interface{}{[]interface{}{1.0}, map[string]interface{}{"a": []interface{}{2.0}}}}},
+		{`{"a": 1, "b": "x"}`, `{a, "c": $.b, (.b + "y"): [1, 2], null: 3}`, []interface{}{
+			map[string]interface{}{"a": 1.0, "c": "x", "xy": []interface{}{1, 2}, "null": 3},
+		}},
+		{`{"a": [1, 2], "b": ["x", "y"]}`, `{(.b[]): .a[]}`, []interface{}{
+			map[string]interface{}{"x": 1.0}, map[string]interface{}{"x": 2.0},
+			map[string]interface{}{"y": 1.0}, map[string]interface{}{"y": 2.0},
+		}},
+		{`{"a": [1, 2]}`, `{x: .a[], y: 0}, {}`, []interface{}{
+			map[string]interface{}{"x": 1.0, "y": 0}, map[string]interface{}{"x": 2.0, "y": 0}, map[string]interface{}{},
+		}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var v interface{}
+			err := json.Unmarshal([]byte(c.json), &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := jqp.QueryAll(c.query, v)
+			if err != nil {
+				t.Fatalf("query '%s' failed: %v", c.query, err)
+			}
+
+			if !reflect.DeepEqual(res, c.results) {
+				t.Fatalf("query '%s' on '%s' gave '%#v', expected: '%#v'", c.query, c.json, res, c.results)
+			}
+
+			// query should return the first output only
+			first, err := jqp.Query(c.query, v)
+			if err != nil || !reflect.DeepEqual(first, c.results[0]) {
+				t.Fatalf("query '%s' on '%s' gave '%#v', expected: '%#v'", c.query, c.json, first, c.results[0])
+			}
+		})
+	}
+}
+
+// builtinCase is a query on json input with the expected results
+type builtinCase struct {
+	json    string
+	query   string
+	results []interface{}
+}
+
+func TestBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`[1, "ab", null, {"a": 1}, -2.5]`, `.[] | length`, []interface{}{1.0, 2, 0, 1, 2.5}},
+		{`{"b": 1, "a": 2}`, `keys, length`, []interface{}{[]interface{}{"a", "b"}, 2}},
+		{`[3, 4]`, `keys`, []interface{}{[]interface{}{0, 1}}},
+		{`[1, null, 2]`, `[.[] | values]`, []interface{}{[]interface{}{1.0, 2.0}}},
+		{`{"a": 1}`, `has("a"), has("b")`, []interface{}{true, false}},
+		{`[1, 2]`, `has(1), has(2), has(-1)`, []interface{}{true, false, false}},
+		{`{"a": null}`, `"a", "b" | in({"a": 1})`, []interface{}{true, false}},
+		{`[null, true, 1, "s", [], {}]`, `.[] | type`, []interface{}{"null", "boolean", "number", "string", "array", "object"}},
+		{`[1, 2, 3]`, `map(. * 2), map(empty)`, []interface{}{[]interface{}{2, 4, 6}, []interface{}{}}},
+		{`{"a": 1, "b": 2}`, `map_values(. + 1), map(. + 1), map_values(empty)`, []interface{}{
+			map[string]interface{}{"a": 2, "b": 3}, []interface{}{2, 3}, map[string]interface{}{},
+		}},
+		{`[1, 5, 3]`, `.[] | select(. > 2)`, []interface{}{5.0, 3.0}},
+		{`null`, `[empty], [1, empty, 2]`, []interface{}{[]interface{}{}, []interface{}{1, 2}}},
+		{`[true, false, null]`, `map(not)`, []interface{}{[]interface{}{false, true, true}}},
+		{`[1, 2, 3]`, `add`, []interface{}{6}},
+		{`[[1], [2]]`, `add`, []interface{}{[]interface{}{1.0, 2.0}}},
+		{`[{"a": 1}, {"b": 2}]`, `add`, []interface{}{map[string]interface{}{"a": 1.0, "b": 2.0}}},
+		{`["a", null, "b"]`, `add`, []interface{}{"ab"}},
+		{`[]`, `add`, []interface{}{nil}},
+		{`[false, 1]`, `any, all, any(. == 1), all(. == 1), any(.[]; . == false), all(empty; false)`, []interface{}{true, false, true, false, true, true}},
+		{`null`, `[range(3)], [range(1; 3)], [range(0; 10; 4)], [range(3; 0; -1)], [range(0; 1; 0)]`, []interface{}{
+			[]interface{}{0, 1, 2}, []interface{}{1, 2}, []interface{}{0, 4, 8}, []interface{}{3, 2, 1}, []interface{}{},
+		}},
+		{`null`, `[range(0, 1; 3, 4)]`, []interface{}{[]interface{}{0, 1, 2, 0, 1, 2, 3, 1, 2, 1, 2, 3}}},
+		{`[1, 2, 3]`, `first, last, first(.[] | . * 10), last(.[]), [limit(2; .[])], [limit(0; .[])]`, []interface{}{
+			1.0, 3.0, 10, 3.0, []interface{}{1.0, 2.0}, []interface{}{},
+		}},
+		{`[]`, `first, last, [first(empty)]`, []interface{}{nil, nil, []interface{}{}}},
+		{`[1, "a"]`, `reverse, ("abc" | reverse), (null | reverse)`, []interface{}{[]interface{}{"a", 1.0}, "cba", []interface{}{}}},
+		{`{"a": [1, "x"]}`, `tostring, (.a[] | tostring)`, []interface{}{`{"a":[1,"x"]}`, "1", "x"}},
+		{`null`, `("12", "1.5", "-2e-1", 3) | tonumber`, []interface{}{12, 1.5, -0.2, 3}},
+		{`null`, `(("1e1000", "-1e1000") | tonumber), 1e1000, -1e1000, 1e1000 == ("1e999" | tonumber)`, []interface{}{
+			math.MaxFloat64, -math.MaxFloat64, math.MaxFloat64, -math.MaxFloat64, true,
+		}},
+	})
+}
+
+func TestStringBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`"a,b, c"`, `split(","), split(", *"; null), ("" | split(","))`, []interface{}{
+			[]interface{}{"a", "b", " c"}, []interface{}{"a", "b", "c"}, []interface{}{},
+		}},
+		{`["a", 1, null, true]`, `join("-"), ([] | join("-"))`, []interface{}{"a-1--true", ""}},
+		{`"foobar"`, `ltrimstr("foo"), rtrimstr("bar"), ltrimstr("bar"), ltrimstr(1), (1 | ltrimstr("a"))`, []interface{}{"bar", "foo", "foobar", "foobar", 1}},
+		{`"foobar"`, `startswith("foo"), startswith("bar"), endswith("bar")`, []interface{}{true, false, true}},
+		{`"aBc-Ü"`, `ascii_downcase, ascii_upcase`, []interface{}{"abc-Ü", "ABC-Ü"}},
+		{`"aé"`, `explode, (explode | implode)`, []interface{}{[]interface{}{97, 233}, "aé"}},
+		{`"foo bar"`, `test("BAR"), test("BAR"; "i"), test(["o b", "x"]), test("a b"; "x")`, []interface{}{false, true, false, false}},
+		{`"aé-b"`, `match("(?<l>[a-z])(x)?"; "g")`, []interface{}{
+			map[string]interface{}{"offset": 0, "length": 1, "string": "a", "captures": []interface{}{
+				map[string]interface{}{"offset": 0, "length": 1, "string": "a", "name": "l"},
+				map[string]interface{}{"offset": -1, "length": 0, "string": nil, "name": nil},
+			}},
+			map[string]interface{}{"offset": 3, "length": 1, "string": "b", "captures": []interface{}{
+				map[string]interface{}{"offset": 3, "length": 1, "string": "b", "name": "l"},
+				map[string]interface{}{"offset": -1, "length": 0, "string": nil, "name": nil},
+			}},
+		}},
+		{`"xyz"`, `[match("a*"; "g") | .offset], [match("a*"; "gn")]`, []interface{}{[]interface{}{0, 1, 2, 3}, []interface{}{}}},
+		{`"a1 b2"`, `capture("(?<l>[a-z])(?<n>[0-9])")`, []interface{}{map[string]interface{}{"l": "a", "n": "1"}}},
+		{`"a1 b2"`, `[scan("[a-z]")], [scan("([a-z])([0-9])")]`, []interface{}{
+			[]interface{}{"a", "b"}, []interface{}{[]interface{}{"a", "1"}, []interface{}{"b", "2"}},
+		}},
+		{`"a1 b2"`, `sub("[0-9]"; "#"), gsub("(?<d>[0-9])"; "<\(.d)>"), gsub("A"; "-"; "i"), [sub("b"; "x", "y")]`, []interface{}{
+			"a# b2", "a<1> b<2>", "-1 b2", []interface{}{"a1 x2", "a1 y2"},
+		}},
+		{`{"a": 1, "b": [2]}`, `"\(.a)-\(.b)", "\("x") \(.b[0] + 1)!", "n: \(null)"`, []interface{}{"1-[2]", "x 3!", "n: null"}},
+		{`null`, `"\(1, 2)-\(3, 4)"`, []interface{}{"1-3", "2-3", "1-4", "2-4"}},
+	})
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`[3, "a", null, [1], {}, true, 1.5, false]`, `sort`, []interface{}{
+			[]interface{}{nil, false, true, 1.5, 3.0, "a", []interface{}{1.0}, map[string]interface{}{}},
+		}},
+		{`[{"a": 2, "b": 1}, {"a": 1, "b": 2}, {"a": 2, "b": 0}]`, `(sort_by(.a) | map(.b)), (sort_by(.a, .b) | map(.b))`, []interface{}{
+			[]interface{}{2.0, 1.0, 0.0}, []interface{}{2.0, 0.0, 1.0},
+		}},
+		{`[{"a": 2, "b": 1}, {"a": 1, "b": 2}, {"a": 2, "b": 0}]`, `group_by(.a) | map(map(.b))`, []interface{}{
+			[]interface{}{[]interface{}{2.0}, []interface{}{1.0, 0.0}},
+		}},
+		{`[1, 2, 1, 3, 2]`, `unique, unique_by(. % 2)`, []interface{}{
+			[]interface{}{1.0, 2.0, 3.0}, []interface{}{2.0, 1.0},
+		}},
+		{`[{"n": 1, "i": 0}, {"n": 3, "i": 1}, {"n": 1, "i": 2}, {"n": 3, "i": 3}]`, `min.i, max.i, min_by(.n).i, max_by(.n).i, ([] | min, max)`, []interface{}{
+			0.0, 3.0, 0.0, 3.0, nil, nil,
+		}},
+		{`[1, [2, [3, [4]]], []]`, `flatten, flatten(1), flatten(0)`, []interface{}{
+			[]interface{}{1.0, 2.0, 3.0, 4.0},
+			[]interface{}{1.0, 2.0, []interface{}{3.0, []interface{}{4.0}}},
+			[]interface{}{1.0, []interface{}{2.0, []interface{}{3.0, []interface{}{4.0}}}, []interface{}{}},
+		}},
+		{`"a,bé, cd, d"`, `indices(", "), index(", "), rindex(", "), indices("x"), index("x")`, []interface{}{
+			[]interface{}{4, 8}, 4, 8, []interface{}{}, nil,
+		}},
+		{`[0, 1, 2, 1, 2, 1]`, `indices(1), indices([1, 2]), index(2), rindex([2, 1])`, []interface{}{
+			[]interface{}{1, 3, 5}, []interface{}{1, 3}, 2, 4,
+		}},
+		{`{"a": "foobar", "b": [1, {"c": 2, "d": 3}], "e": true}`, `contains({a: "bar"}), contains({b: [{c: 2}]}), contains({b: [4]}), contains({f: null})`, []interface{}{
+			true, true, false, false,
+		}},
+		{`"bar"`, `inside("foobar"), inside("baz"), ([1] | inside([1, 2])), (false | contains(false))`, []interface{}{true, false, true, true}},
+		{`[[1, 2], [3]]`, `transpose`, []interface{}{[]interface{}{[]interface{}{1.0, 3.0}, []interface{}{2.0, nil}}}},
+		{`{"b": 2, "a": 1}`, `to_entries, (to_entries | from_entries), with_entries({key, value: (.value + 1)})`, []interface{}{
+			[]interface{}{map[string]interface{}{"key": "a", "value": 1.0}, map[string]interface{}{"key": "b", "value": 2.0}},
+			map[string]interface{}{"a": 1.0, "b": 2.0},
+			map[string]interface{}{"a": 2, "b": 3},
+		}},
+		{`["a", "b"]`, `to_entries, with_entries(.value |= ascii_upcase), (to_entries | from_entries)`, []interface{}{
+			[]interface{}{map[string]interface{}{"key": 0, "value": "a"}, map[string]interface{}{"key": 1, "value": "b"}},
+			map[string]interface{}{"0": "A", "1": "B"},
+			map[string]interface{}{"0": "a", "1": "b"},
+		}},
+		{`[{"name": "a", "v": 1}, {"k": 1}, {"key": false, "Key": "b"}]`, `from_entries`, []interface{}{
+			map[string]interface{}{"a": 1.0, "1": nil, "b": nil},
+		}},
+	})
+}
+
+func TestPathBuiltins(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`{"a": [1, {"b": 2}]}`, `path(.a[1].b), path(.a[]), [paths], [paths(type == "number")]`, []interface{}{
+			[]interface{}{"a", 1, "b"},
+			[]interface{}{"a", 0}, []interface{}{"a", 1},
+			[]interface{}{[]interface{}{"a"}, []interface{}{"a", 0}, []interface{}{"a", 1}, []interface{}{"a", 1, "b"}},
+			[]interface{}{[]interface{}{"a", 0}, []interface{}{"a", 1, "b"}},
+		}},
+		{`[1, 2, 3]`, `path(.[1:]), path(.[] | select(. > 1)), path(first, last), [path(empty)], path(.)`, []interface{}{
+			[]interface{}{map[string]interface{}{"start": 1, "end": nil}},
+			[]interface{}{1}, []interface{}{2},
+			[]interface{}{0}, []interface{}{-1},
+			[]interface{}{},
+			[]interface{}{},
+		}},
+		{`{"a": {"b": 1}}`, `getpath(["a", "b"]), getpath(["x", "y"]), path(getpath(["a", "b"]))`, []interface{}{1.0, nil, []interface{}{"a", "b"}}},
+		{`{"a": [1, 2]}`, `getpath(["b"]), getpath(["a", 5]), getpath(["a", -5]), .a[1.5], (.b |= 1), (. as {b: $x} | $x), (.a as [$p, $q, $r] | $r)`, []interface{}{
+			nil, nil, nil, 2.0, map[string]interface{}{"a": []interface{}{1.0, 2.0}, "b": 1}, nil, nil,
+		}},
+		{`[1, 2]`, `getpath([1e300]), getpath([-1e300]), del(.[1e300], .[-1e300]), (.[-1.5] = 0)`, []interface{}{nil, nil, []interface{}{1.0, 2.0}, []interface{}{1.0, 0}}},
+		{`{"a": 1}`, `setpath(["b", 1]; 2), setpath([]; 3)`, []interface{}{
+			map[string]interface{}{"a": 1.0, "b": []interface{}{nil, 2}}, 3,
+		}},
+		{`[1, 2, 3, 4]`, `delpaths([[0], [2]]), del(.[1, 2]), del(.[:2]), del(.[5])`, []interface{}{
+			[]interface{}{2.0, 4.0}, []interface{}{1.0, 4.0}, []interface{}{3.0, 4.0}, []interface{}{1.0, 2.0, 3.0, 4.0},
+		}},
+		{`{"a": {"b": 1, "c": 2}}`, `del(.a.b), del(.a), del(.x.y)`, []interface{}{
+			map[string]interface{}{"a": map[string]interface{}{"c": 2.0}},
+			map[string]interface{}{},
+			map[string]interface{}{"a": map[string]interface{}{"b": 1.0, "c": 2.0}},
+		}},
+	})
+}
+
+func TestUpdateOperators(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`{"a": 1}`, `.a = 2, .b.c = .a, (.a, .b) = (3, 4)`, []interface{}{
+			map[string]interface{}{"a": 2},
+			map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": 1.0}},
+			map[string]interface{}{"a": 3, "b": 3},
+			map[string]interface{}{"a": 4, "b": 4},
+		}},
+		{`[1, 2, 3]`, `.[] |= . * 2, .[1:] |= [0], .[0] |= empty`, []interface{}{
+			[]interface{}{2, 4, 6},
+			[]interface{}{1.0, 0},
+			[]interface{}{2.0, 3.0},
+		}},
+		{`[1, 2, 3]`, `.[1.5:] |= [0], del(.[:length / 2]), .[0.5:2.5][]`, []interface{}{
+			[]interface{}{1.0, 0},
+			[]interface{}{2.0, 3.0},
+			1.0, 2.0,
+		}},
+		{`{"a": 1, "b": null}`, `.a += 1, .a -= 1, .a *= 3, .a /= 2, .a %= 1, .b //= 5, .a //= 5`, []interface{}{
+			map[string]interface{}{"a": 2, "b": nil},
+			map[string]interface{}{"a": 0, "b": nil},
+			map[string]interface{}{"a": 3, "b": nil},
+			map[string]interface{}{"a": 0.5, "b": nil},
+			map[string]interface{}{"a": 0, "b": nil},
+			map[string]interface{}{"a": 1.0, "b": 5},
+			map[string]interface{}{"a": 1.0, "b": nil},
+		}},
+		{`{"a": [1, 2]}`, `.a[] += (10, 20)`, []interface{}{
+			map[string]interface{}{"a": []interface{}{11, 12}},
+			map[string]interface{}{"a": []interface{}{21, 22}},
+		}},
+	})
+}
+
+func TestUpdateDoesNotMutate(t *testing.T) {
+	in := map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": 2}}}
+	res, err := jqp.QueryAll(`.a[1].b = 3, .a[0] |= . + 1, del(.a[1].b), setpath(["a", 0]; 5)`, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 4 {
+		t.Fatalf("expected 4 results, got: %v", res)
+	}
+
+	exp := map[string]interface{}{"a": []interface{}{1, map[string]interface{}{"b": 2}}}
+	if !reflect.DeepEqual(in, exp) {
+		t.Fatalf("input was modified to: %v", in)
+	}
+}
+
+func TestVariableBinding(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`{"a": 1, "b": [2, 3]}`, `.a as $x | .b[] | . + $x`, []interface{}{3, 4}},
+		{`[1, 2]`, `.[] as $x | .[] as $y | [$x, $y]`, []interface{}{
+			[]interface{}{1.0, 1.0}, []interface{}{1.0, 2.0}, []interface{}{2.0, 1.0}, []interface{}{2.0, 2.0},
+		}},
+		{`{"a": 1}`, `1 as $x | 2 as $y | [$x, $y, .a]`, []interface{}{[]interface{}{1, 2, 1.0}}},
+		{`{"a": 1}`, `(.a as $x | $x) + (2 as $x | $x), (1 as $x | 2 as $x | $x)`, []interface{}{3, 2}},
+		{`[1, {"b": 2, "c": [3]}]`, `. as [$a, {b: $b, $c: [$d]}] | [$a, $b, $c, $d]`, []interface{}{
+			[]interface{}{1.0, 2.0, []interface{}{3.0}, 3.0},
+		}},
+		{`{"a": "b", "b": 1}`, `. as {$a, ($a): $v, "c": [$n]} | [$a, $v, $n]`, []interface{}{[]interface{}{"b", 1.0, nil}}},
+		{`{"a": 1, "b": 2}`, `. as {("a", "b"): $v} | $v`, []interface{}{1.0, 2.0}},
+		{`[[1, 2], [3, 4]]`, `.[] as [$a, $b] | $a * $b`, []interface{}{2, 12}},
+		{`{"a": [1, 2]}`, `path(.a as $x | .a[$x[0]]), (1 as $i | .a[$i] |= 5)`, []interface{}{
+			[]interface{}{"a", 1.0},
+			map[string]interface{}{"a": []interface{}{1.0, 5}},
+		}},
+	})
+}
+
+func TestWithVar(t *testing.T) {
+	f, err := jqp.Compile(`[limit($limit; .[])], $name + "!", $tags[0]`,
+		jqp.WithVar("limit", 2),
+		jqp.WithVar("name", "x"),
+		jqp.WithVar("$tags", []string{"a"}))
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	res, err := f.RunAll([]interface{}{1, 2, 3})
+	if err != nil || !reflect.DeepEqual(res, []interface{}{[]interface{}{1, 2}, "x!", "a"}) {
+		t.Fatalf("unexpected result: %v (%v)", res, err)
+	}
+
+	// variables can be shadowed by bindings
+	shadowed, err := jqp.MustCompile(`1 as $x | $x`, jqp.WithVar("x", 2)).Run(nil)
+	if err != nil || shadowed != 1 {
+		t.Fatalf("expected binding to shadow the variable, got: %v (%v)", shadowed, err)
+	}
+
+	_, err = jqp.Compile(`$x`, jqp.WithVar("x", make(chan int)))
+	if err == nil || err.Error() != "jqp: invalid value for variable $x: jqp/value: cant convert this type from native: chan int" {
+		t.Fatalf("expected conversion error, got: %v", err)
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`[1, null, false, "a"]`, `.[] | if . then "yes" else "no" end`, []interface{}{"yes", "no", "no", "yes"}},
+		{`[1, 2, 3]`, `.[] | if . == 1 then "one" elif . == 2 then "two" end`, []interface{}{"one", "two", 3.0}},
+		{`{"a": true}`, `if .a, false, null then 1 else 2, 3 end`, []interface{}{1, 2, 3, 2, 3}},
+		{`{"detail": {}, "target": {"value": "x"}}`, `.detail.value // .target.value`, []interface{}{"x"}},
+		{`{"a": [null, 1, false, 2]}`, `.a[] // 3, (.a[0], .a[2]) // 3, empty // 4, error("x") // 5, (1, error("x")) // 6`, []interface{}{1.0, 2.0, 3, 4, 5, 1}},
+		{`{"a": 1}`, `.b?, .a?, (.[0]? // "no"), [.[]?], ([1] | .a?), "x"`, []interface{}{1.0, "no", []interface{}{1.0}, "x"}},
+		{`[[1], 2, {"a": 3}]`, `[.[] | .[0]?], [.[][]?]`, []interface{}{[]interface{}{1.0}, []interface{}{1.0}}},
+		{`null`, `try error("x") catch ., try error({a: 1}) catch .a, try (1 + "a") catch ., try error("y"), "z"`, []interface{}{
+			"x", 1, "cannot apply '+' to values of type 'int' and 'string'", "z",
+		}},
+		{`null`, `[try (1, 2, error("x"), 3) catch .], [.[]?], [(1, 2) | try error(.) catch .]`, []interface{}{
+			[]interface{}{1, 2, "x"}, []interface{}{}, []interface{}{1, 2},
+		}},
+		{`{"a": 1, "b": null}`, `path(.a // .b), path(.b // .c), path(if .a then .a else .b end), path(.c?), (.b // .a |= 5)`, []interface{}{
+			[]interface{}{"a"}, []interface{}{"c"}, []interface{}{"a"}, []interface{}{"c"}, map[string]interface{}{"a": 5, "b": nil},
+		}},
+		{`null`, `first(try (1, 2)), [limit(1; try (1, error("x")) catch 3)], [.[]?] | length`, []interface{}{1, 1, 0}},
+	})
+}
+
+func TestErrorsAfterTryAreNotCaught(t *testing.T) {
+	_, err := jqp.QueryAll(`(try 1 catch 2) | error("x")`, nil)
+	if err == nil || err.Error() != "jqp/value: x at position 18" {
+		t.Fatalf("expected error after try to propagate, got: %v", err)
+	}
+
+	_, err = jqp.QueryAll(`(1 // 2) | error("y")`, nil)
+	if err == nil || err.Error() != "jqp/value: y at position 11" {
+		t.Fatalf("expected error after alternative to propagate, got: %v", err)
+	}
+}
+
+func TestReduceAndRecursion(t *testing.T) {
+	os.Setenv("JQP_TEST_ENV", "x")
+	defer os.Unsetenv("JQP_TEST_ENV")
+
+	testBuiltins(t, []builtinCase{
+		{`[1, 2, 3]`, `reduce .[] as $x (0; . + $x), reduce .[] as $x (0, 10; . + $x), reduce empty as $x (1; 2)`, []interface{}{6, 6, 16, 1}},
+		{`[[1, 2], [3, 4]]`, `reduce .[] as [$a, $b] ({}; .["\($a)"] = $b), reduce .[] as $x (0; empty), reduce .[] as $x (0; 1, 2)`, []interface{}{
+			map[string]interface{}{"1": 2.0, "3": 4.0}, nil, 2,
+		}},
+		{`[1, 2, 3]`, `[foreach .[] as $x (0; . + $x)], [foreach .[] as $x (0; . + $x; [$x, .])]`, []interface{}{
+			[]interface{}{1, 3, 6},
+			[]interface{}{[]interface{}{1.0, 1}, []interface{}{2.0, 3}, []interface{}{3.0, 6}},
+		}},
+		{`[1, 2]`, `[foreach .[] as $x (0; . + $x, . - $x)], [foreach .[] as $x (0; empty)], [limit(3; foreach range(10) as $x (0; . + $x))]`, []interface{}{
+			[]interface{}{1, -1, 1, -3}, []interface{}{}, []interface{}{0, 1, 3},
+		}},
+		{`{"a": [1, {"b": 2}]}`, `[..], [recurse | .b?]`, []interface{}{
+			[]interface{}{
+				map[string]interface{}{"a": []interface{}{1.0, map[string]interface{}{"b": 2.0}}},
+				[]interface{}{1.0, map[string]interface{}{"b": 2.0}},
+				1.0, map[string]interface{}{"b": 2.0}, 2.0,
+			},
+			[]interface{}{2.0},
+		}},
+		{`{"a": [1, {"b": 2}]}`, `[.. | select(type == "number")], [path(..)], [paths] == [path(..)][1:]`, []interface{}{
+			[]interface{}{1.0, 2.0},
+			[]interface{}{[]interface{}{}, []interface{}{"a"}, []interface{}{"a", 0}, []interface{}{"a", 1}, []interface{}{"a", 1, "b"}},
+			true,
+		}},
+		{`2`, `[recurse(if . < 10 then . * 2 else empty end)], [recurse(. * 2; . < 10)], [limit(3; recurse(. + 1))]`, []interface{}{
+			[]interface{}{2.0, 4, 8, 16}, []interface{}{2.0, 4, 8}, []interface{}{2.0, 3, 4},
+		}},
+		{`{"a": {"b": {"a": 1}}}`, `[recurse(.a?; . != null)], [path(recurse(.a?; . != null))], (.. |= if type == "object" then .c = 1 else . end)`, []interface{}{
+			[]interface{}{map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"a": 1.0}}}, map[string]interface{}{"b": map[string]interface{}{"a": 1.0}}},
+			[]interface{}{[]interface{}{}, []interface{}{"a"}},
+			map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"a": 1.0, "c": 1}, "c": 1}, "c": 1},
+		}},
+		{`[1, [2, {"a": 3}]]`, `walk(if type == "number" then . + 1 else . end), walk(if type == "array" then sort else . end), walk(select(type != "object"))`, []interface{}{
+			[]interface{}{2, []interface{}{3, map[string]interface{}{"a": 4}}},
+			[]interface{}{1.0, []interface{}{2.0, map[string]interface{}{"a": 3.0}}},
+			[]interface{}{1.0, []interface{}{2.0}},
+		}},
+		{`{"a": [1, 2], "b": 3}`, `walk(if type == "number" then empty else . end), walk(if type == "number" then (., .) else . end)`, []interface{}{
+			map[string]interface{}{"a": []interface{}{}},
+			map[string]interface{}{"a": []interface{}{1.0, 1.0, 2.0, 2.0}, "b": 3.0},
+		}},
+		{`null`, `env | type, (env | length > 0), env.JQP_TEST_ENV`, []interface{}{"object", true, "x"}},
+	})
+}
+
+func TestFunctionDefinitions(t *testing.T) {
+	testBuiltins(t, []builtinCase{
+		{`[1, 2]`, `def inc: . + 1; map(inc), (.[0] | inc | inc)`, []interface{}{[]interface{}{2, 3}, 3}},
+		{`[1, 2]`, `def twice(f): f | f; def inc: . + 1; map(twice(inc)), [2 | twice(., . * 10)]`, []interface{}{
+			[]interface{}{3, 4}, []interface{}{2, 20, 20, 200},
+		}},
+		{`[1, 2]`, `def addvalue(f): f as $x | map(. + $x); addvalue(.[0]), [.[] as $x | def f: $x * 10; f]`, []interface{}{
+			[]interface{}{2, 3}, []interface{}{10, 20},
+		}},
+		{`[1, 2]`, `def f($a; $b): [$a, $b, a]; f(.[]; 10, 20)`, []interface{}{
+			[]interface{}{1.0, 10, 1.0}, []interface{}{1.0, 20, 1.0}, []interface{}{2.0, 10, 2.0}, []interface{}{2.0, 20, 2.0},
+		}},
+		{`5`, `def fac: if . <= 1 then 1 else . * (. - 1 | fac) end; fac, ([range(6)] | map(fac))`, []interface{}{
+			120, []interface{}{1, 1, 2, 6, 24, 120},
+		}},
+		{`null`, `def f: 1; def g: f; def f: 2; g, f, (def f: 3; g, f)`, []interface{}{1, 2, 1, 3}},
+		{`null`, `1 as $x | def f: $x; 2 as $x | f, $x`, []interface{}{1, 2}},
+		{`[3, 1, 2]`, `def length: "shadowed"; length, (def sort(f): "also"; sort, sort(.))`, []interface{}{"shadowed", []interface{}{1.0, 2.0, 3.0}, "also"}},
+		{`null`, `def f(x): x * 2; def g(x): f(x + 1); g(1), f(2)`, []interface{}{4, 4}},
+		{`null`, `def f(g): def h: g; 10 | h; 1 | f(. + 1)`, []interface{}{11}},
+		{`{"a": {"b": 1}}`, `def ab: .a.b; def at(p): p; path(ab), (ab |= . + 1), path(at(.a)), del(at(.a.b))`, []interface{}{
+			[]interface{}{"a", "b"},
+			map[string]interface{}{"a": map[string]interface{}{"b": 2}},
+			[]interface{}{"a"},
+			map[string]interface{}{"a": map[string]interface{}{}},
+		}},
+		{`[[1, [2]], 3]`, `def flat: if type == "array" then .[] | flat else . end; [flat]`, []interface{}{[]interface{}{1.0, 2.0, 3.0}}},
+	})
+}
+
+func TestSharedDefs(t *testing.T) {
+	defs, err := jqp.CompileDefs(`def inc: . + 1; def incn($n): reduce range($n) as $i (.; inc);`)
+	if err != nil {
+		t.Fatalf("failed to compile definitions: %v", err)
+	}
+
+	more, err := jqp.CompileDefs(`def double: incn(.);`, jqp.WithDefs(defs))
+	if err != nil {
+		t.Fatalf("failed to compile definitions: %v", err)
+	}
+
+	if more.String() != `def double: incn(.);` {
+		t.Fatalf("expected definitions to return their source, got: %s", more)
+	}
+
+	for _, c := range []struct {
+		query string
+		defs  *jqp.Defs
+		res   interface{}
+	}{
+		{`inc`, defs, 2},
+		{`incn(3)`, defs, 4},
+		{`def inc: . * 10; inc, incn(2)`, defs, []interface{}{10, 3}},
+		{`double`, more, 2},
+	} {
+		res, err := jqp.MustCompile(c.query, jqp.WithDefs(c.defs)).RunAll(1)
+		if exp, ok := c.res.([]interface{}); ok {
+			if err != nil || !reflect.DeepEqual(res, exp) {
+				t.Fatalf("query '%s' gave: %v (%v), expected: %v", c.query, res, err, exp)
+			}
+		} else if err != nil || !reflect.DeepEqual(res, []interface{}{c.res}) {
+			t.Fatalf("query '%s' gave: %v (%v), expected: %v", c.query, res, err, c.res)
+		}
+	}
+
+	lib, err := jqp.CompileDefs(`import "math" as m; def inc3: m::inc | m::inc | m::inc;`,
+		jqp.WithModuleLoader(jqp.MapLoader{"math": `def inc: . + 1;`}))
+	if err != nil {
+		t.Fatalf("failed to compile definitions with imports: %v", err)
+	}
+
+	if res, err := jqp.MustCompile(`inc3`, jqp.WithDefs(lib)).Run(1); err != nil || res != 4 {
+		t.Fatalf("expected imported definitions to be usable, got: %v (%v)", res, err)
+	}
+
+	if _, err = jqp.CompileDefs(`def f: 1; f`); err == nil || err.Error() != "jqp/parser: expected function definition, got: 10:Ident(f) at position 10" {
+		t.Fatalf("expected definitions to only hold definitions, got: %v", err)
+	}
+}
+
+func TestModules(t *testing.T) {
+	loader := jqp.MapLoader{
+		"math":      `module {version: 1}; def inc: . + 1; def twice(f): f | f;`,
+		"lib/util":  `include "math"; import "lib/names" as names; def inc2: twice(inc); def greet: names::hello;`,
+		"lib/names": `def hello: "hello " + .;`,
+		"cycle/a":   `import "cycle/b" as b; def a: 1;`,
+		"cycle/b":   `import "cycle/a" as a; def b: 2;`,
+		"broken":    `def f: 1`,
+		"private":   `def secret: 42; def open: secret;`,
+	}
+
+	for _, c := range []struct {
+		query string
+		in    interface{}
+		res   []interface{}
+	}{
+		{`import "math" as m; m::inc`, 1, []interface{}{2}},
+		{`include "math"; twice(inc)`, 1, []interface{}{3}},
+		{`import "lib/util" as u; u::inc2`, 1, []interface{}{3}},
+		{`import "lib/util" as u; u::greet`, "bob", []interface{}{"hello bob"}},
+		{`import "math" as m; def inc: 10; inc, m::inc`, 1, []interface{}{10, 2}},
+		{`import "math" as m; import "math" as n; m::inc | n::inc`, 1, []interface{}{3}},
+		{`import "private" as p; p::open`, nil, []interface{}{42}},
+		{`include "math" {search: "./"}; inc`, 1, []interface{}{2}},
+		{`"math" | modulemeta`, nil, []interface{}{map[string]interface{}{
+			"version": 1,
+			"deps":    []interface{}{},
+			"defs":    []interface{}{"inc/0", "twice/1"},
+		}}},
+		{`"lib/util" | modulemeta | .deps[]`, nil, []interface{}{
+			map[string]interface{}{"is_data": false, "relpath": "math"},
+			map[string]interface{}{"as": "names", "is_data": false, "relpath": "lib/names"},
+		}},
+	} {
+		res, err := jqp.MustCompile(c.query, jqp.WithModuleLoader(loader)).RunAll(c.in)
+		if err != nil || !reflect.DeepEqual(res, c.res) {
+			t.Fatalf("query '%s' gave: %v (%v), expected: %v", c.query, res, err, c.res)
+		}
+	}
+
+	// the modules are imported once and shared by concurrent runs
+	f := jqp.MustCompile(`import "lib/util" as u; u::inc2 | u::inc2`, jqp.WithModuleLoader(loader))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if res, err := f.Run(i); err != nil || res != i+4 {
+				t.Errorf("unexpected result for run %d: %v (%v)", i, res, err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, c := range []struct {
+		query string
+		err   string
+	}{
+		{`import "missing" as m; 1`, `jqp: module "missing": module not found`},
+		{`import "cycle/a" as a; 1`, `jqp: module "cycle/a": import cycle`},
+		{`include "broken"; 1`, `jqp: module "broken": jqp/parser: expected ';', found: 8:EOF at position 8, expression so far: <int 1>`},
+		{`import "private" as p; secret`, `jqp/value: function not defined: secret/0 at position 23`},
+		{`import "math" as m; inc`, `jqp/value: function not defined: inc/0 at position 20`},
+		{`"missing" | modulemeta`, `jqp: module "missing": module not found`},
+		{`"math" | ascii_downcase | modulemeta`, `jqp/value: module "math" was not loaded when the query was compiled at position 26`},
+	} {
+		f, err := jqp.Compile(c.query, jqp.WithModuleLoader(loader))
+		if err == nil {
+			_, err = f.Run(nil)
+		}
+
+		if err == nil || err.Error() != c.err {
+			t.Fatalf("query '%s' should fail with: \n\t%s got: \n\t%v", c.query, c.err, err)
+		}
+	}
+
+	_, err := jqp.Compile(`import "missing" as m; 1`, jqp.WithModuleLoader(loader))
+	if merr, ok := err.(*jqp.ModuleError); !ok || merr.Path != "missing" || !errors.Is(err, jqp.ErrModuleNotFound) {
+		t.Fatalf("expected a module error for the missing module, got: %#v", err)
+	}
+
+	if _, err = jqp.Query(`"math" | modulemeta`, nil); err == nil || !strings.Contains(err.Error(), "without a module loader") {
+		t.Fatalf("expected modulemeta to fail without a loader, got: %v", err)
+	}
+
+	// modulemeta describes the modules that were loaded when compiling
+	var loads int
+	counter := loaderFunc(func(path string) (string, error) {
+		loads++
+		return loader.LoadModule(path)
+	})
+
+	f = jqp.MustCompile(`import "lib/util" as u; ("lib/names", "math") | modulemeta | .defs`, jqp.WithModuleLoader(counter))
+	if loads != 3 {
+		t.Fatalf("expected 3 modules to be loaded when compiling, got: %d", loads)
+	}
+
+	if res, err := f.RunAll(nil); err != nil || loads != 3 || !reflect.DeepEqual(res, []interface{}{
+		[]interface{}{"hello/0"}, []interface{}{"inc/0", "twice/1"},
+	}) {
+		t.Fatalf("unexpected module metadata: %v (%v), loads: %d", res, err, loads)
+	}
+}
+
+// loaderFunc loads modules by calling the function
+type loaderFunc func(path string) (string, error)
+
+func (fn loaderFunc) LoadModule(path string) (string, error) { return fn(path) }
+
+func TestModuleLoaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jqp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "lib", "util"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"math.jq": "# math helpers\ndef inc: . + 1; # adds one\n",
+		"lib/util/util.jq": `# utilities
+import "math" as m; # the '#' in "#" is not a comment
+def inc2: m::inc | m::inc; # twice
+# end`,
+	}
+
+	for name, src := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, loader := range []jqp.ModuleLoader{
+		jqp.DirLoader(dir),
+		jqp.FSLoader(mapFS(files)),
+	} {
+		res, err := jqp.MustCompile(`import "lib/util" as u; u::inc2`, jqp.WithModuleLoader(loader)).Run(1)
+		if err != nil || res != 3 {
+			t.Fatalf("expected module to be loaded with %T, got: %v (%v)", loader, res, err)
+		}
+
+		for _, path := range []string{"../outside", "/math", "lib/../math", "lib/missing"} {
+			if _, err = loader.LoadModule(path); err == nil {
+				t.Fatalf("expected loading '%s' with %T to fail", path, loader)
+			}
+		}
+	}
+}
+
+// mapFS is a file system in memory, like an embed.FS
+type mapFS map[string]string
+
+func (fsys mapFS) ReadFile(name string) ([]byte, error) {
+	src, ok := fsys[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return []byte(src), nil
+}
+
+func TestGoFuncs(t *testing.T) {
+	slugify := func(in interface{}, args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, errors.New("slugify argument must be a string")
+		}
+
+		return strings.ToLower(strings.Replace(s, " ", "-", -1)), nil
+	}
+
+	add := func(in value.Value, args []value.Value) (value.Value, error) {
+		return value.Int(in.(value.Int) + args[0].(value.Int) + args[1].(value.Int)), nil
+	}
+
+	opts := []jqp.Option{
+		jqp.WithFunc("slugify", 1, slugify),
+		jqp.WithFunc("add", 2, add),
+		jqp.WithFunc("length", 0, func(in interface{}, args []interface{}) (interface{}, error) { return "custom", nil }),
+		jqp.WithFunc("input", 0, func(in interface{}, args []interface{}) (interface{}, error) { return in, nil }),
+		jqp.WithFunc("nothing", 0, func(in value.Value, args []value.Value) (value.Value, error) { return nil, nil }),
+	}
+
+	for _, c := range []struct {
+		query string
+		in    interface{}
+		res   []interface{}
+	}{
+		{`slugify(.title)`, map[string]interface{}{"title": "Hello World"}, []interface{}{"hello-world"}},
+		{`[.[] | slugify(.)]`, []interface{}{"A B", "C"}, []interface{}{[]interface{}{"a-b", "c"}}},
+		{`.a | add(1, 2; 10, 20)`, map[string]interface{}{"a": 100}, []interface{}{111, 121, 112, 122}},
+		{`length`, []interface{}{1}, []interface{}{"custom"}},
+		{`def length: 1; length`, nil, []interface{}{1}},
+		{`input`, map[string]interface{}{"a": []interface{}{1.5}}, []interface{}{map[string]interface{}{"a": []interface{}{1.5}}}},
+		{`nothing`, nil, []interface{}{nil}},
+		{`try slugify(1) catch .`, nil, []interface{}{"slugify argument must be a string"}},
+		{`[slugify(empty)]`, nil, []interface{}{[]interface{}{}}},
+	} {
+		res, err := jqp.MustCompile(c.query, opts...).RunAll(c.in)
+		if err != nil || !reflect.DeepEqual(res, c.res) {
+			t.Fatalf("query '%s' gave: %v (%v), expected: %v", c.query, res, err, c.res)
+		}
+	}
+
+	if _, err := jqp.MustCompile(`slugify(1)`, opts...).Run(nil); err == nil || err.Error() != "jqp/value: slugify argument must be a string at position 0" {
+		t.Fatalf("expected error of the function to be returned, got: %v", err)
+	}
+
+	if _, err := jqp.MustCompile(`.a |= input`, opts...).Run(map[string]interface{}{"a": 1}); err != nil {
+		t.Fatalf("expected function to be usable in an update, got: %v", err)
+	}
+
+	for _, c := range []struct {
+		opt jqp.Option
+		err string
+	}{
+		{jqp.WithFunc("f", 0, func() {}), "jqp: invalid function f/0: unsupported function type func()"},
+		{jqp.WithFunc("f", 0, nil), "jqp: invalid function f/0: function is nil"},
+		{jqp.WithFunc("$f", 0, slugify), "jqp: invalid function $f/0: name is not an identifier"},
+		{jqp.WithFunc("", 0, slugify), "jqp: invalid function /0: name is not an identifier"},
+		{jqp.WithFunc("foo bar", 0, slugify), "jqp: invalid function foo bar/0: name is not an identifier"},
+		{jqp.WithFunc("1x", 0, slugify), "jqp: invalid function 1x/0: name is not an identifier"},
+		{jqp.WithFunc("a-b", 0, slugify), "jqp: invalid function a-b/0: name is not an identifier"},
+		{jqp.WithFunc("if", 0, slugify), "jqp: invalid function if/0: name is not an identifier"},
+		{jqp.WithFunc("m::f", 0, slugify), "jqp: invalid function m::f/0: name is not an identifier"},
+		{jqp.WithFunc("f", -1, slugify), "jqp: invalid function f/-1: arity is negative"},
+	} {
+		if _, err := jqp.Compile(`1`, c.opt); err == nil || err.Error() != c.err {
+			t.Fatalf("expected compile to fail with: %s, got: %v", c.err, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, c := range []struct {
+		query  string
+		limits value.Limits
+		limit  string
+	}{
+		{`def f: f; f`, value.Limits{Steps: 1000}, "steps"},
+		{`last(range(1e9))`, value.Limits{Steps: 1000}, "steps"},
+		{`[limit(2000; 0 | recurse(. + 1))]`, value.Limits{Steps: 1000}, "steps"},
+		{`def f: 1 + f; f`, value.Limits{Depth: 100}, "depth"},
+		{`def f(g): g | f(g); f(.)`, value.Limits{Depth: 100}, "depth"},
+		{`range(10)`, value.Limits{Outputs: 3}, "outputs"},
+		{`[range(1000)]`, value.Limits{Size: 100}, "size"},
+		{`reduce range(100) as $i (""; . + "abcdefghij")`, value.Limits{Size: 500}, "size"},
+		{`{a: "\(range(1000))"}`, value.Limits{Size: 500}, "size"},
+		{`try (def f: f; f) catch "caught"`, value.Limits{Steps: 100}, "steps"},
+		{`(def f: f; f)?`, value.Limits{Steps: 100}, "steps"},
+		{`(def f: 1 + f; f) // 1`, value.Limits{Depth: 10}, "depth"},
+		{`[.[]?, ([range(100)] | error)?]`, value.Limits{Size: 50}, "size"},
+		{`[range(200)] | join("abcdefghij")`, value.Limits{Size: 1000}, "size"},
+		{`"abc" * 100 | ascii_upcase`, value.Limits{Size: 500}, "size"},
+		{`"abc" * 100 | explode`, value.Limits{Size: 500}, "size"},
+		{`[range(65; 365)] | implode`, value.Limits{Size: 500}, "size"},
+		{`"ab" * 100 | gsub("a"; "xyz")`, value.Limits{Size: 500}, "size"},
+	} {
+		_, err := jqp.MustCompile(c.query, jqp.WithLimits(c.limits)).RunAll([]interface{}{1})
+		var le *value.LimitError
+		if !errors.As(err, &le) || le.Limit != c.limit {
+			t.Fatalf("query '%s' should exceed the %s limit, got: %v", c.query, c.limit, err)
+		}
+	}
+
+	for _, c := range []struct {
+		query  string
+		limits value.Limits
+		res    []interface{}
+	}{
+		{`range(3)`, value.Limits{Outputs: 3, Steps: 100, Depth: 1, Size: 1}, []interface{}{0, 1, 2}},
+		{`def f: if . < 5 then . + 1 | f else . end; f`, value.Limits{Depth: 6}, []interface{}{5}},
+		{`try error("x") catch .`, value.Limits{Steps: 100}, []interface{}{"x"}},
+	} {
+		res, err := jqp.MustCompile(c.query, jqp.WithLimits(c.limits)).RunAll(0)
+		if err != nil || !reflect.DeepEqual(res, c.res) {
+			t.Fatalf("query '%s' gave: %v (%v), expected: %v", c.query, res, err, c.res)
+		}
+	}
+
+	_, err := jqp.MustCompile(`range(10)`, jqp.WithLimits(value.Limits{Outputs: 3})).RunAll(nil)
+	if err == nil || err.Error() != "jqp/value: evaluation exceeded the outputs limit of 3" {
+		t.Fatalf("expected the limit error to be returned as-is, got: %v", err)
+	}
+
+	if res, err := jqp.MustCompile(`range(10)`, jqp.WithLimits(value.Limits{Outputs: 1})).Run(nil); err != nil || res != 0 {
+		t.Fatalf("expected the first output within the limit, got: %v (%v)", res, err)
+	}
+
+	// values that would exceed the size limit fail before they are built
+	for _, q := range []string{`"x" * 100000000`, `[] | .[60000000] = 1`, `setpath([60000000]; 1)`} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := jqp.MustCompile(q, jqp.WithLimits(value.Limits{Size: 1000})).Run(nil)
+		runtime.ReadMemStats(&after)
+		if !errors.As(err, new(*value.LimitError)) || after.TotalAlloc-before.TotalAlloc > 1<<20 {
+			t.Fatalf("query '%s' should fail on the size limit before allocating, got: %v after %d bytes", q, err, after.TotalAlloc-before.TotalAlloc)
+		}
+	}
+
+	// only slices of Go arrays copy their elements
+	in := struct {
+		A [6]int
+		S []int
+	}{S: []int{1, 2, 3, 4, 5, 6}}
+
+	if _, err := jqp.MustCompile(`.A[1:], .A[2:]`, jqp.WithLimits(value.Limits{Size: 8})).RunAll(in); !errors.As(err, new(*value.LimitError)) {
+		t.Fatalf("expected slices of an array to exceed the size limit, got: %v", err)
+	}
+
+	if res, err := jqp.MustCompile(`.S[1:] | .[1:] | length`, jqp.WithLimits(value.Limits{Size: 1})).Run(in); err != nil || res != 4 {
+		t.Fatalf("expected slices of a slice to be within the size limit, got: %v (%v)", res, err)
+	}
+
+	large := make([]interface{}, 100000)
+	for _, q := range []string{`.[]`, `[.[]]`, `[.[]?]`, `[paths]`, `[.[2:][]]`} {
+		_, err := jqp.MustCompile(q, jqp.WithLimits(value.Limits{Steps: 10})).RunAll(large)
+		var le *value.LimitError
+		if !errors.As(err, &le) || le.Limit != "steps" {
+			t.Fatalf("query '%s' on a large slice should exceed the steps limit, got: %v", q, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, q := range []string{`last(range(1e9))`, `def f: f; f`, `try (def f: f; f) catch 1`, `(def f: f; f)?`} {
+		if _, err := jqp.MustCompile(q).RunContext(ctx, nil); err != context.Canceled {
+			t.Fatalf("query '%s' should be canceled, got: %v", q, err)
+		}
+	}
+
+	// values that would exceed the size limit fail before they are built
+	for _, q := range []string{`"x" * 100000000`, `[] | .[60000000] = 1`, `setpath([60000000]; 1)`} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := jqp.MustCompile(q, jqp.WithLimits(value.Limits{Size: 1000})).Run(nil)
+		runtime.ReadMemStats(&after)
+		if !errors.As(err, new(*value.LimitError)) || after.TotalAlloc-before.TotalAlloc > 1<<20 {
+			t.Fatalf("query '%s' should fail on the size limit before allocating, got: %v after %d bytes", q, err, after.TotalAlloc-before.TotalAlloc)
+		}
+	}
+
+	// only slices of Go arrays copy their elements
+	in := struct {
+		A [6]int
+		S []int
+	}{S: []int{1, 2, 3, 4, 5, 6}}
+
+	if _, err := jqp.MustCompile(`.A[1:], .A[2:]`, jqp.WithLimits(value.Limits{Size: 8})).RunAll(in); !errors.As(err, new(*value.LimitError)) {
+		t.Fatalf("expected slices of an array to exceed the size limit, got: %v", err)
+	}
+
+	if res, err := jqp.MustCompile(`.S[1:] | .[1:] | length`, jqp.WithLimits(value.Limits{Size: 1})).Run(in); err != nil || res != 4 {
+		t.Fatalf("expected slices of a slice to be within the size limit, got: %v (%v)", res, err)
+	}
+
+	large := make([]interface{}, 100000)
+	for _, q := range []string{`[.[]] | length`, `[.[]?]`, `[paths]`} {
+		if _, err := jqp.MustCompile(q).RunAllContext(ctx, large); err != context.Canceled {
+			t.Fatalf("query '%s' on a large slice should be canceled, got: %v", q, err)
+		}
+	}
+
+	// the deadline also stops busy evaluations on a single thread, as in js/wasm
+	for _, q := range []string{`[range(1e18)] | length`, `last(range(1e18))`, `def f: f; f`} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		if _, err := jqp.MustCompile(q).RunAllContext(ctx, nil); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+			t.Fatalf("expected the deadline to stop query '%s', got: %v after %s", q, err, time.Since(start))
+		}
+
+		cancel()
+	}
+
+	if res, err := jqp.MustCompile(`.a + 1`).RunContext(context.Background(), map[string]interface{}{"a": 1}); err != nil || res != 2 {
+		t.Fatalf("expected query to run with a context, got: %v (%v)", res, err)
+	}
+}
+
+// testBuiltins runs the queries on the json input and on the input as a
+// port, the builtins should give the same results for both.
+func testBuiltins(t *testing.T, cases []builtinCase) {
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var v interface{}
+			err := json.Unmarshal([]byte(c.json), &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// builtins should give the same results on ports
+			port, err := value.FromNative(v, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, in := range []interface{}{v, port} {
+				res, err := jqp.QueryAll(c.query, in)
+				if err != nil {
+					t.Fatalf("query '%s' on %T failed: %v", c.query, in, err)
+				}
+
+				if !reflect.DeepEqual(res, c.results) {
+					t.Fatalf("query '%s' on %T gave '%#v', expected: '%#v'", c.query, in, res, c.results)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryStopsAfterFirst(t *testing.T) {
+	var calls int
+	res, err := jqp.Query(`$(), $()`, func(args ...interface{}) interface{} {
+		calls++
+		return calls
+	})
+
+	if err != nil || res != 1 || calls != 1 {
+		t.Fatalf("expected query to stop after first output, got: %v (%v) after %d calls", res, err, calls)
+	}
+}
+
+func TestLimitStopsEvaluation(t *testing.T) {
+	var calls int
+	res, err := jqp.QueryAll(`[limit(2; $(), $(), $())], first($(), $())`, func(args ...interface{}) interface{} {
+		calls++
+		return calls
+	})
+
+	if err != nil || !reflect.DeepEqual(res, []interface{}{[]interface{}{1, 2}, 3}) || calls != 3 {
+		t.Fatalf("expected limit and first to stop the evaluation, got: %v (%v) after %d calls", res, err, calls)
+	}
+}
+
+func TestSetAndDelete(t *testing.T) {
+	tree := map[string]interface{}{
+		"a": []interface{}{1, map[string]interface{}{"b": 2, "c": 3}},
+		"d": "x",
+	}
+
+	if err := jqp.Set(`.a[1].b, .a[0], .e`, tree, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jqp.Delete(`.a[1].c, .d, .x`, tree); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]interface{}{
+		"a": []interface{}{5, map[string]interface{}{"b": 5}},
+		"e": 5,
+	}
+
+	if !reflect.DeepEqual(tree, exp) {
+		t.Fatalf("expected tree to be modified to: %v, got: %v", exp, tree)
+	}
+
+	user := &testUser{
+		Name:      "alice",
+		Addresses: []*testAddress{{City: "Amsterdam"}},
+		Extra:     1,
+		testMeta:  &testMeta{Tags: map[string]int{"a": 1, "b": 2}},
+	}
+
+	f := jqp.MustCompile(`.Name, .addresses[0].city, .Scores[1], .tags.a, .Extra`)
+	if err := f.Set(user, "2"); err == nil {
+		t.Fatal("expected setting a string on an int map and float array to fail")
+	}
+
+	for q, v := range map[string]interface{}{
+		`.Name, .addresses[0].city`: "bob",
+		`.Scores[1], .tags.a`:       2,
+		`.Extra`:                    []interface{}{1, "x"},
+		`.addresses[1:][]`:          nil,
+		`.Friend`:                   map[string]interface{}{"Name": "carol", "Scores": []int{1, 2, 3}},
+	} {
+		if err := jqp.Set(q, user, v); err != nil {
+			t.Fatalf("failed to set '%s': %v", q, err)
+		}
+	}
+
+	if err := jqp.Delete(`.tags.b`, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "bob" || user.Addresses[0].City != "bob" || user.Scores[1] != 2 ||
+		!reflect.DeepEqual(user.Tags, map[string]int{"a": 2}) || !reflect.DeepEqual(user.Extra, []interface{}{1, "x"}) ||
+		user.Friend == nil || user.Friend.Name != "carol" || user.Friend.Scores != [3]float64{1, 2, 3} {
+		t.Fatalf("unexpected struct after writing: %+v", user)
+	}
+
+	// numbers beyond the int64 range keep their precision in the big number mode
+	huge := json.Number("123456789012345678901234567890")
+	bigTree := map[string]interface{}{"a": []interface{}{0}, "n": &struct{ N *big.Int }{}}
+	if err := jqp.MustCompile(`.a[0], .b, .n.N`, jqp.WithBigNumbers()).Set(bigTree, huge); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := bigTree["n"].(*struct{ N *big.Int }).N; bigTree["a"].([]interface{})[0] != huge || bigTree["b"] != huge || n == nil || n.String() != huge.String() {
+		t.Fatalf("expected big numbers to be written exactly, got: %v", bigTree)
+	}
+}
+
+func TestSetAndDeleteErrors(t *testing.T) {
+	for i, c := range []struct {
+		root  interface{}
+		query string
+		del   bool
+		err   string
+	}{
+		{map[string]interface{}{}, `.`, false, "jqp/value: cannot write to path []: cannot write the root value in place"},
+		{map[string]interface{}{}, `.a.b`, false, `jqp/value: cannot write to path ["a","b"]: cannot write in place to value of type 'null'`},
+		{map[string]interface{}{"a": 1}, `.a.b`, false, "jqp/value: cannot index number with value of type 'string' at position 2"},
+		{[]interface{}{1}, `.[0] + 1`, false, "jqp/value: invalid path expression with result 2 at position 5"},
+		{[]interface{}{1}, `.[2]`, false, "jqp/value: cannot write to path [2]: index out of range: 2"},
+		{[]interface{}{1}, `.[0]`, true, "jqp/value: cannot write to path [0]: cannot delete element at index of type 'int' from array in place"},
+		{testUser{}, `.Name`, false, `jqp/value: cannot write to path ["Name"]: cannot set field 'Name' of a struct that is not addressable, use a pointer`},
+		{map[string]int{}, `.a`, false, `jqp/value: cannot write to path ["a"]: cannot assign value of type 'string' to 'int': json: cannot unmarshal string into Go value of type int`},
+		{&testUser{}, `.Name`, true, `jqp/value: cannot write to path ["Name"]: delete on struct port cargo is not supported`},
+		{nil, `.a b`, false, "jqp/parser: unexpected token '3:Ident(b)' at position 3, expression so far: (<var $> . <string a>)"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var err error
+			if c.del {
+				err = jqp.Delete(c.query, c.root)
+			} else {
+				err = jqp.Set(c.query, c.root, "x")
+			}
+
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("writing '%s' should fail with: \n\t%s got: \n\t%v", c.query, c.err, err)
+			}
+		})
+	}
+}
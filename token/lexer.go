@@ -1,10 +1,23 @@
 package token
 
 import (
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// LexError is returned when the input contains characters that
+// cannot be turned into tokens.
+type LexError struct {
+	Pos int    // byte position in the input
+	Msg string // description of the problem
+}
+
+func (e *LexError) Error() string {
+	return "jqp/token: " + e.Msg + " at position " + strconv.Itoa(e.Pos)
+}
+
 // stateFn represents the state of the scanner
 // as a function that returns the next state.
 type stateFn func(*lexer) stateFn
@@ -12,10 +25,16 @@ type stateFn func(*lexer) stateFn
 func lexAny(l *lexer) stateFn {
 	switch r := l.next(); {
 	case r == eof:
+		if len(l.interp) > 0 {
+			return l.errorf("unterminated string interpolation")
+		}
+
 		l.emit(EOF)
 		return nil //done
 	case unicode.IsSpace(r):
 		return lexSpace
+	case r == '#':
+		return lexComment
 	case unicode.IsDigit(r):
 		return lexNumber
 	case isAlphaNum(r):
@@ -24,6 +43,14 @@ func lexAny(l *lexer) stateFn {
 		return lexAny
 	case r == '\'':
 		return lexString
+	case r == '"':
+		return lexQuoted
+	case r == '{':
+		l.emit(LBrace)
+		return lexAny
+	case r == '}':
+		l.emit(RBrace)
+		return lexAny
 	case r == '[':
 		l.emit(LBrack)
 		return lexAny
@@ -31,13 +58,43 @@ func lexAny(l *lexer) stateFn {
 		l.emit(RBrack)
 		return lexAny
 	case r == '(':
+		if n := len(l.interp); n > 0 {
+			l.interp[n-1]++
+		}
+
 		l.emit(LParen)
 		return lexAny
 	case r == ')':
+		if n := len(l.interp); n > 0 {
+			if l.interp[n-1] == 0 {
+				l.interp = l.interp[:n-1]
+				return lexInterpolated
+			}
+
+			l.interp[n-1]--
+		}
+
 		l.emit(RParen)
 		return lexAny
+	case r == '|':
+		if l.peek() == '=' {
+			l.next()
+			l.emit(Update)
+			return lexAny
+		}
+		l.emit(Pipe)
+		return lexAny
+	case r == ':':
+		l.emit(Colon)
+		return lexAny
+	case r == ';':
+		l.emit(Semicolon)
+		return lexAny
+	case r == '?':
+		l.emit(Question)
+		return lexAny
 	default:
-		panic("unrecognized character: " + string(r))
+		return l.errorf("unrecognized character: " + strconv.QuoteRune(r))
 	}
 }
 
@@ -47,6 +104,11 @@ func lexNumber(l *lexer) stateFn {
 	var isFloat bool
 	for {
 		p := l.peek()
+		if (p == 'e' || p == 'E') && l.exponent() {
+			isFloat = true
+			continue
+		}
+
 		if p != '.' && !unicode.IsDigit(p) {
 			if isFloat {
 				l.emit(Float)
@@ -65,24 +127,120 @@ func lexNumber(l *lexer) stateFn {
 	}
 }
 
+// exponent consumes the exponent of a number if the input at the current
+// position is an 'e' followed by an optionally signed digit
+func (l *lexer) exponent() bool {
+	rest := l.input[l.pos+1:]
+	if len(rest) > 0 && (rest[0] == '+' || rest[0] == '-') {
+		rest = rest[1:]
+	}
+
+	if len(rest) == 0 || rest[0] < '0' || rest[0] > '9' {
+		return false
+	}
+
+	l.pos = len(l.input) - len(rest) + 1
+	return true
+}
+
 func lexString(l *lexer) stateFn {
 	l.ignore()
 	for {
-		if l.peek() == '\'' {
+		switch l.peek() {
+		case '\'':
 			l.emit(String)
 			l.next()
 			l.ignore()
 			return lexAny
+		case eof:
+			return l.errorf("unterminated string")
 		}
 
 		l.next()
 	}
 }
 
+// lexQuoted lexes a double quoted string in which the JSON escape
+// sequences are replaced by the characters they represent
+func lexQuoted(l *lexer) stateFn {
+	return l.quoted(String, InterpBeg)
+}
+
+// lexInterpolated continues lexing a double quoted string after
+// the closing parenthesis of an interpolated expression
+func lexInterpolated(l *lexer) stateFn {
+	return l.quoted(InterpEnd, InterpMid)
+}
+
+// quoted lexes the text of a double quoted string up to the closing
+// quote, which is emitted as 'end', or up to an interpolation '\(' which
+// is emitted as 'interp' after which the expression is lexed.
+func (l *lexer) quoted(end, interp TokenType) stateFn {
+	l.ignore()
+	var sb strings.Builder
+	for {
+		switch r := l.next(); r {
+		case '"':
+			l.prev()
+			l.emitText(end, sb.String())
+			l.next()
+			l.ignore()
+			return lexAny
+		case eof:
+			return l.errorf("unterminated string")
+		case '\\':
+			esc := l.next()
+			switch esc {
+			case '(':
+				l.emitText(interp, sb.String())
+				l.interp = append(l.interp, 0)
+				return lexAny
+			case '"', '\\', '/':
+				sb.WriteRune(esc)
+			case 'b':
+				sb.WriteRune('\b')
+			case 'f':
+				sb.WriteRune('\f')
+			case 'n':
+				sb.WriteRune('\n')
+			case 'r':
+				sb.WriteRune('\r')
+			case 't':
+				sb.WriteRune('\t')
+			case 'u':
+				if l.pos+4 > len(l.input) {
+					return l.errorf("invalid unicode escape")
+				}
+
+				cp, err := strconv.ParseUint(l.input[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return l.errorf("invalid unicode escape")
+				}
+
+				l.pos += 4
+				sb.WriteRune(rune(cp))
+			default:
+				return l.errorf("invalid escape sequence: " + strconv.QuoteRune(esc))
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+}
+
+// lexIdent scans an identifier, identifiers of functions in imported
+// modules are prefixed with the name of the module, as in 'mod::fn'
 func lexIdent(l *lexer) stateFn {
 	for {
+		if rest := l.input[l.pos:]; strings.HasPrefix(rest, "::") {
+			if r, _ := utf8.DecodeRuneInString(rest[2:]); r == '_' || unicode.IsLetter(r) {
+				l.pos += 2
+				continue
+			}
+		}
+
 		if !isAlphaNum(l.peek()) {
-			l.emit(Ident)
+			l.emit(Keyword(l.input[l.start:l.pos]))
 			return lexAny
 		}
 
@@ -98,8 +256,20 @@ func lexSpace(l *lexer) stateFn {
 	return lexAny
 }
 
-// Lex the input into token tokens
-func Lex(input string) []Token {
+// lexComment skips a comment from '#' to the end of the line, like
+// whitespace
+func lexComment(l *lexer) stateFn {
+	for r := l.peek(); r != '\n' && r != eof; r = l.peek() {
+		l.next()
+	}
+	l.ignore()
+	return lexAny
+}
+
+// Lex the input into tokens. If the input contains characters that
+// cannot be lexed a *LexError is returned together with the tokens
+// that were lexed up to that point.
+func Lex(input string) ([]Token, error) {
 	l := &lexer{
 		input: input,
 	}
@@ -108,13 +278,15 @@ func Lex(input string) []Token {
 		state = state(l)
 	}
 
-	return l.tokens
+	return l.tokens, l.err
 }
 
 // lexer holds the state the lexing process
 type lexer struct {
 	input  string  // the string that is being scanned
 	tokens []Token // the resulting tokens
+	err    error   // error that stopped the lexing, if any
+	interp []int   // open parentheses in each interpolated expression
 
 	pos   int // zero-based index into the input
 	start int // start position of this item
@@ -152,9 +324,15 @@ func (l *lexer) next() (rune rune) {
 // emit will append a result item while annotating the
 // last scanned text with the provided token
 func (l *lexer) emit(t TokenType) {
+	l.emitText(t, l.input[l.start:l.pos])
+}
+
+// emitText is like emit but annotates the token with
+// the provided text instead of the scanned text
+func (l *lexer) emitText(t TokenType, text string) {
 	l.tokens = append(l.tokens, Token{
 		Type: t,
-		Text: l.input[l.start:l.pos],
+		Text: text,
 		Pos:  l.start,
 	})
 
@@ -165,28 +343,31 @@ func (l *lexer) ignore() {
 	l.start = l.pos
 }
 
+// errorf emits an illegal token, records the error and returns
+// a nil state to stop the lexing.
+func (l *lexer) errorf(msg string) stateFn {
+	l.err = &LexError{Pos: l.start, Msg: msg}
+	l.tokens = append(l.tokens, Token{
+		Type: Illegal,
+		Text: l.input[l.start:l.pos],
+		Pos:  l.start,
+	})
+
+	return nil
+}
+
 // lexOperator report whether r is the start of an operator the
 // lexer ecountered an operator.
 func (l *lexer) lexOperator(r rune) bool {
 	switch r {
 
-	// only support as single char operators:
-	case '+':
-		l.emit(Add)
-		return true
-	case '-':
-		l.emit(Sub)
-		return true
-	case '*':
-		l.emit(Mul)
-		return true
-	case '/':
-		l.emit(Quo)
-		return true
-	case '%':
-		l.emit(Rem)
-		return true
+	// mostly supported as single char operators:
 	case '.':
+		if l.peek() == '.' {
+			l.next()
+			l.emit(DotDot)
+			return true
+		}
 		l.emit(Dot)
 		return true
 	case ',':
@@ -194,6 +375,18 @@ func (l *lexer) lexOperator(r rune) bool {
 		return true
 
 	// supported as also as multi char operators
+	case '+':
+		l.emitAssign(Add, AddAssign)
+		return true
+	case '-':
+		l.emitAssign(Sub, SubAssign)
+		return true
+	case '*':
+		l.emitAssign(Mul, MulAssign)
+		return true
+	case '%':
+		l.emitAssign(Rem, RemAssign)
+		return true
 	case '>':
 		if l.peek() == '=' {
 			l.next()
@@ -218,14 +411,21 @@ func (l *lexer) lexOperator(r rune) bool {
 		}
 		l.emit(Not)
 		return true
-
-	// supported only as multi char
+	case '/':
+		if l.peek() == '/' {
+			l.next()
+			l.emitAssign(Alt, AltAssign)
+			return true
+		}
+		l.emitAssign(Quo, QuoAssign)
+		return true
 	case '=':
-		if l.peek() != '=' {
-			return false
+		if l.peek() == '=' {
+			l.next()
+			l.emit(Equal)
+			return true
 		}
-		l.next()
-		l.emit(Equal)
+		l.emit(Assign)
 		return true
 
 	default:
@@ -233,6 +433,18 @@ func (l *lexer) lexOperator(r rune) bool {
 	}
 }
 
+// emitAssign emits the arithmetic update operator 'assign' if the
+// operator is followed by '=', or else the operator 'op' itself
+func (l *lexer) emitAssign(op, assign TokenType) {
+	if l.peek() == '=' {
+		l.next()
+		l.emit(assign)
+		return
+	}
+
+	l.emit(op)
+}
+
 // IsAlphaNum returns whether the rune is a valid start
 // of a javascript identifier.
 func isAlphaNum(r rune) bool {
@@ -17,6 +17,8 @@ func TestLexing(t *testing.T) {
 		{"", "[0:EOF]"},
 		{" \t\n", "[3:EOF]"},
 		{" \t(\n[)\t]", "[2:( 4:[ 5:) 7:] 8:EOF]"},
+		{"# comment", "[9:EOF]"},
+		{"1 # one\n# two\n+ '#'#", "[0:Int(1) 14:+ 17:String(#) 20:EOF]"},
 		{"11", "[0:Int(11) 2:EOF]"},
 		{"1 1", "[0:Int(1) 2:Int(1) 3:EOF]"},
 		{"1.1", "[0:Float(1.1) 3:EOF]"},
@@ -33,9 +35,33 @@ func TestLexing(t *testing.T) {
 		{"==1.0", "[0:== 2:Float(1.0) 5:EOF]"},
 		{"!=$", "[0:!= 2:Ident($) 3:EOF]"},
 		{"!1", "[0:! 1:Int(1) 2:EOF]"},
+		{"|", "[0:| 1:EOF]"},
+		{"= |= += -= *= /= %= //=", "[0:= 2:|= 5:+= 8:-= 11:*= 14:/= 17:%= 20://= 23:EOF]"},
+		{"1 ==2 = 3", "[0:Int(1) 2:== 4:Int(2) 6:= 8:Int(3) 9:EOF]"},
+		{"{a: 1}", "[0:{ 1:Ident(a) 2:: 4:Int(1) 5:} 6:EOF]"},
+		{`"foo"`, "[1:String(foo) 5:EOF]"},
+		{`"a\(1)b"`, `[1:"\((a) 4:Int(1) 6:)"(b) 8:EOF]`},
+		{`"\("x\(.)" + (1)) \(2)"`, `[1:"\(() 4:"\((x) 7:. 9:)"() 11:+ 13:( 14:Int(1) 15:) 17:)\(( ) 20:Int(2) 22:)"() 23:EOF]`},
+		{"range(1; 2)", "[0:Ident(range) 5:( 6:Int(1) 7:; 9:Int(2) 10:) 11:EOF]"},
+		{"1e17 1.5E-3 2e", "[0:Float(1e17) 5:Float(1.5E-3) 12:Int(2) 13:Ident(e) 14:EOF]"},
+		{`"a\"b\u00e9\n"`, "[1:String(a\"bé\n) 14:EOF]"},
+		{"$.a // 1", "[0:Ident($) 1:. 2:Ident(a) 4:// 7:Int(1) 8:EOF]"},
+		{"true false null nullable", "[0:true 5:false 11:null 16:Ident(nullable) 24:EOF]"},
+		{"$ and $ or not", "[0:Ident($) 2:and 6:Ident($) 8:or 11:Ident(not) 14:EOF]"},
+		{".a as $x | $x", "[0:. 1:Ident(a) 3:as 6:Ident($x) 9:| 11:Ident($x) 13:EOF]"},
+		{`import "a" as b; include "c"; b::f(1), {x::1}`, "[0:import 8:String(a) 11:as 14:Ident(b) 15:; 17:include 26:String(c) 28:; 30:Ident(b::f) 34:( 35:Int(1) 36:) 37:, 39:{ 40:Ident(x) 41:: 42:: 43:Int(1) 44:} 45:EOF]"},
+		{"def f($a; g): g; f", "[0:def 4:Ident(f) 5:( 6:Ident($a) 8:; 10:Ident(g) 11:) 12:: 14:Ident(g) 15:; 17:Ident(f) 18:EOF]"},
+		{"reduce ..[] as $x (0; .) | ...", "[0:reduce 7:.. 9:[ 10:] 12:as 15:Ident($x) 18:( 19:Int(0) 20:; 22:. 23:) 25:| 27:.. 29:. 30:EOF]"},
+		{"if .a? then try 1 catch 2 elif 3 else 4 end", "[0:if 3:. 4:Ident(a) 5:? 7:then 12:try 16:Int(1) 18:catch 24:Int(2) 26:elif 31:Int(3) 33:else 38:Int(4) 40:end 43:EOF]"},
+		{"$[]", "[0:Ident($) 1:[ 2:] 3:EOF]"},
+		{"$[1:-2]", "[0:Ident($) 1:[ 2:Int(1) 3:: 4:- 5:Int(2) 6:] 7:EOF]"},
+		{"$.a|$[0] | $", "[0:Ident($) 1:. 2:Ident(a) 3:| 4:Ident($) 5:[ 6:Int(0) 7:] 9:| 11:Ident($) 12:EOF]"},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			tokens := token.Lex(c.input)
+			tokens, err := token.Lex(c.input)
+			if err != nil {
+				t.Fatalf("lexing '%s' failed: %v", c.input, err)
+			}
 
 			//@TODO assert that token positions don't overlap
 
@@ -45,3 +71,35 @@ func TestLexing(t *testing.T) {
 		})
 	}
 }
+
+func TestLexingErrors(t *testing.T) {
+	for i, c := range []struct {
+		input  string
+		tokens string
+		err    string
+	}{
+		{"$ @", "[0:Ident($) 2:ILLEGAL]", "jqp/token: unrecognized character: '@' at position 2"},
+		{"'foo", "[1:ILLEGAL]", "jqp/token: unterminated string at position 1"},
+		{"1 ^ 2", "[0:Int(1) 2:ILLEGAL]", "jqp/token: unrecognized character: '^' at position 2"},
+		{`"foo`, "[1:ILLEGAL]", "jqp/token: unterminated string at position 1"},
+		{`"\x"`, "[1:ILLEGAL]", "jqp/token: invalid escape sequence: 'x' at position 1"},
+		{`"\(1`, `[1:"\(() 3:Int(1) 4:ILLEGAL]`, "jqp/token: unterminated string interpolation at position 4"},
+		{`"\u12"`, "[1:ILLEGAL]", "jqp/token: invalid unicode escape at position 1"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tokens, err := token.Lex(c.input)
+			lerr, ok := err.(*token.LexError)
+			if !ok {
+				t.Fatalf("lexing '%s' should return a lex error, got: %v", c.input, err)
+			}
+
+			if lerr.Error() != c.err {
+				t.Fatalf("lexing '%s' gave error: \n\t'%v' but expected:\n\t'%s'", c.input, lerr, c.err)
+			}
+
+			if fmt.Sprintf("%s", tokens) != c.tokens {
+				t.Fatalf("lexing '%s' caused: \n\t'%v' but expected:\n\t'%s'", c.input, tokens, c.tokens)
+			}
+		})
+	}
+}
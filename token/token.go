@@ -19,13 +19,44 @@ const (
 	String // 42
 
 	// grouping, calls, indexing, fields and func args
-	LParen // '('
-	RParen // ')'
-	LBrack // '['
-	RBrack // ']'
-	Dot    // '.'
-	Comma  // ','
-	// Pipe   // '|' @TODO add support
+	LParen    // '('
+	RParen    // ')'
+	LBrack    // '['
+	RBrack    // ']'
+	Dot       // '.'
+	DotDot    // '..'
+	Comma     // ','
+	Pipe      // '|'
+	Colon     // ':'
+	Semicolon // ';'
+	LBrace    // '{'
+	RBrace    // '}'
+	Question  // '?'
+
+	// string interpolation such as "a\(x)b\(y)c", the text of
+	// the tokens holds the literal parts around the expressions
+	InterpBeg // "a\(
+	InterpMid // )b\(
+	InterpEnd // )c"
+
+	// keywords
+	True    // true
+	False   // false
+	Null    // null
+	As      // as
+	If      // if
+	Then    // then
+	Elif    // elif
+	Else    // else
+	End     // end
+	Try     // try
+	Catch   // catch
+	Reduce  // reduce
+	Foreach // foreach
+	Def     // def
+	Import  // import
+	Include // include
+	Module  // module
 
 	// basic operators
 	_operator_beg
@@ -42,6 +73,19 @@ const (
 	LT       // <
 	GT       // >
 	Not      // !
+	And      // and
+	Or       // or
+	Alt      // //
+
+	// assignment and update operators
+	Assign    // =
+	Update    // |=
+	AddAssign // +=
+	SubAssign // -=
+	MulAssign // *=
+	QuoAssign // /=
+	RemAssign // %=
+	AltAssign // //=
 	_operator_end
 )
 
@@ -49,6 +93,50 @@ func (tt TokenType) IsOperator() bool {
 	return _operator_beg < tt && tt < _operator_end
 }
 
+// IsKeyword reports whether the token type is a reserved word
+func (tt TokenType) IsKeyword() bool {
+	for _, kt := range keywords {
+		if kt == tt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keywords maps reserved words to their token type
+var keywords = map[string]TokenType{
+	"true":    True,
+	"false":   False,
+	"null":    Null,
+	"as":      As,
+	"if":      If,
+	"then":    Then,
+	"elif":    Elif,
+	"else":    Else,
+	"end":     End,
+	"try":     Try,
+	"catch":   Catch,
+	"reduce":  Reduce,
+	"foreach": Foreach,
+	"def":     Def,
+	"import":  Import,
+	"include": Include,
+	"module":  Module,
+	"and":     And,
+	"or":      Or,
+}
+
+// Keyword returns the token type of the reserved word 'text', or Ident
+// if the text is not a reserved word
+func Keyword(text string) TokenType {
+	if tt, ok := keywords[text]; ok {
+		return tt
+	}
+
+	return Ident
+}
+
 func (tt TokenType) String() string {
 	var tokens = map[TokenType]string{
 		Illegal: "ILLEGAL",
@@ -64,15 +152,45 @@ func (tt TokenType) String() string {
 		LBrack: "[",
 		RBrack: "]",
 
-		Add:   "+",
-		Sub:   "-",
-		Mul:   "*",
-		Quo:   "/",
-		Rem:   "%",
-		Dot:   ".",
-		Comma: ",",
-
-		// Pipe: "|",
+		Add:    "+",
+		Sub:    "-",
+		Mul:    "*",
+		Quo:    "/",
+		Rem:    "%",
+		Dot:    ".",
+		DotDot: "..",
+		Comma:  ",",
+
+		Pipe:      "|",
+		Colon:     ":",
+		Semicolon: ";",
+
+		LBrace: "{",
+		RBrace: "}",
+
+		Question: "?",
+
+		InterpBeg: `"\(`,
+		InterpMid: `)\(`,
+		InterpEnd: `)"`,
+
+		True:    "true",
+		False:   "false",
+		Null:    "null",
+		As:      "as",
+		If:      "if",
+		Then:    "then",
+		Elif:    "elif",
+		Else:    "else",
+		End:     "end",
+		Try:     "try",
+		Catch:   "catch",
+		Reduce:  "reduce",
+		Foreach: "foreach",
+		Def:     "def",
+		Import:  "import",
+		Include: "include",
+		Module:  "module",
 
 		Equal:    "==",
 		NotEqual: "!=",
@@ -81,6 +199,18 @@ func (tt TokenType) String() string {
 		LT:       "<",
 		GT:       ">",
 		Not:      "!",
+		And:      "and",
+		Or:       "or",
+		Alt:      "//",
+
+		Assign:    "=",
+		Update:    "|=",
+		AddAssign: "+=",
+		SubAssign: "-=",
+		MulAssign: "*=",
+		QuoAssign: "/=",
+		RemAssign: "%=",
+		AltAssign: "//=",
 	}
 
 	s, ok := tokens[tt]
@@ -100,7 +230,7 @@ type Token struct {
 func (tok Token) String() string {
 	s := strconv.Itoa(tok.Pos) + ":" + tok.Type.String()
 	switch tok.Type {
-	case Int, Float, String, Ident:
+	case Int, Float, String, Ident, InterpBeg, InterpMid, InterpEnd:
 		return s + "(" + tok.Text + ")"
 	default:
 		return s
@@ -17,6 +17,24 @@ func TestTokenIsOperator(t *testing.T) {
 	}
 }
 
+func TestTokenIsKeyword(t *testing.T) {
+	if token.Ident.IsKeyword() == true {
+		t.Fatal("ident should not be keyword")
+	}
+
+	if token.Null.IsKeyword() == false || token.Keyword("null") != token.Null {
+		t.Fatal("null should be keyword")
+	}
+
+	if token.And.IsKeyword() == false || token.And.IsOperator() == false {
+		t.Fatal("and should be a keyword operator")
+	}
+
+	if token.Keyword("foo") != token.Ident {
+		t.Fatal("foo should not be a keyword")
+	}
+}
+
 func TestTokenTypeString(t *testing.T) {
 	if fmt.Sprint(token.Illegal) != "ILLEGAL" {
 		t.Fatalf("should print token type correctly")
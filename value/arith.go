@@ -0,0 +1,232 @@
+package value
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/advanderveer/jqp/token"
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+// maxRepeatLen is the maximum length in bytes of a repeated string
+const maxRepeatLen = 1 << 28
+
+// addNull implements addition with null on either side, which returns
+// the other operant as-is
+func addNull(u, v Value) (Value, error) {
+	switch {
+	case u.whichType() == nullType:
+		return v, nil
+	case v.whichType() == nullType:
+		return u, nil
+	default:
+		return nil, nil
+	}
+}
+
+// repeatString implements the multiplication of a string and a number
+// in either order. The string is repeated the number of times, zero or
+// negative times results in null. Strings that would be longer than
+// maxRepeatLen are an error.
+func repeatString(u, v Value) (Value, error) {
+	s, n, ok := repeatOperants(u, v)
+	if !ok {
+		return nil, nil
+	}
+
+	if math.IsNaN(n) {
+		return nil, errors.New("cannot repeat string a NaN number of times")
+	} else if n <= 0 {
+		return Null{}, nil
+	} else if n < 1 {
+		n = 1
+	}
+
+	if len(s) == 0 {
+		return s, nil
+	} else if n > float64(maxRepeatLen/len(s)) {
+		return nil, errors.New("repeated string would be longer than " + strconv.Itoa(maxRepeatLen) + " bytes")
+	}
+
+	return String(strings.Repeat(string(s), int(n))), nil
+}
+
+// repeatOperants returns the string and the number of times it is
+// repeated if one operant is a string and the other a number
+func repeatOperants(u, v Value) (String, float64, bool) {
+	if _, ok := v.(String); ok {
+		u, v = v, u
+	}
+
+	s, ok := u.(String)
+	if !ok {
+		return "", 0, false
+	}
+
+	switch vt := v.(type) {
+	case Int:
+		return s, float64(vt), true
+	case Float:
+		return s, float64(vt), true
+	default:
+		return "", 0, false
+	}
+}
+
+// repeatSize returns the length of the string that the operants repeat
+// to, capped just beyond maxRepeatLen, or zero if they don't repeat one
+func repeatSize(u, v Value) int {
+	s, n, ok := repeatOperants(u, v)
+	if !ok || !(n > 0) {
+		return 0
+	}
+
+	return int(math.Min(float64(len(s))*math.Max(n, 1), maxRepeatLen+1))
+}
+
+// splitString splits 's' around each instance of 'sep'
+func splitString(s, sep String) Value {
+	if s == "" {
+		return Array{}
+	}
+
+	parts := strings.Split(string(s), string(sep))
+	res := make(Array, len(parts))
+	for i, p := range parts {
+		res[i] = String(p)
+	}
+
+	return res
+}
+
+// concatArrays returns a new array with the elements of 'a' followed
+// by the elements of 'b'
+func concatArrays(a, b Array) Array {
+	res := make(Array, 0, len(a)+len(b))
+	return append(append(res, a...), b...)
+}
+
+// subtractArray returns the elements of 'a' that are not in 'b'
+func subtractArray(a, b Array) (Value, error) {
+	res := Array{}
+	for _, av := range a {
+		found := false
+		for _, bv := range b {
+			c, err := compare(av, bv)
+			if err != nil {
+				return nil, err
+			}
+
+			if c == 0 {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			res = append(res, av)
+		}
+	}
+
+	return res, nil
+}
+
+// mergeMaps returns a new map with the keys of 'b' added to those of
+// 'a'. If deep is true, maps that exist under the same key in both are
+// merged recursively, else the values of 'b' replace those of 'a'.
+func mergeMaps(a, b Map, deep bool) Map {
+	res := make(Map, len(a)+len(b))
+	for k, v := range a {
+		res[k] = v
+	}
+
+	for k, bv := range b {
+		if deep {
+			am, aok := res[k].(Map)
+			bm, bok := bv.(Map)
+			if aok && bok {
+				res[k] = mergeMaps(am, bm, true)
+				continue
+			}
+		}
+
+		res[k] = bv
+	}
+
+	return res
+}
+
+// arith applies the binary operator 'tt' to both operants, arithmetic
+// operators first prepare their operants with arithOperants.
+func arith(ctx Context, tt token.TokenType, lhs, rhs Value) (Value, error) {
+	switch tt {
+	case token.Add, token.Sub, token.Mul, token.Quo, token.Rem:
+		var err error
+		if lhs, rhs, err = arithOperants(ctx, lhs, rhs); err != nil {
+			return nil, err
+		}
+	}
+
+	if tt == token.Mul {
+		if err := ctx.Budget.fits(repeatSize(lhs, rhs)); err != nil {
+			return nil, err
+		}
+	}
+
+	return binaryOps[tt].apply(tt, lhs, rhs)
+}
+
+// arithOperants prepares the operants of an arithmetic operator. Ports
+// are expanded and numbers are prepared for the number mode of the
+// context: in the big number mode numbers are promoted to decimals such
+// that integers never overflow and decimal fractions are exact, otherwise
+// decimals are narrowed to an Int or Float.
+func arithOperants(ctx Context, lhs, rhs Value) (Value, Value, error) {
+	lhs, err := expand(lhs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rhs, err = expand(rhs); err != nil {
+		return nil, nil, err
+	}
+
+	if !ctx.BigNumbers {
+		if d, ok := lhs.(Decimal); ok {
+			lhs = d.narrow()
+		}
+
+		if d, ok := rhs.(Decimal); ok {
+			rhs = d.narrow()
+		}
+
+		return lhs, rhs, nil
+	}
+
+	if !isNumber(lhs) || !isNumber(rhs) {
+		return lhs, rhs, nil
+	}
+
+	if d, err := lhs.toType(decimalType); err == nil {
+		lhs = d
+	}
+
+	if d, err := rhs.toType(decimalType); err == nil {
+		rhs = d
+	}
+
+	return lhs, rhs, nil
+}
+
+// isNumber reports whether the value is an Int, Float or Decimal
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case Int, Float, Decimal:
+		return true
+	default:
+		return false
+	}
+}
@@ -18,26 +18,19 @@ func (a Array) String() string {
 	return "[" + strings.Join(vals, ", ") + "]"
 }
 
-func (a Array) Eval(ctx Context) Value {
-	return a
+func (a Array) Eval(ctx Context, emit Emit) error {
+	return emit(a)
 }
 
 func (a Array) whichType() valueType {
 	return arrayType
 }
 
-func (a Array) toType(which valueType) Value {
+func (a Array) toType(which valueType) (Value, error) {
 	switch which {
 	case arrayType:
-		return a
+		return a, nil
 	default:
-		panic("type coversion from '" + a.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(a, which)
 	}
 }
-
-func (a Array) shrink() Value {
-	if len(a) == 1 {
-		return a[0]
-	}
-	return a
-}
@@ -1,6 +1,10 @@
 package value
 
 import (
+	"errors"
+	"math"
+	"math/big"
+
 	"github.com/advanderveer/jqp/token"
 )
 
@@ -9,106 +13,311 @@ type Binary struct {
 	Op    token.TokenType
 	Left  Expr
 	Right Expr
+	Pos   int
 }
 
-// Eval will evaluate the binary operation
-func (b *Binary) Eval(ctx Context) Value {
+// Eval will evaluate the binary operation for every combination of
+// the outputs of both sides. The right side varies the slowest.
+func (b *Binary) Eval(ctx Context, emit Emit) error {
+	if err := ctx.Budget.step(); err != nil {
+		return err
+	}
+
+	switch b.Op {
+	case token.And, token.Or:
+		return b.evalLogical(ctx, emit)
+	case token.Alt:
+		return b.evalAlternative(ctx, emit)
+	case token.Assign:
+		return b.evalAssign(ctx, ctx.charge(emit))
+	case token.Update:
+		return b.evalUpdate(ctx, ctx.charge(emit))
+	case token.AddAssign, token.SubAssign, token.MulAssign, token.QuoAssign, token.RemAssign, token.AltAssign:
+		return b.evalArithUpdate(ctx, ctx.charge(emit))
+	case token.Dot, token.LBrack:
+		return b.evalIndex(ctx, emit)
+	case token.Add, token.Sub, token.Mul, token.Quo, token.Rem:
+		emit = ctx.charge(emit)
+	}
+
 	op := binaryOps[b.Op]
 	if op == nil {
-		panic("binary op not implemented: " + b.Op.String())
+		return evalError(b.Pos, b, errors.New("binary op not implemented: "+b.Op.String()))
 	}
 
 	// eval both sides
-	rhs := b.Right.Eval(ctx)
-	lhs := b.Left.Eval(ctx)
+	return b.Right.Eval(ctx, func(rhs Value) error {
+		return b.Left.Eval(ctx, func(lhs Value) error {
+			res, err := arith(ctx, b.Op, lhs, rhs)
+			if err != nil {
+				return evalError(b.Pos, b, err)
+			}
 
-	// determine the bigger type
-	bigger := op.biggerType(
-		lhs.whichType(),
-		rhs.whichType())
+			return emit(res)
+		})
+	})
+}
 
-	// promote both sides to the bigger type
-	lhs = lhs.toType(bigger)
-	rhs = rhs.toType(bigger)
+// EvalPath will emit the paths of field and index reads, missing
+// fields and indexes read as null, and the paths of the alternative
+// operator. Other operators are not path expressions.
+func (b *Binary) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	if b.Op == token.Alt {
+		return b.evalAlternativePath(ctx, path, emit)
+	}
 
-	// lookup the implementaiton for the type
-	impl := op.impl[bigger]
-	if impl == nil {
-		panic("no implementation for op: " + b.Op.String() + " and the bigger type: " + bigger.String())
+	if b.Op != token.Dot && b.Op != token.LBrack {
+		if err := evalNoPath(ctx, b); err != nil {
+			return evalError(b.Pos, b, err)
+		}
+
+		return nil
 	}
 
-	// call the actual implementation
-	return impl(lhs, rhs)
+	return b.Right.Eval(ctx, func(key Value) error {
+		return evalPath(ctx, b.Left, path, func(lp Array, v Value) error {
+			res, err := getKey(v, key)
+			if err != nil {
+				return evalError(b.Pos, b, err)
+			}
+
+			return emit(appendPath(lp, key), res)
+		})
+	})
+}
+
+// evalIndex reads fields and indexes, unlike their paths reading a
+// missing field or an index out of range is an error
+func (b *Binary) evalIndex(ctx Context, emit Emit) error {
+	return b.Right.Eval(ctx, func(key Value) error {
+		return b.Left.Eval(ctx, func(v Value) error {
+			res, err := readKey(v, key)
+			if err != nil {
+				return evalError(b.Pos, b, err)
+			}
+
+			return emit(res)
+		})
+	})
+}
+
+// evalLogical short-circuits 'and' and 'or': the right side is only
+// evaluated for outputs of the left side that don't decide the outcome.
+func (b *Binary) evalLogical(ctx Context, emit Emit) error {
+	return b.Left.Eval(ctx, func(lhs Value) error {
+		if truthy(lhs) == (b.Op == token.Or) {
+			return emit(Bool(truthy(lhs)))
+		}
+
+		return b.Right.Eval(ctx, func(rhs Value) error {
+			return emit(Bool(truthy(rhs)))
+		})
+	})
+}
+
+// evalAlternative implements 'a // b': it emits the outputs of the left
+// side that are neither false nor null. If there are none, because the
+// left side outputs nothing, only false and null or fails, the outputs of
+// the right side are emitted instead.
+func (b *Binary) evalAlternative(ctx Context, emit Emit) error {
+	var found bool
+	_, err := catch(func(mark func(err error) error) error {
+		return b.Left.Eval(ctx, func(lhs Value) error {
+			if !truthy(lhs) {
+				return nil
+			}
+
+			found = true
+			return mark(emit(lhs))
+		})
+	})
+
+	if err != nil || found {
+		return err
+	}
+
+	return b.Right.Eval(ctx, emit)
+}
+
+// evalAlternativePath is like evalAlternative but emits the paths of
+// the outputs instead
+func (b *Binary) evalAlternativePath(ctx Context, path Array, emit EmitPath) error {
+	var found bool
+	_, err := catch(func(mark func(err error) error) error {
+		return evalPath(ctx, b.Left, path, func(lp Array, lhs Value) error {
+			if !truthy(lhs) {
+				return nil
+			}
+
+			found = true
+			return mark(emit(lp, lhs))
+		})
+	})
+
+	if err != nil || found {
+		return err
+	}
+
+	return evalPath(ctx, b.Right, path, emit)
 }
 
 // binaryOps holds all implementations for the binary operations
 var binaryOps = map[token.TokenType]*binaryOp{
 
-	// addition and string concat
-	token.Add: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) Value{
-		intType:    func(u, v Value) Value { return Int(u.(Int) + v.(Int)) },
-		floatType:  func(u, v Value) Value { return Float(u.(Float) + v.(Float)).shrink() },
-		stringType: func(u, v Value) Value { return String(u.(String) + v.(String)) },
-	}},
-
-	// field reading
-	token.Dot: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) Value{
-		mapType: mapKeyReadingImpl,
-		portType: func(u, v Value) Value {
-			up := u.(Port)
-			vp := v.(Port)
-			return up.cargo.Get(string(vp.cargo.Get("").(String)))
+	// addition, concatenation and shallow merging, null is the identity
+	token.Add: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) (Value, error){
+		nullType:    func(u, v Value) (Value, error) { return u, nil },
+		intType:     func(u, v Value) (Value, error) { return addInts(u.(Int), v.(Int)), nil },
+		floatType:   func(u, v Value) (Value, error) { return Float(u.(Float) + v.(Float)).shrink(), nil },
+		decimalType: decimalArith((*big.Rat).Add),
+		stringType:  func(u, v Value) (Value, error) { return String(u.(String) + v.(String)), nil },
+		arrayType:   func(u, v Value) (Value, error) { return concatArrays(u.(Array), v.(Array)), nil },
+		mapType:     func(u, v Value) (Value, error) { return mergeMaps(u.(Map), v.(Map), false), nil },
+	}, addNull},
+
+	// subtraction and removal of array elements
+	token.Sub: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) (Value, error){
+		intType:     func(u, v Value) (Value, error) { return subInts(u.(Int), v.(Int)), nil },
+		floatType:   func(u, v Value) (Value, error) { return Float(u.(Float) - v.(Float)).shrink(), nil },
+		decimalType: decimalArith((*big.Rat).Sub),
+		arrayType:   func(u, v Value) (Value, error) { return subtractArray(u.(Array), v.(Array)) },
+	}, nil},
+
+	// multiplication, deep merging and string repetition
+	token.Mul: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) (Value, error){
+		intType:     func(u, v Value) (Value, error) { return mulInts(u.(Int), v.(Int)), nil },
+		floatType:   func(u, v Value) (Value, error) { return Float(u.(Float) * v.(Float)).shrink(), nil },
+		decimalType: decimalArith((*big.Rat).Mul),
+		mapType:     func(u, v Value) (Value, error) { return mergeMaps(u.(Map), v.(Map), true), nil },
+	}, repeatString},
+
+	// division and string splitting
+	token.Quo: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) (Value, error){
+		intType: func(u, v Value) (Value, error) {
+			if v.(Int) == 0 {
+				return nil, errDivisionByZero
+			}
+
+			return Float(float64(u.(Int)) / float64(v.(Int))).shrink(), nil
+		},
+		floatType: func(u, v Value) (Value, error) {
+			if v.(Float) == 0 {
+				return nil, errDivisionByZero
+			}
+
+			return Float(u.(Float) / v.(Float)).shrink(), nil
 		},
-	}},
-
-	// index reading
-	token.LBrack: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) Value{
-		arrayType: func(u, v Value) Value {
-			ua := u.(Array)
-			va := v.(Array)
-
-			//we expect the right side 'v' to be an array of index integers
-			//which will be used to read from the right side 'u' array of values
-			var vals = make(Array, len(va))
-			for i, iv := range va {
-				ii, ok := iv.(Int)
-				if !ok {
-					panic("non integer index")
-				}
-
-				//@TODO make sure it is not out-of-range
-				vals[i] = ua[int(ii)]
+		decimalType: decimalQuo,
+		stringType:  func(u, v Value) (Value, error) { return splitString(u.(String), v.(String)), nil },
+	}, nil},
+
+	// remainder of the integer division, floats are truncated. Floats
+	// are not converted to an Int as they may be outside of its range.
+	token.Rem: &binaryOp{binaryArithType, [_numTypes]func(u, v Value) (Value, error){
+		intType: func(u, v Value) (Value, error) {
+			if v.(Int) == 0 {
+				return nil, errDivisionByZero
 			}
 
-			// shrink before returning
-			return vals.shrink()
+			return u.(Int) % v.(Int), nil
 		},
+		floatType: func(u, v Value) (Value, error) {
+			x, y := math.Trunc(float64(u.(Float))), math.Trunc(float64(v.(Float)))
+			if y == 0 {
+				return nil, errDivisionByZero
+			}
 
-		portType: func(u, v Value) Value {
-			up := u.(Port)
-			vp := v.(Port)
-			idx := int(vp.cargo.Get("").(Int))
-			return up.cargo.Range(idx, idx+1)
+			return Float(math.Mod(x, y)).shrink(), nil
 		},
+		decimalType: decimalRem,
+	}, nil},
 
-		mapType: mapKeyReadingImpl,
-	}},
+	// comparison in the total order of values
+	token.Equal:    compareOp(func(c int) bool { return c == 0 }),
+	token.NotEqual: compareOp(func(c int) bool { return c != 0 }),
+	token.LT:       compareOp(func(c int) bool { return c < 0 }),
+	token.LTE:      compareOp(func(c int) bool { return c <= 0 }),
+	token.GT:       compareOp(func(c int) bool { return c > 0 }),
+	token.GTE:      compareOp(func(c int) bool { return c >= 0 }),
 }
 
-func mapKeyReadingImpl(u, v Value) Value {
-	um := u.(Map)
-	vm := v.(Map)
+type binaryOp struct {
+	biggerType func(a, b valueType) valueType             // which of the two binary operants to promote, nil dispatches on the left operant as-is
+	impl       [_numTypes]func(u, v Value) (Value, error) // implementations for each value type
+	mixed      func(u, v Value) (Value, error)            // optional implementation for operants of different types, nil result if not applicable
+}
 
-	key := string(vm["key"].(String))
-	val, ok := um[key]
-	if !ok {
-		panic("object doesn't have key: " + key)
+// apply the operator 'tt' to both operants
+func (op *binaryOp) apply(tt token.TokenType, lhs, rhs Value) (res Value, err error) {
+	lt, rt := lhs.whichType(), rhs.whichType()
+	if op.mixed != nil && lt != rt {
+		if res, err = op.mixed(lhs, rhs); res != nil || err != nil {
+			return res, err
+		}
 	}
 
-	return val
+	which := lt
+	if op.biggerType != nil {
+
+		// determine the bigger type
+		which = op.biggerType(lt, rt)
+
+		// promote both sides to the bigger type
+		if lhs, err = lhs.toType(which); err != nil {
+			return nil, operantError(tt, lt, rt)
+		}
+
+		if rhs, err = rhs.toType(which); err != nil {
+			return nil, operantError(tt, lt, rt)
+		}
+	}
+
+	// lookup the implementaiton for the type
+	impl := op.impl[which]
+	if impl == nil {
+		return nil, operantError(tt, lt, rt)
+	}
+
+	// call the actual implementation
+	return impl(lhs, rhs)
 }
 
-type binaryOp struct {
-	biggerType func(a, b valueType) valueType    // which of the two binary operants to promote
-	impl       [_numTypes]func(u, v Value) Value // implementations for each value type
+// operantError is returned when the operator cannot be applied to
+// operants of the given types
+func operantError(tt token.TokenType, lt, rt valueType) error {
+	if lt == rt {
+		return errors.New("cannot apply '" + tt.String() + "' to values of type '" + lt.String() + "'")
+	}
+
+	return errors.New("cannot apply '" + tt.String() + "' to values of type '" + lt.String() + "' and '" + rt.String() + "'")
+}
+
+// addInts adds the integers, a result that overflows an int64 is
+// returned as a Float. In the big number mode integers are added as
+// decimals, which don't overflow.
+func addInts(a, b Int) Value {
+	if s := a + b; (s > a) == (b > 0) {
+		return s
+	}
+
+	return Float(float64(a) + float64(b))
+}
+
+// subInts subtracts the integers, see addInts
+func subInts(a, b Int) Value {
+	if d := a - b; (d < a) == (b > 0) {
+		return d
+	}
+
+	return Float(float64(a) - float64(b))
+}
+
+// mulInts multiplies the integers, see addInts
+func mulInts(a, b Int) Value {
+	p := a * b
+	if a == 0 || (p/a == b && !(a == -1 && b == math.MinInt64)) {
+		return p
+	}
+
+	return Float(float64(a) * float64(b))
 }
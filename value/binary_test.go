@@ -10,6 +10,15 @@ import (
 	"github.com/advanderveer/jqp/value"
 )
 
+func mustFromNative(v interface{}, port bool) value.Value {
+	vv, err := value.FromNative(v, port)
+	if err != nil {
+		panic(err)
+	}
+
+	return vv
+}
+
 func testContext(v value.Value) value.Context {
 	return value.Context{Decl: map[value.Var]value.Value{
 		value.Var("$"): v,
@@ -112,7 +121,7 @@ func TestBinaryEvalWithoutInput(t *testing.T) {
 
 		// ported nested field reading
 		{testContext(
-			value.FromNative(map[string]interface{}{
+			mustFromNative(map[string]interface{}{
 				"foo": map[string]interface{}{
 					"foo2": map[string]interface{}{
 						"bar": 102,
@@ -132,7 +141,7 @@ func TestBinaryEvalWithoutInput(t *testing.T) {
 
 		// ported nested field and index reading
 		{testContext(
-			value.FromNative(map[string]interface{}{
+			mustFromNative(map[string]interface{}{
 				"foo": []interface{}{
 					map[string]interface{}{
 						"bar": 102,
@@ -155,7 +164,7 @@ func TestBinaryEvalWithoutInput(t *testing.T) {
 
 		// function type calling
 		{testContext(
-			value.FromNative(func(args ...interface{}) interface{} {
+			mustFromNative(func(args ...interface{}) interface{} {
 				return args[0].(int) + args[1].(int) + 103
 			}, false),
 		), &value.Call{
@@ -164,7 +173,7 @@ func TestBinaryEvalWithoutInput(t *testing.T) {
 
 		// nested function calling
 		{testContext(
-			value.FromNative(map[string]interface{}{
+			mustFromNative(map[string]interface{}{
 				"foo": []interface{}{
 					map[string]interface{}{
 						"bar": func(...interface{}) interface{} { return 100 },
@@ -185,28 +194,142 @@ func TestBinaryEvalWithoutInput(t *testing.T) {
 			Args: []value.Expr{}}, value.Int(100)},
 	}
 
+	var pipeCases = []c{
+
+		// output of the left becomes the input of the right
+		{testContext(value.Map{"foo": value.Array{value.Int(104)}}), &value.Pipe{
+			Left: &value.Binary{
+				Left:  value.Var("$"),
+				Op:    token.Dot,
+				Right: value.String("foo")},
+			Right: &value.Binary{
+				Left:  value.Var("$"),
+				Op:    token.LBrack,
+				Right: value.Int(0)}}, value.Int(104)},
+
+		// other declarations are retained in the right side
+		{value.Context{Decl: map[value.Var]value.Value{
+			value.Var("$"):   value.Int(1),
+			value.Var("bar"): value.Int(2),
+		}}, &value.Pipe{
+			Left: value.Int(3),
+			Right: &value.Binary{
+				Left:  value.Var("$"),
+				Op:    token.Add,
+				Right: value.Var("bar")}}, value.Int(5)},
+	}
+
 	for i, c := range [][]c{
 		evalCases,
 		addOpCases,
 		indexCases,
 		fieldCases,
 		callCases,
+		pipeCases,
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			for _, c := range c {
-				func() {
-					defer func() {
-						if r := recover(); r != nil {
-							t.Fatalf("panic while evaluating '%s':\n\t %v", jqp.Format(c.expr), r)
-						}
-					}()
-
-					res := c.expr.Eval(c.ctx)
-					if !reflect.DeepEqual(res, c.out) {
-						t.Fatalf("evaluating '%s' gave: '%v' expected: '%v' ", jqp.Format(c.expr), res, c.out)
-					}
-
-				}()
+				res, err := value.Collect(c.ctx, c.expr)
+				if err != nil {
+					t.Fatalf("error while evaluating '%s':\n\t %v", jqp.Format(c.expr), err)
+				}
+
+				if len(res) != 1 || !reflect.DeepEqual(res[0], c.out) {
+					t.Fatalf("evaluating '%s' gave: '%v' expected: '%v' ", jqp.Format(c.expr), res, c.out)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamEval(t *testing.T) {
+	for i, c := range []struct {
+		ctx  value.Context
+		expr value.Expr
+		out  []value.Value
+	}{
+		// comma emits left outputs and then right outputs
+		{value.Context{}, &value.Comma{
+			Left:  value.Int(1),
+			Right: value.String("foo"),
+		}, []value.Value{value.Int(1), value.String("foo")}},
+
+		// binary operations on the cartesian product, right side slowest
+		{value.Context{}, &value.Binary{
+			Left:  &value.Comma{Left: value.Int(1), Right: value.Int(2)},
+			Op:    token.Add,
+			Right: &value.Comma{Left: value.Int(10), Right: value.Int(20)},
+		}, []value.Value{value.Int(11), value.Int(12), value.Int(21), value.Int(22)}},
+
+		// multiple indexes
+		{testContext(value.Array{value.Int(1), value.Int(2), value.Int(3)}), &value.Binary{
+			Left:  value.Var("$"),
+			Op:    token.LBrack,
+			Right: &value.Comma{Left: value.Int(2), Right: value.Int(0)},
+		}, []value.Value{value.Int(3), value.Int(1)}},
+
+		// indexing a one element array doesn't unwrap nested arrays
+		{testContext(value.Array{value.Array{value.Int(1)}}), &value.Binary{
+			Left:  value.Var("$"),
+			Op:    token.LBrack,
+			Right: value.Int(0),
+		}, []value.Value{value.Array{value.Int(1)}}},
+
+		// pipe runs the right side for every output on the left
+		{value.Context{}, &value.Pipe{
+			Left: &value.Comma{Left: value.Int(1), Right: value.Int(2)},
+			Right: &value.Comma{
+				Left:  value.Var("$"),
+				Right: &value.Binary{Left: value.Var("$"), Op: token.Add, Right: value.Int(10)},
+			},
+		}, []value.Value{value.Int(1), value.Int(11), value.Int(2), value.Int(12)}},
+
+		// calling with each combination of arguments
+		{testContext(mustFromNative(func(args ...interface{}) interface{} {
+			return args[0].(int) * args[1].(int)
+		}, false)), &value.Call{
+			Func: value.Var("$"),
+			Args: []value.Expr{
+				&value.Comma{Left: value.Int(1), Right: value.Int(2)},
+				&value.Comma{Left: value.Int(3), Right: value.Int(4)},
+			},
+		}, []value.Value{value.Int(3), value.Int(4), value.Int(6), value.Int(8)}},
+
+		// iterating over a ported slice
+		{testContext(mustFromNative([]interface{}{1, "a"}, true)), &value.Iterate{
+			Left: value.Var("$"),
+		}, []value.Value{value.Int(1), value.String("a")}},
+
+		// iterating over a ported map in key order
+		{testContext(mustFromNative(map[string]interface{}{"b": 2, "a": 1}, true)), &value.Iterate{
+			Left: value.Var("$"),
+		}, []value.Value{value.Int(1), value.Int(2)}},
+
+		// slicing a ported slice, and indexing from the end
+		{testContext(mustFromNative([]interface{}{1, 2, 3}, true)), &value.Binary{
+			Left: &value.Slice{
+				Left: value.Var("$"),
+				From: value.Int(1),
+			},
+			Op:    token.LBrack,
+			Right: value.Int(-1),
+		}, []value.Value{value.Int(3)}},
+
+		// slicing with multiple bounds
+		{testContext(value.Array{value.Int(1), value.Int(2), value.Int(3)}), &value.Slice{
+			Left: value.Var("$"),
+			From: &value.Comma{Left: value.Int(0), Right: value.Int(1)},
+			To:   value.Int(2),
+		}, []value.Value{value.Array{value.Int(1), value.Int(2)}, value.Array{value.Int(2)}}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := value.Collect(c.ctx, c.expr)
+			if err != nil {
+				t.Fatalf("error while evaluating '%s':\n\t %v", jqp.Format(c.expr), err)
+			}
+
+			if !reflect.DeepEqual(res, c.out) {
+				t.Fatalf("evaluating '%s' gave: '%v' expected: '%v' ", jqp.Format(c.expr), res, c.out)
 			}
 		})
 	}
@@ -0,0 +1,143 @@
+package value
+
+import (
+	"errors"
+)
+
+// Bind declares the variables of a pattern for every output of the source
+// expression and evaluates the body with them, as in '.a as $x | $x + 1'.
+// The body has the same input as the source.
+type Bind struct {
+	Source  Expr
+	Pattern Pattern
+	Body    Expr
+	Pos     int
+}
+
+// Eval will evaluate the body for every output of the source
+func (b *Bind) Eval(ctx Context, emit Emit) error {
+	return b.Source.Eval(ctx, func(v Value) error {
+		return b.Pattern.Bind(ctx, v, func(ctx Context) error {
+			return b.Body.Eval(ctx, emit)
+		})
+	})
+}
+
+// EvalPath will evaluate the body as path expression for every
+// output of the source
+func (b *Bind) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return b.Source.Eval(ctx, func(v Value) error {
+		return b.Pattern.Bind(ctx, v, func(ctx Context) error {
+			return evalPath(ctx, b.Body, path, emit)
+		})
+	})
+}
+
+// Pattern destructures a value into variables. It is a Var, an
+// ArrayPattern or a MapPattern.
+type Pattern interface {
+
+	// Bind declares the variables of the pattern for the value and calls
+	// fn with a context that holds them. Keys in the pattern that output
+	// multiple values cause fn to be called for each. Errors returned by
+	// fn are returned as-is.
+	Bind(ctx Context, v Value, fn func(ctx Context) error) error
+}
+
+// Bind declares the variable as the value
+func (s Var) Bind(ctx Context, v Value, fn func(ctx Context) error) error {
+	return fn(ctx.with(s, v))
+}
+
+// ArrayPattern destructures the elements of an array, missing
+// elements are bound as null.
+type ArrayPattern struct {
+	Elems []Pattern
+	Pos   int
+}
+
+// Bind declares the variables of the element patterns
+func (ap *ArrayPattern) Bind(ctx Context, v Value, fn func(ctx Context) error) error {
+	return ap.bind(ctx, v, 0, fn)
+}
+
+// bind the patterns of the elements from index 'i' onwards
+func (ap *ArrayPattern) bind(ctx Context, v Value, i int, fn func(ctx Context) error) error {
+	if i == len(ap.Elems) {
+		return fn(ctx)
+	}
+
+	ev, err := getKey(v, Int(i))
+	if err != nil {
+		return evalError(ap.Pos, ap, err)
+	}
+
+	return ap.Elems[i].Bind(ctx, ev, func(ctx Context) error {
+		return ap.bind(ctx, v, i+1, fn)
+	})
+}
+
+// Eval makes the pattern an expression such that errors can refer to it,
+// patterns cannot be evaluated
+func (ap *ArrayPattern) Eval(ctx Context, emit Emit) error {
+	return evalError(ap.Pos, ap, errors.New("array pattern cannot be evaluated"))
+}
+
+// MapPatternEntry destructures the value of a single key. The value is
+// declared as Var, if it is not empty, and destructured by the Value
+// pattern, if it is not nil.
+type MapPatternEntry struct {
+	Key   Expr
+	Var   Var
+	Value Pattern
+}
+
+// MapPattern destructures the values of a map, missing keys are bound
+// as null. Key expressions can use the variables of earlier entries.
+type MapPattern struct {
+	Entries []MapPatternEntry
+	Pos     int
+}
+
+// Bind declares the variables of the entries
+func (mp *MapPattern) Bind(ctx Context, v Value, fn func(ctx Context) error) error {
+	return mp.bind(ctx, v, 0, fn)
+}
+
+// bind the entries from index 'i' onwards
+func (mp *MapPattern) bind(ctx Context, v Value, i int, fn func(ctx Context) error) error {
+	if i == len(mp.Entries) {
+		return fn(ctx)
+	}
+
+	entry := mp.Entries[i]
+	return entry.Key.Eval(ctx, func(k Value) error {
+		if _, ok := k.(String); !ok {
+			return evalError(mp.Pos, mp, errors.New("cannot index "+typeName(v)+" with value of type '"+k.whichType().String()+"'"))
+		}
+
+		ev, err := getKey(v, k)
+		if err != nil {
+			return evalError(mp.Pos, mp, err)
+		}
+
+		ectx := ctx
+		if entry.Var != "" {
+			ectx = ctx.with(entry.Var, ev)
+		}
+
+		if entry.Value == nil {
+			return mp.bind(ectx, v, i+1, fn)
+		}
+
+		return entry.Value.Bind(ectx, ev, func(ctx Context) error {
+			return mp.bind(ctx, v, i+1, fn)
+		})
+	})
+}
+
+// Eval makes the pattern an expression such that errors can refer to it,
+// patterns cannot be evaluated
+func (mp *MapPattern) Eval(ctx Context, emit Emit) error {
+	return evalError(mp.Pos, mp, errors.New("object pattern cannot be evaluated"))
+}
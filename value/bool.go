@@ -0,0 +1,36 @@
+package value
+
+import (
+	"strconv"
+)
+
+var _ Value = Bool(false)
+
+// Bool is either true or false
+type Bool bool
+
+func (b Bool) String() string                    { return strconv.FormatBool(bool(b)) }
+func (b Bool) Eval(ctx Context, emit Emit) error { return emit(b) }
+
+func (b Bool) whichType() valueType { return boolType }
+func (b Bool) toType(which valueType) (Value, error) {
+	switch which {
+	case boolType:
+		return b, nil
+	default:
+		return nil, conversionError(b, which)
+	}
+}
+
+// truthy reports whether the value counts as true in conditions, only
+// false and null are falsy
+func truthy(v Value) bool {
+	switch vt := v.(type) {
+	case Null:
+		return false
+	case Bool:
+		return bool(vt)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,19 @@
+package value
+
+import (
+	"testing"
+)
+
+func TestTruthiness(t *testing.T) {
+	for _, v := range []Value{Bool(true), Int(0), Float(0), String(""), Array{}, Map{}} {
+		if !truthy(v) {
+			t.Fatalf("expected '%s' to be truthy", v)
+		}
+	}
+
+	for _, v := range []Value{Bool(false), Null{}} {
+		if truthy(v) {
+			t.Fatalf("expected '%s' to be falsy", v)
+		}
+	}
+}
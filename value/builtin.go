@@ -0,0 +1,656 @@
+package value
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/advanderveer/jqp/token"
+)
+
+// BuiltinFunc implements a builtin function. It is called for every input
+// with the unevaluated arguments, which it can evaluate against any input.
+// Errors returned by emit must be returned as-is.
+type BuiltinFunc func(ctx Context, in Value, args []Expr, emit Emit) error
+
+// builtins holds the builtin functions by their name and arity, it is
+// filled in init as the builtins evaluate expressions that can invoke
+// builtins themselves.
+var builtins map[string]BuiltinFunc
+
+func init() {
+	builtins = map[string]BuiltinFunc{
+		"length/0":         builtinLength,
+		"keys/0":           builtinKeys,
+		"values/0":         builtinValues,
+		"has/1":            builtinHas,
+		"in/1":             builtinIn,
+		"type/0":           builtinType,
+		"map/1":            builtinMap,
+		"map_values/1":     builtinMapValues,
+		"select/1":         builtinSelect,
+		"empty/0":          builtinEmpty,
+		"error/0":          builtinError,
+		"error/1":          builtinError,
+		"not/0":            builtinNot,
+		"add/0":            builtinAdd,
+		"any/0":            anyAll(true),
+		"any/1":            anyAll(true),
+		"any/2":            anyAll(true),
+		"all/0":            anyAll(false),
+		"all/1":            anyAll(false),
+		"all/2":            anyAll(false),
+		"range/1":          builtinRange,
+		"range/2":          builtinRange,
+		"range/3":          builtinRange,
+		"first/0":          builtinFirst,
+		"first/1":          builtinFirst,
+		"last/0":           builtinLast,
+		"last/1":           builtinLast,
+		"limit/2":          builtinLimit,
+		"reverse/0":        builtinReverse,
+		"tostring/0":       builtinToString,
+		"tonumber/0":       builtinToNumber,
+		"split/1":          builtinSplit,
+		"split/2":          builtinSplit,
+		"join/1":           builtinJoin,
+		"ltrimstr/1":       trimStr(strings.TrimPrefix),
+		"rtrimstr/1":       trimStr(strings.TrimSuffix),
+		"startswith/1":     hasFix("startswith", strings.HasPrefix),
+		"endswith/1":       hasFix("endswith", strings.HasSuffix),
+		"ascii_downcase/0": asciiCase("ascii_downcase", 'A', 'a'),
+		"ascii_upcase/0":   asciiCase("ascii_upcase", 'a', 'A'),
+		"explode/0":        builtinExplode,
+		"implode/0":        builtinImplode,
+		"test/1":           builtinTest,
+		"test/2":           builtinTest,
+		"match/1":          eachMatch("match", false, emitMatch),
+		"match/2":          eachMatch("match", false, emitMatch),
+		"capture/1":        eachMatch("capture", false, emitCapture),
+		"capture/2":        eachMatch("capture", false, emitCapture),
+		"scan/1":           eachMatch("scan", true, emitScan),
+		"scan/2":           eachMatch("scan", true, emitScan),
+		"sub/2":            substitute("sub", false),
+		"sub/3":            substitute("sub", false),
+		"gsub/2":           substitute("gsub", true),
+		"gsub/3":           substitute("gsub", true),
+		"sort/0":           builtinSort,
+		"sort_by/1":        builtinSort,
+		"group_by/1":       builtinGroupBy,
+		"unique/0":         builtinUnique,
+		"unique_by/1":      builtinUnique,
+		"min/0":            minMax("min", false),
+		"max/0":            minMax("max", true),
+		"min_by/1":         minMax("min_by", false),
+		"max_by/1":         minMax("max_by", true),
+		"flatten/0":        builtinFlatten,
+		"flatten/1":        builtinFlatten,
+		"indices/1":        indexOf(pickAll),
+		"index/1":          indexOf(pickFirst),
+		"rindex/1":         indexOf(pickLast),
+		"contains/1":       builtinContains,
+		"inside/1":         builtinInside,
+		"transpose/0":      builtinTranspose,
+		"to_entries/0":     builtinToEntries,
+		"from_entries/0":   builtinFromEntries,
+		"with_entries/1":   builtinWithEntries,
+		"path/1":           builtinPath,
+		"paths/0":          builtinPaths,
+		"paths/1":          builtinPaths,
+		"getpath/1":        builtinGetPath,
+		"setpath/2":        builtinSetPath,
+		"delpaths/1":       builtinDelPaths,
+		"del/1":            builtinDel,
+		"recurse/0":        builtinRecurse,
+		"recurse/1":        builtinRecurse,
+		"recurse/2":        builtinRecurse,
+		"walk/1":           builtinWalk,
+		"env/0":            builtinEnv,
+		"modulemeta/0":     builtinModuleMeta,
+	}
+}
+
+// eachArg evaluates the argument with 'in' as its input and passes
+// every output to fn, it is used for arguments that are values.
+func eachArg(ctx Context, arg Expr, in Value, fn func(v Value) error) error {
+	return arg.Eval(ctx.with(Input, in), fn)
+}
+
+// typeName returns the name of the type of the value as jq names them
+func typeName(v Value) string {
+	switch vt := v.(type) {
+	case Bool:
+		return "boolean"
+	case Int, Float, Decimal:
+		return "number"
+	case Map:
+		return "object"
+	case Port:
+		if vt.cargo.IsArray() {
+			return "array"
+		}
+
+		return "object"
+	case Func:
+		return "function"
+	default:
+		return v.whichType().String()
+	}
+}
+
+// toJSON encodes the value as JSON text, ports are encoded with the
+// fields that 'keys' lists rather than as their Go value
+func toJSON(v Value) (string, error) {
+	xv, err := expandAll(v)
+	if err != nil {
+		return "", err
+	}
+
+	nv, err := ToNativeAs(xv, BigNumbers)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(nv); err != nil {
+		return "", errors.New("cannot encode value of type '" + v.whichType().String() + "' as json: " + err.Error())
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func builtinLength(ctx Context, in Value, args []Expr, emit Emit) error {
+	switch vt := in.(type) {
+	case Null:
+		return emit(Int(0))
+	case Int:
+		if vt < 0 {
+			return emit(-vt)
+		}
+
+		return emit(vt)
+	case Float:
+		return emit(Float(math.Abs(float64(vt))))
+	case Decimal:
+		return emit(Decimal{rat: new(big.Rat).Abs(vt.r())})
+	case String:
+		return emit(Int(utf8.RuneCountInString(string(vt))))
+	case Array:
+		return emit(Int(len(vt)))
+	case Map:
+		return emit(Int(len(vt)))
+	case Port:
+		return emit(Int(vt.cargo.Len()))
+	default:
+		return errors.New("value of type '" + in.whichType().String() + "' has no length")
+	}
+}
+
+func builtinKeys(ctx Context, in Value, args []Expr, emit Emit) error {
+	var n int
+	switch vt := in.(type) {
+	case Map:
+		return emit(stringArray(vt.keys()))
+	case Port:
+		if !vt.cargo.IsArray() {
+			return emit(stringArray(vt.cargo.Keys()))
+		}
+
+		n = vt.cargo.Len()
+	case Array:
+		n = len(vt)
+	default:
+		return errors.New("value of type '" + in.whichType().String() + "' has no keys")
+	}
+
+	keys := make(Array, n)
+	for i := range keys {
+		keys[i] = Int(i)
+	}
+
+	return emit(keys)
+}
+
+// stringArray converts strings to an array of string values
+func stringArray(ss []string) Array {
+	arr := make(Array, len(ss))
+	for i, s := range ss {
+		arr[i] = String(s)
+	}
+
+	return arr
+}
+
+func builtinValues(ctx Context, in Value, args []Expr, emit Emit) error {
+	if _, ok := in.(Null); ok {
+		return nil
+	}
+
+	return emit(in)
+}
+
+func builtinHas(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(k Value) error {
+		ok, err := hasKey(in, k)
+		if err != nil {
+			return err
+		}
+
+		return emit(Bool(ok))
+	})
+}
+
+func builtinIn(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		ok, err := hasKey(v, in)
+		if err != nil {
+			return err
+		}
+
+		return emit(Bool(ok))
+	})
+}
+
+// hasKey reports whether the map has the string key, or whether
+// the array has an element at the number key
+func hasKey(v, k Value) (bool, error) {
+	switch kt := k.(type) {
+	case String:
+		switch vt := v.(type) {
+		case Map:
+			_, ok := vt[string(kt)]
+			return ok, nil
+		case Port:
+			if !vt.cargo.IsArray() {
+				keys := vt.cargo.Keys()
+				i := sort.SearchStrings(keys, string(kt))
+				return i < len(keys) && keys[i] == string(kt), nil
+			}
+		}
+	case Int, Float, Decimal:
+		f, _ := kt.toType(floatType)
+		switch vt := v.(type) {
+		case Array:
+			return f.(Float) >= 0 && f.(Float) < Float(len(vt)), nil
+		case Port:
+			if vt.cargo.IsArray() {
+				return f.(Float) >= 0 && f.(Float) < Float(vt.cargo.Len()), nil
+			}
+		}
+	}
+
+	return false, errors.New("cannot check whether " + typeName(v) + " has a key of type '" + k.whichType().String() + "'")
+}
+
+func builtinType(ctx Context, in Value, args []Expr, emit Emit) error {
+	return emit(String(typeName(in)))
+}
+
+func builtinMap(ctx Context, in Value, args []Expr, emit Emit) error {
+	res := Array{}
+	if err := eachElem(ctx, in, func(ev Value) error {
+		return eachArg(ctx, args[0], ev, func(v Value) error {
+			res = append(res, v)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	return emit(res)
+}
+
+// firstOutput returns the first output of 'e' with input 'in', or
+// false if it didn't output anything
+func firstOutput(ctx Context, e Expr, in Value) (first Value, ok bool, err error) {
+	err = evalUntil(ctx, e, in, func(v Value) (bool, error) {
+		first, ok = v, true
+		return false, nil
+	})
+
+	return
+}
+
+func builtinMapValues(ctx Context, in Value, args []Expr, emit Emit) error {
+	in, err := expand(in)
+	if err != nil {
+		return err
+	}
+
+	switch vt := in.(type) {
+	case Array:
+		res := make(Array, 0, len(vt))
+		for _, ev := range vt {
+			v, ok, err := firstOutput(ctx, args[0], ev)
+			if err != nil {
+				return err
+			} else if ok {
+				res = append(res, v)
+			}
+		}
+
+		return emit(res)
+	case Map:
+		res := make(Map, len(vt))
+		for _, k := range vt.keys() {
+			v, ok, err := firstOutput(ctx, args[0], vt[k])
+			if err != nil {
+				return err
+			} else if ok {
+				res[k] = v
+			}
+		}
+
+		return emit(res)
+	default:
+		return iterateError(in)
+	}
+}
+
+func builtinSelect(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		if !truthy(v) {
+			return nil
+		}
+
+		return emit(in)
+	})
+}
+
+func builtinEmpty(ctx Context, in Value, args []Expr, emit Emit) error {
+	return nil
+}
+
+// ValueError is raised by the error builtin, it carries the value it was
+// called with.
+type ValueError struct {
+	Value Value
+}
+
+func (e *ValueError) Error() string {
+	if s, ok := e.Value.(String); ok {
+		return string(s)
+	}
+
+	s, err := toJSON(e.Value)
+	if err != nil {
+		s = e.Value.String()
+	}
+
+	return s + " (not a string)"
+}
+
+func builtinError(ctx Context, in Value, args []Expr, emit Emit) error {
+	if len(args) == 0 {
+		return &ValueError{in}
+	}
+
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		return &ValueError{v}
+	})
+}
+
+func builtinNot(ctx Context, in Value, args []Expr, emit Emit) error {
+	return emit(Bool(!truthy(in)))
+}
+
+func builtinAdd(ctx Context, in Value, args []Expr, emit Emit) error {
+	var sum Value = Null{}
+	if _, ok := in.(Null); ok {
+		return emit(sum)
+	}
+
+	if err := eachElem(ctx, in, func(ev Value) (err error) {
+		sum, err = arith(ctx, token.Add, sum, ev)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return emit(sum)
+}
+
+// anyAll creates the any and all builtins, which look for an element that
+// is truthy or falsy respectively. Without arguments the elements of the
+// input are checked, with one argument the outputs of it for every element,
+// and with two the outputs of the condition for every output of the generator.
+func anyAll(want bool) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		var found bool
+		check := func(v Value) (bool, error) {
+			found = truthy(v) == want
+			return !found, nil
+		}
+
+		var err error
+		switch len(args) {
+		case 0:
+			err = evalUntil(ctx, &Iterate{Left: Input}, in, check)
+		case 1:
+			err = evalUntil(ctx, &Pipe{Left: &Iterate{Left: Input}, Right: args[0]}, in, check)
+		default:
+			err = evalUntil(ctx, &Pipe{Left: args[0], Right: args[1]}, in, check)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return emit(Bool(found == want))
+	}
+}
+
+func builtinRange(ctx Context, in Value, args []Expr, emit Emit) error {
+	return cartesian(ctx.with(Input, in), args, nil, func(argv []Value) error {
+		from, upto, by := Value(Int(0)), argv[0], Value(Int(1))
+		if len(argv) > 1 {
+			from, upto = argv[0], argv[1]
+		}
+
+		if len(argv) > 2 {
+			by = argv[2]
+		}
+
+		if !isNumber(from) || !isNumber(upto) || !isNumber(by) {
+			return errors.New("range bounds must be numbers")
+		}
+
+		dir, err := compare(by, Int(0))
+		if err != nil || dir == 0 {
+			return err
+		}
+
+		for x := from; ; {
+			if c, err := compare(x, upto); err != nil || c*dir >= 0 {
+				return err
+			}
+
+			if err = emit(x); err != nil {
+				return err
+			}
+
+			if x, err = arith(ctx, token.Add, x, by); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func builtinFirst(ctx Context, in Value, args []Expr, emit Emit) error {
+	if len(args) == 0 {
+		return emitElem(in, 0, emit)
+	}
+
+	v, ok, err := firstOutput(ctx, args[0], in)
+	if err != nil || !ok {
+		return err
+	}
+
+	return emit(v)
+}
+
+func builtinLast(ctx Context, in Value, args []Expr, emit Emit) error {
+	if len(args) == 0 {
+		return emitElem(in, -1, emit)
+	}
+
+	var last Value
+	if err := eachArg(ctx, args[0], in, func(v Value) error {
+		last = v
+		return nil
+	}); err != nil || last == nil {
+		return err
+	}
+
+	return emit(last)
+}
+
+// emitElem emits the element at index 'i' of an array, negative indexes
+// count from the end. Null is emitted for missing elements and null.
+func emitElem(v Value, i int, emit Emit) error {
+	var n int
+	switch vt := v.(type) {
+	case Null:
+		return emit(vt)
+	case Array:
+		n = len(vt)
+	case Port:
+		if !vt.cargo.IsArray() {
+			return errors.New("cannot index object with number")
+		}
+
+		n = vt.cargo.Len()
+	default:
+		return errors.New("cannot index value of type '" + v.whichType().String() + "' with number")
+	}
+
+	if i < 0 {
+		i += n
+	}
+
+	if i < 0 || i >= n {
+		return emit(Null{})
+	}
+
+	if p, ok := v.(Port); ok {
+		ev, err := p.cargo.Index(i)
+		if err != nil {
+			return err
+		}
+
+		return emit(ev)
+	}
+
+	return emit(v.(Array)[i])
+}
+
+func builtinLimit(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(nv Value) error {
+		if !isNumber(nv) {
+			return errors.New("limit must be a number")
+		}
+
+		f, _ := nv.toType(floatType)
+		n := float64(f.(Float))
+		if n < 0 {
+			return errors.New("limit must not be negative")
+		} else if n == 0 {
+			return nil
+		}
+
+		var seen float64
+		return evalUntil(ctx, args[1], in, func(v Value) (bool, error) {
+			seen++
+			return seen < n, emit(v)
+		})
+	})
+}
+
+func builtinReverse(ctx Context, in Value, args []Expr, emit Emit) error {
+	in, err := expand(in)
+	if err != nil {
+		return err
+	}
+
+	switch vt := in.(type) {
+	case Null:
+		return emit(Array{})
+	case String:
+		runes := []rune(string(vt))
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+
+		return emit(String(runes))
+	case Array:
+		res := make(Array, len(vt))
+		for i := range vt {
+			res[len(vt)-1-i] = vt[i]
+		}
+
+		return emit(res)
+	default:
+		return errors.New("cannot reverse value of type '" + in.whichType().String() + "'")
+	}
+}
+
+func builtinToString(ctx Context, in Value, args []Expr, emit Emit) error {
+	s, err := stringify(in)
+	if err != nil {
+		return err
+	}
+
+	return emit(s)
+}
+
+// stringify returns strings as-is and other values as JSON text
+func stringify(v Value) (String, error) {
+	if s, ok := v.(String); ok {
+		return s, nil
+	}
+
+	s, err := toJSON(v)
+	return String(s), err
+}
+
+func builtinToNumber(ctx Context, in Value, args []Expr, emit Emit) error {
+	switch vt := in.(type) {
+	case Int, Float, Decimal:
+		return emit(vt)
+	case String:
+		s := string(vt)
+		if !isDecimalText(s) {
+			return errors.New("cannot parse " + strconv.Quote(s) + " as number")
+		}
+
+		if i64, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return emit(Int(i64))
+		}
+
+		if ctx.BigNumbers {
+			d, err := ParseDecimal(s)
+			if err != nil {
+				return errors.New("cannot parse " + strconv.Quote(string(vt)) + " as number")
+			}
+
+			return emit(d.shrink())
+		}
+
+		f, err := ParseFloat(s)
+		if err != nil {
+			return errors.New("cannot parse " + strconv.Quote(string(vt)) + " as number")
+		}
+
+		return emit(f)
+	default:
+		return errors.New("cannot parse value of type '" + in.whichType().String() + "' as number")
+	}
+}
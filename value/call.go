@@ -1,18 +1,50 @@
 package value
 
+import (
+	"errors"
+)
+
 // A Call operations takes two operations
 type Call struct {
 	Func Expr
 	Args []Expr
+	Pos  int
+}
+
+// Eval will call every function the Func expression outputs with every
+// combination of the argument outputs
+func (c *Call) Eval(ctx Context, emit Emit) error {
+	return c.Func.Eval(ctx, func(fv Value) error {
+		f, ok := fv.(Func)
+		if !ok {
+			return evalError(c.Pos, c, errors.New("value of type '"+fv.whichType().String()+"' is not callable"))
+		}
+
+		return cartesian(ctx, c.Args, nil, func(argv []Value) error {
+			if err := ctx.Budget.step(); err != nil {
+				return err
+			}
+
+			res, err := f(argv...)
+			if err != nil {
+				return evalError(c.Pos, c, err)
+			} else if err = ctx.Budget.alloc(sizeOf(res)); err != nil {
+				return err
+			}
+
+			return emit(res)
+		})
+	})
 }
 
-// Eval will evaluate the binary operation
-func (c *Call) Eval(ctx Context) Value {
-	f := c.Func.Eval(ctx).(Func)
-	argv := make([]Value, len(c.Args))
-	for i := range c.Args {
-		argv[i] = c.Args[i].Eval(ctx)
+// cartesian calls fn for every combination of the outputs of the
+// argument expressions, the last argument varies the fastest.
+func cartesian(ctx Context, args []Expr, argv []Value, fn func(argv []Value) error) error {
+	if len(args) == 0 {
+		return fn(argv)
 	}
 
-	return f(argv...)
+	return args[0].Eval(ctx, func(v Value) error {
+		return cartesian(ctx, args[1:], append(argv[:len(argv):len(argv)], v), fn)
+	})
 }
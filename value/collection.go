@@ -0,0 +1,594 @@
+package value
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// arrayInput returns the input as an array, ports are expanded
+func arrayInput(name string, in Value) (Array, error) {
+	in, err := expand(in)
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := in.(Array)
+	if !ok {
+		return nil, errors.New(name + " input must be an array, got value of type '" + in.whichType().String() + "'")
+	}
+
+	return arr, nil
+}
+
+// sortKeys evaluates 'f' for every element and collects its outputs
+// in an array, which is the key that the element is ordered by
+func sortKeys(ctx Context, f Expr, arr Array) (Array, error) {
+	keys := make(Array, len(arr))
+	for i, ev := range arr {
+		key := Array{}
+		if err := eachArg(ctx, f, ev, func(v Value) error {
+			key = append(key, v)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// sortByKeys returns a copy of the array ordered by the keys, elements
+// with equal keys keep their order
+func sortByKeys(arr, keys Array) (sorted, sortedKeys Array, err error) {
+	idx := make([]int, len(arr))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool {
+		c, cerr := compare(keys[idx[i]], keys[idx[j]])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+
+		return c < 0
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sorted, sortedKeys = make(Array, len(arr)), make(Array, len(arr))
+	for i, j := range idx {
+		sorted[i], sortedKeys[i] = arr[j], keys[j]
+	}
+
+	return sorted, sortedKeys, nil
+}
+
+// sortedBy expands the input and sorts it by the outputs of the first
+// argument, or by the elements themselves without arguments
+func sortedBy(ctx Context, name string, in Value, args []Expr) (sorted, keys Array, err error) {
+	arr, err := arrayInput(name, in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys = arr
+	if len(args) > 0 {
+		if keys, err = sortKeys(ctx, args[0], arr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return sortByKeys(arr, keys)
+}
+
+func builtinSort(ctx Context, in Value, args []Expr, emit Emit) error {
+	sorted, _, err := sortedBy(ctx, "sort", in, args)
+	if err != nil {
+		return err
+	}
+
+	return emit(sorted)
+}
+
+func builtinGroupBy(ctx Context, in Value, args []Expr, emit Emit) error {
+	sorted, keys, err := sortedBy(ctx, "group_by", in, args)
+	if err != nil {
+		return err
+	}
+
+	groups := Array{}
+	err = eachGroup(sorted, keys, func(group Array) error {
+		groups = append(groups, group)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return emit(groups)
+}
+
+func builtinUnique(ctx Context, in Value, args []Expr, emit Emit) error {
+	sorted, keys, err := sortedBy(ctx, "unique", in, args)
+	if err != nil {
+		return err
+	}
+
+	uniq := Array{}
+	err = eachGroup(sorted, keys, func(group Array) error {
+		uniq = append(uniq, group[0])
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return emit(uniq)
+}
+
+// eachGroup passes the runs of sorted elements with equal keys to fn
+func eachGroup(sorted, keys Array, fn func(group Array) error) error {
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for ; j < len(sorted); j++ {
+			if c, err := compare(keys[i], keys[j]); err != nil {
+				return err
+			} else if c != 0 {
+				break
+			}
+		}
+
+		if err := fn(sorted[i:j:j]); err != nil {
+			return err
+		}
+
+		i = j
+	}
+
+	return nil
+}
+
+// minMax creates the min, max, min_by and max_by builtins. Of equal
+// elements min picks the first and max picks the last, null is
+// emitted for empty arrays.
+func minMax(name string, max bool) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		arr, err := arrayInput(name, in)
+		if err != nil {
+			return err
+		}
+
+		keys := arr
+		if len(args) > 0 {
+			if keys, err = sortKeys(ctx, args[0], arr); err != nil {
+				return err
+			}
+		}
+
+		if len(arr) == 0 {
+			return emit(Null{})
+		}
+
+		best := 0
+		for i := 1; i < len(arr); i++ {
+			c, err := compare(keys[i], keys[best])
+			if err != nil {
+				return err
+			}
+
+			if (max && c >= 0) || (!max && c < 0) {
+				best = i
+			}
+		}
+
+		return emit(arr[best])
+	}
+}
+
+func builtinFlatten(ctx Context, in Value, args []Expr, emit Emit) error {
+	arr, err := arrayInput("flatten", in)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		res, err := flatten(arr, -1, Array{})
+		if err != nil {
+			return err
+		}
+
+		return emit(res)
+	}
+
+	return eachArg(ctx, args[0], in, func(dv Value) error {
+		if !isNumber(dv) {
+			return errors.New("flatten depth must be a number, got value of type '" + dv.whichType().String() + "'")
+		}
+
+		f, _ := dv.toType(floatType)
+		if f.(Float) < 0 {
+			return errors.New("flatten depth must not be negative")
+		}
+
+		res, err := flatten(arr, int(f.(Float)), Array{})
+		if err != nil {
+			return err
+		}
+
+		return emit(res)
+	})
+}
+
+// flatten appends the elements of the array to 'res', nested arrays are
+// flattened up to 'depth' levels deep or fully when it is negative
+func flatten(arr Array, depth int, res Array) (Array, error) {
+	for _, ev := range arr {
+		if depth == 0 {
+			res = append(res, ev)
+			continue
+		}
+
+		ev, err := expand(ev)
+		if err != nil {
+			return nil, err
+		}
+
+		nested, ok := ev.(Array)
+		if !ok {
+			res = append(res, ev)
+			continue
+		}
+
+		if res, err = flatten(nested, depth-1, res); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// indices returns the positions at which 'sub' occurs in 'v'. For strings
+// these are codepoint offsets of the substring, for arrays the positions of
+// the sub array or of an element. Occurrences may overlap.
+func indices(v, sub Value) (Value, error) {
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub, err = expand(sub); err != nil {
+		return nil, err
+	}
+
+	res := Array{}
+	switch vt := v.(type) {
+	case Null:
+		return Null{}, nil
+	case String:
+		st, ok := sub.(String)
+		if !ok {
+			return nil, errors.New("cannot find indices of value of type '" + sub.whichType().String() + "' in a string")
+		}
+
+		if st == "" {
+			return Null{}, nil
+		}
+
+		s := string(vt)
+		for i := 0; i <= len(s)-len(st); i++ {
+			if strings.HasPrefix(s[i:], string(st)) {
+				res = append(res, Int(utf8.RuneCountInString(s[:i])))
+			}
+		}
+
+		return res, nil
+	case Array:
+		seq, ok := sub.(Array)
+		if !ok {
+			seq = Array{sub}
+		}
+
+		if len(seq) == 0 {
+			return Null{}, nil
+		}
+
+	outer:
+		for i := 0; i <= len(vt)-len(seq); i++ {
+			for j := range seq {
+				if c, err := compare(vt[i+j], seq[j]); err != nil {
+					return nil, err
+				} else if c != 0 {
+					continue outer
+				}
+			}
+
+			res = append(res, Int(i))
+		}
+
+		return res, nil
+	default:
+		return nil, errors.New("cannot find indices in value of type '" + v.whichType().String() + "'")
+	}
+}
+
+// indexOf creates the indices, index and rindex builtins, 'pick' selects
+// the result from all indices
+func indexOf(pick func(idx Array) Value) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		return eachArg(ctx, args[0], in, func(sub Value) error {
+			idx, err := indices(in, sub)
+			if err != nil {
+				return err
+			}
+
+			if arr, ok := idx.(Array); ok {
+				return emit(pick(arr))
+			}
+
+			return emit(idx)
+		})
+	}
+}
+
+func pickAll(idx Array) Value { return idx }
+
+func pickFirst(idx Array) Value {
+	if len(idx) == 0 {
+		return Null{}
+	}
+
+	return idx[0]
+}
+
+func pickLast(idx Array) Value {
+	if len(idx) == 0 {
+		return Null{}
+	}
+
+	return idx[len(idx)-1]
+}
+
+// contains reports whether 'a' contains 'b': strings contain substrings,
+// arrays contain arrays of which every element is contained by one of
+// their elements and maps contain maps whose values are contained by
+// their values under the same key. Other values must be equal.
+func contains(a, b Value) (bool, error) {
+	a, err := expand(a)
+	if err != nil {
+		return false, err
+	}
+
+	if b, err = expand(b); err != nil {
+		return false, err
+	}
+
+	if typeName(a) != typeName(b) {
+		return false, nil
+	}
+
+	switch at := a.(type) {
+	case String:
+		return strings.Contains(string(at), string(b.(String))), nil
+	case Array:
+	outer:
+		for _, bv := range b.(Array) {
+			for _, av := range at {
+				if ok, err := contains(av, bv); err != nil {
+					return false, err
+				} else if ok {
+					continue outer
+				}
+			}
+
+			return false, nil
+		}
+
+		return true, nil
+	case Map:
+		for k, bv := range b.(Map) {
+			av, ok := at[k]
+			if !ok {
+				return false, nil
+			}
+
+			if ok, err := contains(av, bv); err != nil || !ok {
+				return false, err
+			}
+		}
+
+		return true, nil
+	default:
+		c, err := compare(a, b)
+		return c == 0, err
+	}
+}
+
+// checkContains is like contains but only values of the same type
+// can have their containment checked
+func checkContains(a, b Value) (bool, error) {
+	if typeName(a) != typeName(b) {
+		return false, errors.New(typeName(a) + " and " + typeName(b) + " cannot have their containment checked")
+	}
+
+	return contains(a, b)
+}
+
+func builtinContains(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		ok, err := checkContains(in, v)
+		if err != nil {
+			return err
+		}
+
+		return emit(Bool(ok))
+	})
+}
+
+func builtinInside(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		ok, err := checkContains(v, in)
+		if err != nil {
+			return err
+		}
+
+		return emit(Bool(ok))
+	})
+}
+
+func builtinTranspose(ctx Context, in Value, args []Expr, emit Emit) error {
+	arr, err := arrayInput("transpose", in)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]Array, len(arr))
+	var n int
+	for i, ev := range arr {
+		if rows[i], err = arrayInput("transpose", ev); err != nil {
+			return err
+		}
+
+		if len(rows[i]) > n {
+			n = len(rows[i])
+		}
+	}
+
+	res := make(Array, n)
+	for j := range res {
+		col := make(Array, len(rows))
+		for i, row := range rows {
+			col[i] = Null{}
+			if j < len(row) {
+				col[i] = row[j]
+			}
+		}
+
+		res[j] = col
+	}
+
+	return emit(res)
+}
+
+// toEntries converts a map or an array into an array of key value pairs,
+// the keys of an array are its indexes
+func toEntries(v Value) (Array, error) {
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch vt := v.(type) {
+	case Map:
+		entries := make(Array, 0, len(vt))
+		for _, k := range vt.keys() {
+			entries = append(entries, Map{"key": String(k), "value": vt[k]})
+		}
+
+		return entries, nil
+	case Array:
+		entries := make(Array, 0, len(vt))
+		for i, ev := range vt {
+			entries = append(entries, Map{"key": Int(i), "value": ev})
+		}
+
+		return entries, nil
+	default:
+		return nil, errors.New("cannot convert value of type '" + v.whichType().String() + "' to entries")
+	}
+}
+
+// fromEntries converts an array of key value pairs into a map. Like jq
+// the key may also be named k, name, Name, K or Key and the value v,
+// keys that are not strings are converted to json.
+func fromEntries(ctx Context, v Value) (Map, error) {
+	m := Map{}
+	if err := eachElem(ctx, v, func(ev Value) error {
+		ev, err := expand(ev)
+		if err != nil {
+			return err
+		}
+
+		entry, ok := ev.(Map)
+		if !ok {
+			return errors.New("cannot use value of type '" + ev.whichType().String() + "' as an entry")
+		}
+
+		var key Value = Null{}
+		for _, name := range []string{"key", "k", "name", "Name", "K", "Key"} {
+			if kv, ok := entry[name]; ok && truthy(kv) {
+				key = kv
+				break
+			}
+		}
+
+		ks, err := stringify(key)
+		if err != nil {
+			return err
+		}
+
+		val, ok := entry["value"]
+		if !ok {
+			if val, ok = entry["v"]; !ok {
+				val = Null{}
+			}
+		}
+
+		m[string(ks)] = val
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func builtinToEntries(ctx Context, in Value, args []Expr, emit Emit) error {
+	entries, err := toEntries(in)
+	if err != nil {
+		return err
+	}
+
+	return emit(entries)
+}
+
+func builtinFromEntries(ctx Context, in Value, args []Expr, emit Emit) error {
+	m, err := fromEntries(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	return emit(m)
+}
+
+func builtinWithEntries(ctx Context, in Value, args []Expr, emit Emit) error {
+	entries, err := toEntries(in)
+	if err != nil {
+		return err
+	}
+
+	mapped := Array{}
+	for _, entry := range entries {
+		if err := eachArg(ctx, args[0], entry, func(v Value) error {
+			mapped = append(mapped, v)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	m, err := fromEntries(ctx, mapped)
+	if err != nil {
+		return err
+	}
+
+	return emit(m)
+}
@@ -0,0 +1,27 @@
+package value
+
+// Comma concatenates two filters: it emits all outputs of the
+// left expression followed by all outputs of the right expression
+type Comma struct {
+	Left  Expr
+	Right Expr
+	Pos   int
+}
+
+// Eval will evaluate the left side and then the right side
+func (c *Comma) Eval(ctx Context, emit Emit) error {
+	if err := c.Left.Eval(ctx, emit); err != nil {
+		return err
+	}
+
+	return c.Right.Eval(ctx, emit)
+}
+
+// EvalPath will emit the paths of the left side and then of the right side
+func (c *Comma) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	if err := evalPath(ctx, c.Left, path, emit); err != nil {
+		return err
+	}
+
+	return evalPath(ctx, c.Right, path, emit)
+}
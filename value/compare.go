@@ -0,0 +1,196 @@
+package value
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// rank orders the value types as jq does: null < false < true <
+// numbers < strings < arrays < maps. Ports rank as the array or map
+// they hold, funcs have no rank.
+func rank(v Value) (int, error) {
+	switch vt := v.(type) {
+	case Null:
+		return 0, nil
+	case Bool:
+		if !vt {
+			return 1, nil
+		}
+		return 2, nil
+	case Int, Float, Decimal:
+		return 3, nil
+	case String:
+		return 4, nil
+	case Array:
+		return 5, nil
+	case Map:
+		return 6, nil
+	case Port:
+		if vt.cargo.IsArray() {
+			return 5, nil
+		}
+		return 6, nil
+	default:
+		return 0, errors.New("cannot compare value of type '" + v.whichType().String() + "'")
+	}
+}
+
+// compare returns -1, 0 or 1 when 'a' is ordered before, the same as,
+// or after 'b'. Values of different types are ordered by their rank,
+// arrays are ordered element wise and maps by their sorted keys and
+// then by their values key by key.
+func compare(a, b Value) (int, error) {
+	ra, err := rank(a)
+	if err != nil {
+		return 0, err
+	}
+
+	rb, err := rank(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if ra != rb {
+		return cmpInt(ra, rb), nil
+	}
+
+	// ports are only read when they are compared to arrays or maps
+	if a, err = expand(a); err != nil {
+		return 0, err
+	}
+
+	if b, err = expand(b); err != nil {
+		return 0, err
+	}
+
+	switch at := a.(type) {
+	case Int:
+		switch bt := b.(type) {
+		case Int:
+			return cmpInt64(int64(at), int64(bt)), nil
+		case Decimal:
+			return cmpDecimal(at, bt)
+		}
+
+		return cmpFloat(float64(at), float64(b.(Float))), nil
+	case Decimal:
+		return cmpDecimal(at, b)
+	case Float:
+		if bt, ok := b.(Decimal); ok {
+			return cmpDecimal(at, bt)
+		}
+
+		if bt, ok := b.(Int); ok {
+			return cmpFloat(float64(at), float64(bt)), nil
+		}
+
+		return cmpFloat(float64(at), float64(b.(Float))), nil
+	case String:
+		return strings.Compare(string(at), string(b.(String))), nil
+	case Array:
+		bt := b.(Array)
+		for i := 0; i < len(at) && i < len(bt); i++ {
+			if c, err := compare(at[i], bt[i]); err != nil || c != 0 {
+				return c, err
+			}
+		}
+
+		return cmpInt(len(at), len(bt)), nil
+	case Map:
+		bt := b.(Map)
+		ak, bk := at.keys(), bt.keys()
+		for i := 0; i < len(ak) && i < len(bk); i++ {
+			if c := strings.Compare(ak[i], bk[i]); c != 0 {
+				return c, nil
+			}
+		}
+
+		if c := cmpInt(len(ak), len(bk)); c != 0 {
+			return c, nil
+		}
+
+		for _, k := range ak {
+			if c, err := compare(at[k], bt[k]); err != nil || c != 0 {
+				return c, err
+			}
+		}
+
+		return 0, nil
+	default:
+		return 0, nil // null and booleans are fully ordered by rank
+	}
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// cmpDecimal compares two numbers of which at least one is a decimal
+// exactly, non-finite floats are compared as floats
+func cmpDecimal(a, b Value) (int, error) {
+	da, erra := a.toType(decimalType)
+	db, errb := b.toType(decimalType)
+	if erra != nil || errb != nil {
+		fa, _ := a.toType(floatType)
+		fb, _ := b.toType(floatType)
+		return cmpFloat(float64(fa.(Float)), float64(fb.(Float))), nil
+	}
+
+	return da.(Decimal).r().Cmp(db.(Decimal).r()), nil
+}
+
+// cmpFloat compares floats in a total order in which NaN is smaller
+// than every other number, as in jq
+func cmpFloat(a, b float64) int {
+	switch nanA, nanB := math.IsNaN(a), math.IsNaN(b); {
+	case nanA && nanB:
+		return 0
+	case nanA:
+		return -1
+	case nanB:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareOp creates a binary operator that compares operants of any
+// type and passes the outcome of the comparison to 'ok'
+func compareOp(ok func(c int) bool) *binaryOp {
+	op := &binaryOp{}
+	for i := range op.impl {
+		op.impl[i] = func(u, v Value) (Value, error) {
+			c, err := compare(u, v)
+			if err != nil {
+				return nil, err
+			}
+
+			return Bool(ok(c)), nil
+		}
+	}
+
+	return op
+}
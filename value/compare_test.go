@@ -0,0 +1,51 @@
+package value
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	ordered := []Value{
+		Null{},
+		Bool(false),
+		Bool(true),
+		Float(math.NaN()),
+		Float(math.Inf(-1)),
+		Int(-1),
+		Float(0.5),
+		Int(1),
+		String(""),
+		String("a"),
+		String("b"),
+		Array{},
+		Array{Int(1)},
+		Array{Int(1), Int(2)},
+		Array{Int(2)},
+		Map{},
+		Map{"a": Int(2)},
+		Map{"a": Int(1), "b": Int(1)},
+		Map{"b": Int(0)},
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			c, err := compare(ordered[i], ordered[j])
+			if err != nil {
+				t.Fatalf("failed to compare '%s' and '%s': %v", ordered[i], ordered[j], err)
+			}
+
+			if c != cmpInt(i, j) {
+				t.Fatalf("comparing '%s' and '%s' gave %d, expected: %d", ordered[i], ordered[j], c, cmpInt(i, j))
+			}
+		}
+	}
+
+	if c, _ := compare(Int(1), Float(1)); c != 0 {
+		t.Fatal("expected int and float of the same value to be equal")
+	}
+
+	if _, err := compare(Func(nil), Int(1)); err == nil {
+		t.Fatal("expected comparing funcs to fail")
+	}
+}
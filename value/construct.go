@@ -0,0 +1,76 @@
+package value
+
+import (
+	"errors"
+)
+
+// ArrayConstruct collects every output of its element expression
+// into a single array
+type ArrayConstruct struct {
+	Elem Expr // may be nil for the empty array
+	Pos  int
+}
+
+// Eval will emit one array with all outputs of the element expression
+func (ac *ArrayConstruct) Eval(ctx Context, emit Emit) error {
+	arr := Array{}
+	if ac.Elem != nil {
+		if err := ac.Elem.Eval(ctx, func(v Value) error {
+			arr = append(arr, v)
+			return ctx.Budget.alloc(1)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return emit(arr)
+}
+
+// MapEntry is a single key/value pair of a map construction
+type MapEntry struct {
+	Key   Expr
+	Value Expr
+}
+
+// MapConstruct builds maps out of key and value expressions. When keys
+// or values produce multiple outputs a map is emitted for every
+// combination of them, with the first entry varying the slowest
+type MapConstruct struct {
+	Entries []MapEntry
+	Pos     int
+}
+
+// Eval will emit a map for every combination of key and value outputs
+func (mc *MapConstruct) Eval(ctx Context, emit Emit) error {
+	return mc.build(ctx, 0, Map{}, emit)
+}
+
+// build evaluates the entry at index i for the partial map m and
+// continues with the next entry for every output
+func (mc *MapConstruct) build(ctx Context, i int, m Map, emit Emit) error {
+	if i >= len(mc.Entries) {
+		return emit(m)
+	}
+
+	entry := mc.Entries[i]
+	return entry.Key.Eval(ctx, func(kv Value) error {
+		key, ok := kv.(String)
+		if !ok {
+			return evalError(mc.Pos, mc, errors.New("object keys must be strings, got value of type '"+kv.whichType().String()+"'"))
+		}
+
+		return entry.Value.Eval(ctx, func(v Value) error {
+			next := make(Map, len(m)+1)
+			for k, mv := range m {
+				next[k] = mv
+			}
+
+			next[string(key)] = v
+			if err := ctx.Budget.alloc(len(next)); err != nil {
+				return err
+			}
+
+			return mc.build(ctx, i+1, next, emit)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package value
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Decimal is an arbitrary precision number. Numbers that don't fit an Int
+// or a Float without losing precision are converted to a Decimal, and in
+// the big number mode all arithmetic is done on decimals. A decimal that
+// is parsed from text remembers that text such that numbers that are
+// passed through unchanged keep their exact representation. The zero
+// value is the number 0.
+type Decimal struct {
+	rat  *big.Rat // never modified after construction, nil for 0
+	text string   // literal text, empty if computed
+}
+
+// zeroRat is the value of the zero Decimal, it is never modified
+var zeroRat = new(big.Rat)
+
+// r returns the rational value of the decimal, it must not be modified
+func (d Decimal) r() *big.Rat {
+	if d.rat == nil {
+		return zeroRat
+	}
+
+	return d.rat
+}
+
+var _ Value = Decimal{}
+
+// ParseDecimal parses the decimal text of a number, the text is kept
+// and returned by String.
+func ParseDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || !isDecimalText(s) {
+		return Decimal{}, errors.New("jqp/value: invalid decimal number: " + strconv.Quote(s))
+	}
+
+	return Decimal{rat: r, text: s}, nil
+}
+
+// isDecimalText reports whether the text is a signed decimal number
+// with an optional fraction and exponent, such as -1.5e3
+func isDecimalText(s string) bool {
+	digits := func() (n int) {
+		for ; len(s) > 0 && s[0] >= '0' && s[0] <= '9'; s = s[1:] {
+			n++
+		}
+		return
+	}
+
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+
+	n := digits()
+	if len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		n += digits()
+	}
+
+	if n == 0 {
+		return false
+	}
+
+	if len(s) > 0 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+			s = s[1:]
+		}
+
+		if digits() == 0 {
+			return false
+		}
+	}
+
+	return len(s) == 0
+}
+
+// NewDecimal returns a decimal with the value of 'r'
+func NewDecimal(r *big.Rat) Decimal {
+	return Decimal{rat: new(big.Rat).Set(r)}
+}
+
+// Rat returns the value of the decimal as a rational number
+func (d Decimal) Rat() *big.Rat { return new(big.Rat).Set(d.r()) }
+
+// String returns the literal text of the decimal, or the exact decimal
+// notation if it has one. Other fractions are rounded to 34 digits.
+func (d Decimal) String() string {
+	if d.text != "" {
+		return d.text
+	}
+
+	if d.r().IsInt() {
+		return d.r().Num().String()
+	}
+
+	if prec, exact := decimalPlaces(d.r().Denom()); exact {
+		return d.r().FloatString(prec)
+	}
+
+	return new(big.Float).SetPrec(128).SetRat(d.r()).Text('g', 34)
+}
+
+// decimalPlaces returns the number of decimal places that are needed to
+// write a fraction with the denominator exactly, this is only possible
+// if the denominator has no other prime factors than 2 and 5.
+func decimalPlaces(denom *big.Int) (int, bool) {
+	var places int
+	d := new(big.Int).Set(denom)
+	for _, factor := range []int64{2, 5} {
+		var n int
+		f, q, m := big.NewInt(factor), new(big.Int), new(big.Int)
+		for {
+			if q.QuoRem(d, f, m); m.Sign() != 0 {
+				break
+			}
+
+			d.Set(q)
+			n++
+		}
+
+		if n > places {
+			places = n
+		}
+	}
+
+	return places, d.IsInt64() && d.Int64() == 1
+}
+
+func (d Decimal) Eval(ctx Context, emit Emit) error { return emit(d) }
+
+func (d Decimal) whichType() valueType { return decimalType }
+func (d Decimal) toType(which valueType) (Value, error) {
+	switch which {
+	case decimalType:
+		return d, nil
+	case floatType:
+		f64, _ := d.r().Float64()
+		return Float(f64), nil
+	default:
+		return nil, conversionError(d, which)
+	}
+}
+
+// shrink narrows the decimal to an Int if it is an integer that fits,
+// other decimals are kept as-is.
+func (d Decimal) shrink() Value {
+	if d.r().IsInt() && d.r().Num().IsInt64() {
+		return Int(d.r().Num().Int64())
+	}
+
+	return d
+}
+
+// narrow converts the decimal to an Int if it is an integer that fits,
+// or else to the nearest Float. It is used outside of the big number mode.
+func (d Decimal) narrow() Value {
+	if d.r().IsInt() && d.r().Num().IsInt64() {
+		return Int(d.r().Num().Int64())
+	}
+
+	f64, _ := d.r().Float64()
+	return Float(f64)
+}
+
+// decimalFromFloat converts a float through its shortest decimal
+// representation, such that 0.1 becomes exactly 1/10.
+func decimalFromFloat(f Float) (Decimal, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return Decimal{}, conversionError(f, decimalType)
+	}
+
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(float64(f), 'g', -1, 64))
+	return Decimal{rat: r}, nil
+}
+
+// decimalArith applies an arithmetic operation to the rational values of
+// both decimals and shrinks the result.
+func decimalArith(fn func(z, x, y *big.Rat) *big.Rat) func(u, v Value) (Value, error) {
+	return func(u, v Value) (Value, error) {
+		return Decimal{rat: fn(new(big.Rat), u.(Decimal).r(), v.(Decimal).r())}.shrink(), nil
+	}
+}
+
+// decimalQuo divides decimals exactly
+func decimalQuo(u, v Value) (Value, error) {
+	if v.(Decimal).r().Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+
+	return Decimal{rat: new(big.Rat).Quo(u.(Decimal).r(), v.(Decimal).r())}.shrink(), nil
+}
+
+// decimalRem returns the remainder of the truncated decimals, like
+// the remainder of floats
+func decimalRem(u, v Value) (Value, error) {
+	trunc := func(r *big.Rat) *big.Int { return new(big.Int).Quo(r.Num(), r.Denom()) }
+	x, y := trunc(u.(Decimal).r()), trunc(v.(Decimal).r())
+	if y.Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+
+	return Decimal{rat: new(big.Rat).SetInt(x.Rem(x, y))}.shrink(), nil
+}
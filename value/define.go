@@ -0,0 +1,131 @@
+package value
+
+import (
+	"strings"
+)
+
+// FuncDef defines a function, as in 'def name(f; $x): body;'. Parameters
+// without a '$' are filters: their argument is passed unevaluated and runs
+// in the scope of the caller, with the input at the place it is invoked
+// from in the body. Parameters with a '$' are values: the body is
+// evaluated for every output of their argument, which is bound as the
+// variable '$x' and as the filter 'x'.
+type FuncDef struct {
+	Name   string
+	Params []string
+	Body   Expr
+	Pos    int
+}
+
+// Define evaluates an expression in which the function is defined, the
+// function itself can also be invoked from its body.
+type Define struct {
+	Func *FuncDef
+	Expr Expr
+}
+
+// Eval will evaluate the expression with the function defined
+func (d *Define) Eval(ctx Context, emit Emit) error {
+	return d.Expr.Eval(ctx.Define(d.Func), emit)
+}
+
+// EvalPath will evaluate the expression as path expression with the
+// function defined
+func (d *Define) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return evalPath(ctx.Define(d.Func), d.Expr, path, emit)
+}
+
+// Define returns a copy of the context in which the functions are
+// defined in order, each function can invoke itself and the functions
+// defined before it. Functions shadow builtins with the same name and
+// number of parameters.
+func (ctx Context) Define(fns ...*FuncDef) Context {
+	for _, fn := range fns {
+		s := &scope{parent: ctx.scope}
+		s.fn = &closure{
+			key:    funcKey(fn.Name, len(fn.Params)),
+			params: fn.Params,
+			body:   fn.Body,
+			env:    s,
+		}
+
+		ctx.scope = s
+	}
+
+	return ctx
+}
+
+// closure is a function body together with the scope it was defined
+// in. Filter arguments are closures without parameters.
+type closure struct {
+	key    string
+	params []string
+	body   Expr
+	env    *scope
+}
+
+// lookupFunc returns the function from the innermost scope that
+// defines it, or nil if there is none
+func (ctx Context) lookupFunc(key string) *closure {
+	for s := ctx.scope; s != nil; s = s.parent {
+		if s.fn != nil && s.fn.key == key {
+			return s.fn
+		}
+	}
+
+	return nil
+}
+
+// bind returns the context the body is evaluated in when the closure is
+// invoked from 'ctx' with 'args': its own scope with the arguments bound
+// to the parameters. It calls fn for every combination of the outputs of
+// the value arguments.
+func (c *closure) bind(ctx Context, args []Expr, fn func(bctx Context) error) error {
+	bctx := ctx
+	bctx.scope, bctx.depth = c.env, ctx.depth+1
+	if err := ctx.Budget.depth(bctx.depth); err != nil {
+		return err
+	}
+
+	return c.bindParams(ctx, bctx.with(Input, ctx.input()), args, 0, fn)
+}
+
+// bindParams binds the arguments from index 'i' onwards to the parameters,
+// value arguments are evaluated in the scope of the caller
+func (c *closure) bindParams(ctx, bctx Context, args []Expr, i int, fn func(bctx Context) error) error {
+	if i == len(args) {
+		return fn(bctx)
+	}
+
+	param := c.params[i]
+	if !strings.HasPrefix(param, "$") {
+		bctx = bctx.define(&closure{key: funcKey(param, 0), body: args[i], env: ctx.scope})
+		return c.bindParams(ctx, bctx, args, i+1, fn)
+	}
+
+	return args[i].Eval(ctx, func(v Value) error {
+		vctx := bctx.with(Var(param), v)
+		vctx = vctx.define(&closure{key: funcKey(param[1:], 0), body: v})
+		return c.bindParams(ctx, vctx, args, i+1, fn)
+	})
+}
+
+// define returns a copy of the context with the closure in scope
+func (ctx Context) define(c *closure) Context {
+	ctx.scope = &scope{parent: ctx.scope, fn: c}
+	return ctx
+}
+
+// call evaluates the body of the closure
+func (c *closure) call(ctx Context, args []Expr, emit Emit) error {
+	return c.bind(ctx, args, func(bctx Context) error {
+		return c.body.Eval(bctx, emit)
+	})
+}
+
+// callPath evaluates the body of the closure as path expression
+func (c *closure) callPath(ctx Context, path Array, args []Expr, emit EmitPath) error {
+	return c.bind(ctx, args, func(bctx Context) error {
+		return evalPath(bctx, c.body, path, emit)
+	})
+}
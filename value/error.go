@@ -0,0 +1,46 @@
+package value
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// EvalError is returned when an expression could not be evaluated. It
+// carries the expression that failed and the position of its operator
+// in the query.
+type EvalError struct {
+	Pos  int   // position of the operator token, -1 if unknown
+	Expr Expr  // the expression that failed to evaluate
+	Err  error // the underlying cause
+}
+
+func (e *EvalError) Error() string {
+	s := "jqp/value: " + strings.TrimPrefix(e.Err.Error(), "jqp/value: ")
+	if e.Pos >= 0 {
+		s += " at position " + strconv.Itoa(e.Pos)
+	}
+
+	return s
+}
+
+// Unwrap returns the underlying cause
+func (e *EvalError) Unwrap() error { return e.Err }
+
+// evalError wraps err into an evaluation error for the expression
+// unless it already is one, such that errors carry the position of
+// the deepest expression that failed. Errors that abort the evaluation,
+// such as a LimitError, are not wrapped.
+func evalError(pos int, expr Expr, err error) error {
+	if _, ok := err.(*EvalError); ok || isAbort(err) {
+		return err
+	}
+
+	return &EvalError{Pos: pos, Expr: expr, Err: err}
+}
+
+// conversionError is returned when a value cannot be converted
+// to the requested type
+func conversionError(v Value, which valueType) error {
+	return errors.New("type conversion from '" + v.whichType().String() + "' to '" + which.String() + "' not implemented")
+}
@@ -1,6 +1,8 @@
 package value
 
 import (
+	"errors"
+	"math"
 	"strconv"
 )
 
@@ -8,23 +10,43 @@ var _ Value = Float(0)
 
 type Float float64
 
-func (f Float) String() string         { return strconv.FormatFloat(float64(f), 'E', -1, 64) }
-func (f Float) Eval(ctx Context) Value { return f }
+func (f Float) String() string                    { return strconv.FormatFloat(float64(f), 'E', -1, 64) }
+func (f Float) Eval(ctx Context, emit Emit) error { return emit(f) }
+
+// ParseFloat parses the text of a number as a float, numbers that are
+// too large for a float64 are clamped to ±math.MaxFloat64 as jq does
+func ParseFloat(s string) (Float, error) {
+	f64, err := strconv.ParseFloat(s, 64)
+	if errors.Is(err, strconv.ErrRange) && math.IsInf(f64, 0) {
+		return Float(math.Copysign(math.MaxFloat64, f64)), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return Float(f64), nil
+}
 
 func (f Float) whichType() valueType { return floatType }
-func (f Float) toType(which valueType) Value {
+func (f Float) toType(which valueType) (Value, error) {
 	switch which {
 	case floatType:
-		return f
-	case arrayType:
-		return Array{f}
+		return f, nil
+	case decimalType:
+		return decimalFromFloat(f)
 	default:
-		panic("type coversion from '" + f.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(f, which)
 	}
 }
 
+// maxExactFloat is the largest integer from which all smaller
+// integers can be represented exactly by a float64
+const maxExactFloat = 1 << 53
+
+// shrink narrows the float to an Int if it is an integer within the
+// range in which floats represent integers exactly, such that narrowing
+// never changes the value and large numbers stay floats.
 func (f Float) shrink() Value {
-	if float64(f) == float64(int(f)) {
+	if f >= -maxExactFloat && f <= maxExactFloat && f == Float(math.Trunc(float64(f))) {
 		return Int(f)
 	}
 	return f
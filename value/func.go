@@ -2,21 +2,41 @@ package value
 
 // Func type can return its value when the binary operator is
 // evaluated with this type as its left operant.
-type Func func(args ...Value) Value
+type Func func(args ...Value) (Value, error)
 
 var _ Value = Func(nil)
 
-func (f Func) String() string         { return "func()" }
-func (f Func) Eval(ctx Context) Value { return f }
+func (f Func) String() string                    { return "func()" }
+func (f Func) Eval(ctx Context, emit Emit) error { return emit(f) }
 func (f Func) whichType() valueType {
 	return funcType
 }
 
-func (f Func) toType(which valueType) Value {
+func (f Func) toType(which valueType) (Value, error) {
 	switch which {
 	case funcType:
-		return f
+		return f, nil
 	default:
-		panic("type coversion from '" + f.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(f, which)
+	}
+}
+
+// GoFunc adapts a Go function to a builtin that can be registered in
+// the Funcs of a context. The arguments are evaluated against the input
+// and the function is called for every combination of their outputs,
+// the first argument varies slowest. Its result is the only output of
+// each call.
+func GoFunc(fn func(in Value, args []Value) (Value, error)) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		return cartesian(ctx.with(Input, in), args, nil, func(vals []Value) error {
+			v, err := fn(in, vals)
+			if err != nil {
+				return err
+			} else if v == nil {
+				v = Null{}
+			}
+
+			return emit(v)
+		})
 	}
 }
@@ -0,0 +1,34 @@
+package value
+
+// If evaluates the Then branch for every output of the condition that
+// is neither false nor null, and the Else branch for the others. 'elif'
+// is an If in the Else branch, a missing else branch is the input.
+type If struct {
+	Cond Expr
+	Then Expr
+	Else Expr
+	Pos  int
+}
+
+// Eval will evaluate a branch for every output of the condition
+func (e *If) Eval(ctx Context, emit Emit) error {
+	return e.Cond.Eval(ctx, func(c Value) error {
+		if truthy(c) {
+			return e.Then.Eval(ctx, emit)
+		}
+
+		return e.Else.Eval(ctx, emit)
+	})
+}
+
+// EvalPath will evaluate a branch as path expression for every output
+// of the condition, the condition itself is not a path expression
+func (e *If) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return e.Cond.Eval(ctx, func(c Value) error {
+		if truthy(c) {
+			return evalPath(ctx, e.Then, path, emit)
+		}
+
+		return evalPath(ctx, e.Else, path, emit)
+	})
+}
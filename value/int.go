@@ -1,6 +1,7 @@
 package value
 
 import (
+	"math/big"
 	"strconv"
 )
 
@@ -12,31 +13,23 @@ func (i Int) String() string {
 	return strconv.FormatInt(int64(i), 10)
 }
 
-func (i Int) Eval(ctx Context) Value {
-	return i
+func (i Int) Eval(ctx Context, emit Emit) error {
+	return emit(i)
 }
 
 func (i Int) whichType() valueType {
 	return intType
 }
 
-func (i Int) toType(which valueType) Value {
+func (i Int) toType(which valueType) (Value, error) {
 	switch which {
 	case intType:
-		return i
+		return i, nil
 	case floatType:
-		return Float(float64(i))
-	case arrayType:
-		return Array{i}
-	case portType:
-
-		// when the index operator is used  on a port with an int
-		// operant, it must be upgraded from to a port.
-		// As such we create a port that will always return
-		// the key as a string so that the operator implementation
-		// can be written
-		return Port{intPortCargo(i)}
+		return Float(float64(i)), nil
+	case decimalType:
+		return Decimal{rat: new(big.Rat).SetInt64(int64(i))}, nil
 	default:
-		panic("type coversion from '" + i.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(i, which)
 	}
 }
@@ -0,0 +1,41 @@
+package value
+
+import (
+	"strings"
+)
+
+// Interpolate builds strings out of literal parts and the outputs of
+// interpolated expressions. Strings are inserted as-is and other values
+// as JSON. A string is emitted for every combination of the outputs, the
+// last expression varies the slowest.
+type Interpolate struct {
+	Parts []Expr // literal strings and interpolated expressions
+	Pos   int
+}
+
+// Eval will emit the interpolated strings
+func (ip *Interpolate) Eval(ctx Context, emit Emit) error {
+	return ip.build(ctx, len(ip.Parts)-1, nil, ctx.charge(emit))
+}
+
+// build evaluates the part at index i and continues with the part
+// before it for every output, 'tail' holds the parts after it
+func (ip *Interpolate) build(ctx Context, i int, tail []string, emit Emit) error {
+	if i < 0 {
+		var sb strings.Builder
+		for j := len(tail) - 1; j >= 0; j-- {
+			sb.WriteString(tail[j])
+		}
+
+		return emit(String(sb.String()))
+	}
+
+	return ip.Parts[i].Eval(ctx, func(v Value) error {
+		s, err := stringify(v)
+		if err != nil {
+			return evalError(ip.Pos, ip, err)
+		}
+
+		return ip.build(ctx, i-1, append(tail[:len(tail):len(tail)], string(s)), emit)
+	})
+}
@@ -0,0 +1,152 @@
+package value
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Invoke calls a function by its name, such as the builtin 'length'
+// or 'map(f)'. The arguments are passed unevaluated such that the
+// function can evaluate them against any input. Functions defined in
+// the query take precedence over the builtins.
+type Invoke struct {
+	Name string
+	Args []Expr
+	Pos  int
+}
+
+// Eval will call the function with the current input
+func (inv *Invoke) Eval(ctx Context, emit Emit) error {
+	if err := ctx.Budget.step(); err != nil {
+		return err
+	}
+
+	if c := ctx.lookupFunc(funcKey(inv.Name, len(inv.Args))); c != nil {
+		return c.call(ctx, inv.Args, emit)
+	}
+
+	fn, ok := ctx.lookupBuiltin(funcKey(inv.Name, len(inv.Args)))
+	if !ok {
+		return evalError(inv.Pos, inv, errors.New("function not defined: "+funcKey(inv.Name, len(inv.Args))))
+	}
+
+	// errors from emit are marked such that they are not
+	// wrapped as errors of this expression
+	err := fn(ctx, ctx.input(), inv.Args, func(v Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		} else if err = ctx.Budget.alloc(sizeOf(v)); err != nil {
+			return err
+		}
+
+		if err := emit(v); err != nil {
+			return &emitError{err}
+		}
+
+		return nil
+	})
+
+	if ee, ok := err.(*emitError); ok {
+		return ee.err
+	} else if err != nil {
+		return evalError(inv.Pos, inv, err)
+	}
+
+	return nil
+}
+
+// EvalPath will call the path version of the function, functions
+// without one are not path expressions
+func (inv *Invoke) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	if err := ctx.Budget.step(); err != nil {
+		return err
+	}
+
+	if c := ctx.lookupFunc(funcKey(inv.Name, len(inv.Args))); c != nil {
+		return c.callPath(ctx, path, inv.Args, emit)
+	}
+
+	fn, ok := pathBuiltins[funcKey(inv.Name, len(inv.Args))]
+	if _, isGo := ctx.Funcs[funcKey(inv.Name, len(inv.Args))]; !ok || isGo {
+		if _, ok = ctx.lookupBuiltin(funcKey(inv.Name, len(inv.Args))); !ok {
+			return evalError(inv.Pos, inv, errors.New("function not defined: "+funcKey(inv.Name, len(inv.Args))))
+		}
+
+		if err := evalNoPath(ctx, inv); err != nil {
+			return evalError(inv.Pos, inv, err)
+		}
+
+		return nil
+	}
+
+	err := fn(ctx, ctx.input(), path, inv.Args, func(p Array, v Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		}
+
+		if err := emit(p, v); err != nil {
+			return &emitError{err}
+		}
+
+		return nil
+	})
+
+	if ee, ok := err.(*emitError); ok {
+		return ee.err
+	} else if err != nil {
+		return evalError(inv.Pos, inv, err)
+	}
+
+	return nil
+}
+
+// lookupBuiltin returns the function registered from Go or else the
+// builtin with the key
+func (ctx Context) lookupBuiltin(key string) (BuiltinFunc, bool) {
+	if fn, ok := ctx.Funcs[key]; ok {
+		return fn, true
+	}
+
+	fn, ok := builtins[key]
+	return fn, ok
+}
+
+// funcKey identifies a function by its name and number of arguments
+func funcKey(name string, arity int) string {
+	return name + "/" + strconv.Itoa(arity)
+}
+
+// emitError marks an error that was returned by emit
+type emitError struct{ err error }
+
+func (e *emitError) Error() string { return e.err.Error() }
+
+// stopError is used to stop an evaluation once enough outputs were seen,
+// it is not zero sized such that every instance has a distinct address
+type stopError struct{ _ int }
+
+func (e *stopError) Error() string { return "jqp/value: evaluation stopped" }
+
+// evalUntil evaluates 'e' with 'in' as its input and passes the outputs
+// to fn until it returns false.
+func evalUntil(ctx Context, e Expr, in Value, fn func(v Value) (bool, error)) error {
+	stop := &stopError{} // unique per call, such that nested calls don't interfere
+	err := e.Eval(ctx.with(Input, in), func(v Value) error {
+		more, err := fn(v)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return stop
+		}
+
+		return nil
+	})
+
+	if err == stop {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,147 @@
+package value
+
+import (
+	"errors"
+)
+
+// Iterate emits every element of an array or every value of a map
+// that the left expression outputs
+type Iterate struct {
+	Left Expr
+	Pos  int
+}
+
+// Eval will emit the elements of every output of the left side
+func (it *Iterate) Eval(ctx Context, emit Emit) error {
+	return it.Left.Eval(ctx, func(v Value) error {
+		if !isIterable(v) {
+			return evalError(it.Pos, it, iterateError(v))
+		}
+
+		return eachElem(ctx, v, emit)
+	})
+}
+
+// EvalPath will emit the paths of the elements of every path of the
+// left side, iterating over null outputs nothing
+func (it *Iterate) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return evalPath(ctx, it.Left, path, func(lp Array, v Value) error {
+		if _, null := v.(Null); null {
+			return nil
+		}
+
+		if !isIterable(v) {
+			return evalError(it.Pos, it, iterateError(v))
+		}
+
+		return eachKey(ctx, v, func(k, ev Value) error {
+			return emit(appendPath(lp, k), ev)
+		})
+	})
+}
+
+// eachKey passes every index and element of an array, or every key and
+// value of a map in the order of its keys, to fn. Every element is a
+// step of the evaluation.
+func eachKey(ctx Context, v Value, fn func(k, ev Value) error) error {
+	step := func(k, ev Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		}
+
+		return fn(k, ev)
+	}
+
+	switch vt := v.(type) {
+	case Array:
+		for i, ev := range vt {
+			if err := step(Int(i), ev); err != nil {
+				return err
+			}
+		}
+	case Map:
+		for _, k := range vt.keys() {
+			if err := step(String(k), vt[k]); err != nil {
+				return err
+			}
+		}
+	case Port:
+		if vt.cargo.IsArray() {
+			var i int
+			return vt.cargo.Iterate(func(ev Value) error {
+				i++
+				return step(Int(i-1), ev)
+			})
+		}
+
+		for _, k := range vt.cargo.Keys() {
+			if err := ctx.Budget.step(); err != nil {
+				return err
+			}
+
+			ev, err := vt.cargo.Get(k)
+			if err != nil {
+				return err
+			}
+
+			if err = fn(String(k), ev); err != nil {
+				return err
+			}
+		}
+	default:
+		return iterateError(v)
+	}
+
+	return nil
+}
+
+// isIterable reports whether eachElem can iterate over the value
+func isIterable(v Value) bool {
+	switch v.(type) {
+	case Array, Map, Port:
+		return true
+	default:
+		return false
+	}
+}
+
+// eachElem passes every element of an array, or every value of a map in
+// the order of its keys, to fn. It returns the error of fn as-is. Every
+// element is a step of the evaluation.
+func eachElem(ctx Context, v Value, fn func(v Value) error) error {
+	step := func(ev Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		}
+
+		return fn(ev)
+	}
+
+	switch vt := v.(type) {
+	case Array:
+		for _, ev := range vt {
+			if err := step(ev); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case Map:
+		for _, k := range vt.keys() {
+			if err := step(vt[k]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case Port:
+		return vt.cargo.Iterate(step)
+	default:
+		return iterateError(v)
+	}
+}
+
+// iterateError is returned for values that cannot be iterated over
+func iterateError(v Value) error {
+	return errors.New("cannot iterate over value of type '" + v.whichType().String() + "'")
+}
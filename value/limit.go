@@ -0,0 +1,186 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Limits bound the resources that an evaluation can use, a limit that
+// is zero is not enforced.
+type Limits struct {
+
+	// Steps limits the number of pipes, operators, function calls and
+	// builtin outputs that are evaluated, it bounds the running time
+	Steps int
+
+	// Depth limits how deeply calls of functions that are defined in
+	// the query can be nested
+	Depth int
+
+	// Outputs limits the number of outputs of the query, it is enforced
+	// by the caller that receives them, see Budget.Output
+	Outputs int
+
+	// Size limits the total size of the values that are created by
+	// constructors, arithmetic, updates and builtins: the bytes of
+	// strings, the elements of arrays and the entries of maps. Values
+	// that a builtin passes on unchanged are counted as well. Large
+	// strings and arrays are checked against the limit before they are
+	// built, such that the evaluation fails before using the memory.
+	Size int
+}
+
+// LimitError is returned when an evaluation exceeds one of its limits.
+// Like the cancellation of an evaluation it cannot be caught by 'try',
+// '?' or '//'.
+type LimitError struct {
+	Limit string // name of the limit: "steps", "depth", "outputs" or "size"
+	Max   int    // the value of the limit
+}
+
+func (e *LimitError) Error() string {
+	return "jqp/value: evaluation exceeded the " + e.Limit + " limit of " + strconv.Itoa(e.Max)
+}
+
+// Budget tracks the resources that a single evaluation uses against its
+// limits and stops the evaluation once its context is done. It is not
+// safe for concurrent use.
+type Budget struct {
+	limits   Limits
+	ctx      context.Context
+	deadline time.Time // deadline of ctx, zero if it has none
+	steps    int
+	size     int
+	outputs  int
+}
+
+// NewBudget creates a budget for an evaluation with the limits that
+// stops it when 'ctx' is done
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	deadline, _ := ctx.Deadline()
+	return &Budget{limits: limits, ctx: ctx, deadline: deadline}
+}
+
+// yieldSteps is the number of steps after which the evaluation yields to
+// other goroutines and checks the deadline of its context itself. On a
+// single thread, as in js/wasm, the timer of the context cannot fire
+// while the evaluation is busy.
+const yieldSteps = 1 << 10
+
+// step counts an evaluation step and checks whether the evaluation
+// should stop
+func (b *Budget) step() error {
+	if b == nil {
+		return nil
+	}
+
+	select {
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	default:
+	}
+
+	b.steps++
+	if b.steps%yieldSteps == 0 {
+		runtime.Gosched()
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+
+	if b.limits.Steps > 0 && b.steps > b.limits.Steps {
+		return &LimitError{Limit: "steps", Max: b.limits.Steps}
+	}
+
+	return nil
+}
+
+// depth checks the nesting of function calls
+func (b *Budget) depth(depth int) error {
+	if b != nil && b.limits.Depth > 0 && depth > b.limits.Depth {
+		return &LimitError{Limit: "depth", Max: b.limits.Depth}
+	}
+
+	return nil
+}
+
+// alloc counts the size of values that were created
+func (b *Budget) alloc(size int) error {
+	if b == nil || b.limits.Size <= 0 {
+		return nil
+	}
+
+	b.size += size
+	if b.size > b.limits.Size {
+		return &LimitError{Limit: "size", Max: b.limits.Size}
+	}
+
+	return nil
+}
+
+// fits checks whether a value of the size can be created within the size
+// limit before it is built. The size is not counted, values are counted
+// once they are output.
+func (b *Budget) fits(size int) error {
+	if b == nil || b.limits.Size <= 0 || size <= b.limits.Size-b.size {
+		return nil
+	}
+
+	return &LimitError{Limit: "size", Max: b.limits.Size}
+}
+
+// Output counts an output of the evaluation, it returns an error once
+// there are more outputs than the limit allows
+func (b *Budget) Output() error {
+	if b == nil {
+		return nil
+	}
+
+	b.outputs++
+	if b.limits.Outputs > 0 && b.outputs > b.limits.Outputs {
+		return &LimitError{Limit: "outputs", Max: b.limits.Outputs}
+	}
+
+	return nil
+}
+
+// charge returns an emit that counts the size of every value before it
+// is passed to emit
+func (ctx Context) charge(emit Emit) Emit {
+	if ctx.Budget == nil || ctx.Budget.limits.Size <= 0 {
+		return emit
+	}
+
+	return func(v Value) error {
+		if err := ctx.Budget.alloc(sizeOf(v)); err != nil {
+			return err
+		}
+
+		return emit(v)
+	}
+}
+
+// sizeOf returns the size of the value as it is counted against the
+// size limit, without the values it holds
+func sizeOf(v Value) int {
+	switch vt := v.(type) {
+	case String:
+		return len(vt)
+	case Array:
+		return len(vt)
+	case Map:
+		return len(vt)
+	default:
+		return 0
+	}
+}
+
+// isAbort reports whether the error stops the evaluation as a whole,
+// such errors are not caught and are returned as-is.
+func isAbort(err error) bool {
+	var le *LimitError
+	return errors.As(err, &le) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
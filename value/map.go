@@ -20,19 +20,30 @@ func (m Map) String() string {
 	return "{" + strings.Join(vals, ", ") + "}"
 }
 
-func (m Map) Eval(ctx Context) Value {
-	return m
+// keys returns the keys of the map in sorted order
+func (m Map) keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func (m Map) Eval(ctx Context, emit Emit) error {
+	return emit(m)
 }
 
 func (m Map) whichType() valueType {
 	return mapType
 }
 
-func (m Map) toType(which valueType) Value {
+func (m Map) toType(which valueType) (Value, error) {
 	switch which {
 	case mapType:
-		return m
+		return m, nil
 	default:
-		panic("type coversion from '" + m.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(m, which)
 	}
 }
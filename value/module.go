@@ -0,0 +1,110 @@
+package value
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Module is a compiled module: the functions it defines after the
+// modules it depends on are imported or included.
+type Module struct {
+	Meta  Map // metadata of the 'module' directive, may be nil
+	Deps  []*Dependency
+	Funcs []*FuncDef
+}
+
+// Dependency is an import or include of a module. The functions of an
+// imported module are prefixed with its alias, as in 'alias::fn', while
+// the functions of an included module keep their name.
+type Dependency struct {
+	Path   string // path of the module as written in the directive
+	Alias  string // alias of an import, empty for an include
+	Meta   Map    // metadata of the directive, may be nil
+	Module *Module
+}
+
+// Import returns a copy of the context in which the functions of the
+// dependencies are defined in order. The functions of a module are
+// evaluated in the scope of the module, they cannot invoke functions
+// of the context they are imported into.
+func (ctx Context) Import(deps ...*Dependency) Context {
+	for _, dep := range deps {
+		mctx := ctx
+		mctx.scope = nil
+		mctx = mctx.Import(dep.Module.Deps...).Define(dep.Module.Funcs...)
+
+		// the functions of the module, including those of modules it
+		// included, are those without a prefix. Only the innermost
+		// function of every name is visible.
+		var fns []*closure
+		seen := map[string]bool{}
+		for s := mctx.scope; s != nil; s = s.parent {
+			if s.fn == nil || seen[s.fn.key] || strings.Contains(s.fn.key, "::") {
+				continue
+			}
+
+			seen[s.fn.key] = true
+			fns = append(fns, s.fn)
+		}
+
+		for i := len(fns) - 1; i >= 0; i-- {
+			c := *fns[i]
+			if dep.Alias != "" {
+				c.key = dep.Alias + "::" + c.key
+			}
+
+			ctx = ctx.define(&c)
+		}
+	}
+
+	return ctx
+}
+
+// meta describes the module as the modulemeta builtin outputs it: the
+// metadata of the module with its dependencies and the functions it
+// defines in order
+func (m *Module) meta() Map {
+	res := make(Map, len(m.Meta)+2)
+	for k, v := range m.Meta {
+		res[k] = v
+	}
+
+	deps := make(Array, 0, len(m.Deps))
+	for _, dep := range m.Deps {
+		dm := make(Map, len(dep.Meta)+3)
+		for k, v := range dep.Meta {
+			dm[k] = v
+		}
+
+		if dep.Alias != "" {
+			dm["as"] = String(dep.Alias)
+		}
+
+		dm["is_data"] = Bool(false)
+		dm["relpath"] = String(dep.Path)
+		deps = append(deps, dm)
+	}
+
+	defs := make([]string, 0, len(m.Funcs))
+	for _, fn := range m.Funcs {
+		defs = append(defs, funcKey(fn.Name, len(fn.Params)))
+	}
+
+	res["deps"], res["defs"] = deps, stringArray(defs)
+	return res
+}
+
+func builtinModuleMeta(ctx Context, in Value, args []Expr, emit Emit) error {
+	path, ok := in.(String)
+	if !ok {
+		return errors.New("modulemeta input must be a string, got value of type '" + in.whichType().String() + "'")
+	}
+
+	m, ok := ctx.Modules[string(path)]
+	if !ok {
+		return errors.New("module " + strconv.Quote(string(path)) + " was not loaded when the query was compiled")
+	}
+
+	return emit(m.meta())
+}
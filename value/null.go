@@ -0,0 +1,19 @@
+package value
+
+var _ Value = Null{}
+
+// Null is the absence of a value
+type Null struct{}
+
+func (n Null) String() string                    { return "null" }
+func (n Null) Eval(ctx Context, emit Emit) error { return emit(n) }
+
+func (n Null) whichType() valueType { return nullType }
+func (n Null) toType(which valueType) (Value, error) {
+	switch which {
+	case nullType:
+		return n, nil
+	default:
+		return nil, conversionError(n, which)
+	}
+}
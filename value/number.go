@@ -0,0 +1,134 @@
+package value
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// NumberType selects the native type that numbers are
+// converted to when converting back to the go type system
+type NumberType int
+
+const (
+	// IntNumbers converts ints to int, or to int64 when the value
+	// doesn't fit an int on the platform, and floats to float64
+	IntNumbers NumberType = iota
+
+	// Int64Numbers converts ints to int64 and floats to float64
+	Int64Numbers
+
+	// Float64Numbers converts all numbers to float64, like
+	// encoding/json does when decoding into an interface{}
+	Float64Numbers
+
+	// JSONNumbers converts all numbers to a json.Number that
+	// holds the decimal text of the number
+	JSONNumbers
+
+	// BigNumbers converts ints and floats like IntNumbers, decimals
+	// are converted to a json.Number that holds their exact text
+	BigNumbers
+)
+
+// nativeNumbers returns the number type that values are converted to
+// when they are written into a port, BigNumbers in the big number mode
+func nativeNumbers(bigNumbers bool) NumberType {
+	if bigNumbers {
+		return BigNumbers
+	}
+
+	return IntNumbers
+}
+
+// toNative converts the Int or Float 'v' to the native number type
+func (nt NumberType) toNative(v Value) interface{} {
+	if d, ok := v.(Decimal); ok {
+		if nt == JSONNumbers || nt == BigNumbers {
+			return json.Number(d.String())
+		}
+
+		v = d.narrow()
+	}
+
+	switch nt {
+	case Int64Numbers:
+		if i, ok := v.(Int); ok {
+			return int64(i)
+		}
+	case Float64Numbers:
+		if i, ok := v.(Int); ok {
+			return float64(i)
+		}
+	case JSONNumbers:
+		if i, ok := v.(Int); ok {
+			return json.Number(strconv.FormatInt(int64(i), 10))
+		}
+
+		return json.Number(strconv.FormatFloat(float64(v.(Float)), 'g', -1, 64))
+	default:
+		if i, ok := v.(Int); ok {
+			if int64(int(i)) != int64(i) {
+				return int64(i) // would truncate on 32-bit platforms
+			}
+
+			return int(i)
+		}
+	}
+
+	return float64(v.(Float))
+}
+
+// fromUint converts an unsigned integer, values that don't fit
+// an Int become a Float, or a Decimal in the big number mode.
+func fromUint(u uint64, bigNumbers bool) Value {
+	if u > math.MaxInt64 {
+		if bigNumbers {
+			return Decimal{rat: new(big.Rat).SetInt(new(big.Int).SetUint64(u))}
+		}
+
+		return Float(float64(u))
+	}
+
+	return Int(int64(u))
+}
+
+// fromFloat32 converts a float32 through its shortest decimal
+// representation such that 0.1 doesn't turn into 0.10000000149
+func fromFloat32(f float32) Value {
+	f64, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return Float(f64)
+}
+
+// fromJSONNumber converts the text of a json number into an Int when
+// it is an integer that fits, else into a Float, or a Decimal that keeps
+// the text in the big number mode.
+func fromJSONNumber(n json.Number, bigNumbers bool) (Value, error) {
+	if i64, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return Int(i64), nil
+	}
+
+	f64, err := strconv.ParseFloat(string(n), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, errors.New("jqp/value: invalid json number: " + strconv.Quote(string(n)))
+	}
+
+	if bigNumbers {
+		return ParseDecimal(string(n))
+	}
+
+	return Float(f64), nil
+}
+
+// fromRat converts an exact number into an Int when it is an integer
+// that fits, else into the nearest Float, or a Decimal in the big number
+// mode.
+func fromRat(r *big.Rat, bigNumbers bool) Value {
+	if bigNumbers {
+		return Decimal{rat: r}.shrink()
+	}
+
+	return Decimal{rat: r}.narrow()
+}
@@ -0,0 +1,684 @@
+package value
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// EmitPath is called by a path expression for every output with the
+// path of the output, relative to the original input, and its value.
+type EmitPath func(path Array, v Value) error
+
+// PathExpr is implemented by expressions that can also be evaluated
+// into paths, such as '.a[0]' in 'path(.a[0])' or '.a[] |= f'. The
+// paths are arrays of object keys, array indexes and slice bounds.
+type PathExpr interface {
+	Expr
+
+	// EvalPath evaluates the expression with the Input of the context
+	// as its input, which is found at 'path'.
+	EvalPath(ctx Context, path Array, emit EmitPath) error
+}
+
+// evalPath evaluates 'e' as a path expression with the input at 'path'.
+// Expressions that are not path expressions may only output nothing.
+func evalPath(ctx Context, e Expr, path Array, emit EmitPath) error {
+	if pe, ok := e.(PathExpr); ok {
+		return pe.EvalPath(ctx, path, emit)
+	}
+
+	return evalNoPath(ctx, e)
+}
+
+// evalNoPath evaluates an expression that is not a path expression,
+// it fails on its first output
+func evalNoPath(ctx Context, e Expr) error {
+	return e.Eval(ctx, func(v Value) error {
+		return invalidPathError(v)
+	})
+}
+
+// invalidPathError is returned when a value is output where a path
+// is expected
+func invalidPathError(v Value) error {
+	s, err := toJSON(v)
+	if err != nil {
+		s = v.String()
+	}
+
+	return errors.New("invalid path expression with result " + s)
+}
+
+// appendPath returns a copy of the path with the key appended
+func appendPath(path Array, keys ...Value) Array {
+	return append(path[:len(path):len(path)], keys...)
+}
+
+// pathArray returns the value as a path
+func pathArray(v Value) (Array, error) {
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := v.(Array)
+	if !ok {
+		return nil, errors.New("path must be an array, got value of type '" + v.whichType().String() + "'")
+	}
+
+	return path, nil
+}
+
+// sliceKey is the path key of a slice with optional bounds
+func sliceKey(from, to Value) Map {
+	key := Map{"start": Null{}, "end": Null{}}
+	if from != nil {
+		key["start"] = from
+	}
+
+	if to != nil {
+		key["end"] = to
+	}
+
+	return key
+}
+
+// sliceKeyBounds returns the bounds of a slice key, or false if the
+// key is not a slice key
+func sliceKeyBounds(key Value) (from, to Value, ok bool) {
+	m, ok := key.(Map)
+	if !ok {
+		return nil, nil, false
+	}
+
+	from, to = m["start"], m["end"]
+	if _, null := from.(Null); null {
+		from = nil
+	}
+
+	if _, null := to.(Null); null {
+		to = nil
+	}
+
+	return from, to, true
+}
+
+// maxArrayIndex is the largest index that can be set in an array, up to
+// which arrays are padded with null
+const maxArrayIndex = 1<<26 - 1
+
+// indexKey converts a number key to an index in a sequence of
+// length 'n', negative keys count from the end. Keys that are not
+// finite or don't fit an int are clamped, such that they are out of
+// the range of every sequence.
+func indexKey(n int, key Value) (int, bool) {
+	if !isNumber(key) {
+		return 0, false
+	}
+
+	f, _ := key.toType(floatType)
+	switch x := math.Trunc(float64(f.(Float))); {
+	case math.IsNaN(x) || x >= math.MaxInt32:
+		return math.MaxInt32, true
+	case x < 0 && x+float64(n) < 0:
+		return -1, true
+	case x < 0:
+		return int(x) + n, true
+	default:
+		return int(x), true
+	}
+}
+
+// keyError is returned when a key cannot be used on a value
+func keyError(v, key Value) error {
+	return errors.New("cannot index " + typeName(v) + " with value of type '" + key.whichType().String() + "'")
+}
+
+// getKey reads the key from the value as a path expression does: keys
+// that are missing and keys read from null result in null
+func getKey(v, key Value) (Value, error) {
+	if from, to, ok := sliceKeyBounds(key); ok {
+		if _, null := v.(Null); !null && typeName(v) != "array" {
+			return nil, keyError(v, key)
+		}
+
+		return slice(v, from, to)
+	}
+
+	switch vt := v.(type) {
+	case Null:
+		switch key.(type) {
+		case String, Int, Float, Decimal, Null:
+			return Null{}, nil
+		}
+	case Map:
+		if k, ok := key.(String); ok {
+			if ev, ok := vt[string(k)]; ok {
+				return ev, nil
+			}
+
+			return Null{}, nil
+		}
+	case Array:
+		if i, ok := indexKey(len(vt), key); ok {
+			if i < 0 || i >= len(vt) {
+				return Null{}, nil
+			}
+
+			return vt[i], nil
+		}
+	case Port:
+		if k, ok := key.(String); ok && !vt.cargo.IsArray() {
+			ev, err := vt.cargo.Get(string(k))
+			if _, missing := err.(noKeyError); missing {
+				return Null{}, nil
+			}
+
+			return ev, err
+		}
+
+		if i, ok := indexKey(vt.cargo.Len(), key); ok && vt.cargo.IsArray() {
+			if i < 0 || i >= vt.cargo.Len() {
+				return Null{}, nil
+			}
+
+			return vt.cargo.Index(i)
+		}
+	}
+
+	return nil, keyError(v, key)
+}
+
+// readKey reads the key from the value as a plain field or index read
+// does: keys that are missing from objects and indexes that are out of
+// the range of arrays are errors, other keys are read as getKey does
+func readKey(v, key Value) (Value, error) {
+	switch vt := v.(type) {
+	case Map:
+		if k, ok := key.(String); ok {
+			if _, ok := vt[string(k)]; !ok {
+				return nil, noKeyError(k)
+			}
+		}
+	case Array:
+		if i, ok := indexKey(len(vt), key); ok && (i < 0 || i >= len(vt)) {
+			return nil, indexRangeError(key)
+		}
+	case Port:
+		if k, ok := key.(String); ok && !vt.cargo.IsArray() {
+			return vt.cargo.Get(string(k))
+		}
+
+		if i, ok := indexKey(vt.cargo.Len(), key); ok && vt.cargo.IsArray() && (i < 0 || i >= vt.cargo.Len()) {
+			return nil, indexRangeError(key)
+		}
+	}
+
+	return getKey(v, key)
+}
+
+// indexRangeError is returned when an index is read that is out of the
+// range of an array
+func indexRangeError(key Value) error {
+	f, _ := key.toType(floatType)
+	return errors.New("index out of range: " + strconv.FormatFloat(math.Trunc(float64(f.(Float))), 'f', -1, 64))
+}
+
+// getPath reads the value at the path
+func getPath(v Value, path Array) (Value, error) {
+	for _, key := range path {
+		var err error
+		if v, err = getKey(v, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// setPath returns a copy of 'v' in which the value at the path is
+// replaced by 'nv'. Only the maps and arrays along the path are copied,
+// missing maps and arrays are created and arrays are padded with null.
+func setPath(ctx Context, v Value, path Array, nv Value) (Value, error) {
+	if len(path) == 0 {
+		return nv, nil
+	}
+
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[0]
+	if _, null := v.(Null); null {
+		switch key.(type) {
+		case String:
+			v = Map{}
+		default:
+			v = Array{}
+		}
+	}
+
+	switch vt := v.(type) {
+	case Map:
+		k, ok := key.(String)
+		if !ok {
+			break
+		}
+
+		var ev Value = Null{}
+		if old, ok := vt[string(k)]; ok {
+			ev = old
+		}
+
+		if ev, err = setPath(ctx, ev, path[1:], nv); err != nil {
+			return nil, err
+		}
+
+		res := make(Map, len(vt)+1)
+		for mk, mv := range vt {
+			res[mk] = mv
+		}
+
+		res[string(k)] = ev
+		return res, nil
+	case Array:
+		if from, to, ok := sliceKeyBounds(key); ok {
+			i, j, err := sliceBounds(len(vt), from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			sv, err := setPath(ctx, vt[i:j:j], path[1:], nv)
+			if err != nil {
+				return nil, err
+			}
+
+			if sv, err = expand(sv); err != nil {
+				return nil, err
+			}
+
+			sa, ok := sv.(Array)
+			if !ok {
+				return nil, errors.New("a slice of an array can only be assigned another array")
+			}
+
+			return concatArrays(concatArrays(vt[:i:i], sa), vt[j:]), nil
+		}
+
+		i, ok := indexKey(len(vt), key)
+		if !ok {
+			break
+		}
+
+		if i < 0 {
+			return nil, errors.New("out of bounds negative array index")
+		} else if i > maxArrayIndex {
+			return nil, errors.New("array index too large, it must be at most " + strconv.Itoa(maxArrayIndex))
+		}
+
+		var ev Value = Null{}
+		if i < len(vt) {
+			ev = vt[i]
+		}
+
+		if ev, err = setPath(ctx, ev, path[1:], nv); err != nil {
+			return nil, err
+		}
+
+		n := len(vt)
+		if i >= n {
+			n = i + 1
+		}
+
+		if err := ctx.Budget.fits(n); err != nil {
+			return nil, err
+		}
+
+		res := make(Array, n)
+		copy(res, vt)
+		for j := len(vt); j < n; j++ {
+			res[j] = Null{}
+		}
+
+		res[i] = ev
+		return res, nil
+	}
+
+	return nil, keyError(v, key)
+}
+
+// delPath returns a copy of 'v' without the value at the path, paths
+// that don't exist leave the value as-is
+func delPath(ctx Context, v Value, path Array) (Value, error) {
+	if len(path) == 0 {
+		return Null{}, nil
+	}
+
+	if _, null := v.(Null); null {
+		return v, nil
+	}
+
+	if len(path) > 1 {
+		ev, err := getKey(v, path[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if _, null := ev.(Null); null {
+			return v, nil
+		}
+
+		if ev, err = delPath(ctx, ev, path[1:]); err != nil {
+			return nil, err
+		}
+
+		return setPath(ctx, v, path[:1], ev)
+	}
+
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[0]
+	switch vt := v.(type) {
+	case Map:
+		k, ok := key.(String)
+		if !ok {
+			break
+		}
+
+		res := make(Map, len(vt))
+		for mk, mv := range vt {
+			if mk != string(k) {
+				res[mk] = mv
+			}
+		}
+
+		return res, nil
+	case Array:
+		if from, to, ok := sliceKeyBounds(key); ok {
+			i, j, err := sliceBounds(len(vt), from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			return concatArrays(vt[:i:i], vt[j:]), nil
+		}
+
+		i, ok := indexKey(len(vt), key)
+		if !ok {
+			break
+		}
+
+		if i < 0 || i >= len(vt) {
+			return vt, nil
+		}
+
+		return concatArrays(vt[:i:i], vt[i+1:]), nil
+	}
+
+	return nil, errors.New("cannot delete field at index of type '" + key.whichType().String() + "' from " + typeName(v))
+}
+
+// delPaths deletes all paths from the value, the longest paths and the
+// highest indexes are deleted first such that they don't shift
+func delPaths(ctx Context, v Value, paths Array) (Value, error) {
+	sorted, _, err := sortByKeys(paths, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		path, err := pathArray(sorted[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if v, err = delPath(ctx, v, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// eachPath passes the path and value of every value nested in 'v' to
+// fn, parents are passed before their children
+func eachPath(v Value, path Array, fn EmitPath) error {
+	switch vt := v.(type) {
+	case Array:
+		for i, ev := range vt {
+			if err := eachChildPath(ev, appendPath(path, Int(i)), fn); err != nil {
+				return err
+			}
+		}
+	case Map:
+		for _, k := range vt.keys() {
+			if err := eachChildPath(vt[k], appendPath(path, String(k)), fn); err != nil {
+				return err
+			}
+		}
+	case Port:
+		if vt.cargo.IsArray() {
+			var i int
+			return vt.cargo.Iterate(func(ev Value) error {
+				i++
+				return eachChildPath(ev, appendPath(path, Int(i-1)), fn)
+			})
+		}
+
+		for _, k := range vt.cargo.Keys() {
+			ev, err := vt.cargo.Get(k)
+			if err != nil {
+				return err
+			}
+
+			if err = eachChildPath(ev, appendPath(path, String(k)), fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// eachChildPath passes the child itself and then its children to fn
+func eachChildPath(v Value, path Array, fn EmitPath) error {
+	if err := fn(path, v); err != nil {
+		return err
+	}
+
+	return eachPath(v, path, fn)
+}
+
+func builtinPath(ctx Context, in Value, args []Expr, emit Emit) error {
+	return evalPath(ctx.with(Input, in), args[0], Array{}, func(path Array, v Value) error {
+		return emit(path)
+	})
+}
+
+func builtinPaths(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachPath(in, Array{}, func(path Array, v Value) error {
+		if len(args) == 0 {
+			return emit(path)
+		}
+
+		return eachArg(ctx, args[0], v, func(ok Value) error {
+			if !truthy(ok) {
+				return nil
+			}
+
+			return emit(path)
+		})
+	})
+}
+
+func builtinGetPath(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(pv Value) error {
+		path, err := pathArray(pv)
+		if err != nil {
+			return err
+		}
+
+		v, err := getPath(in, path)
+		if err != nil {
+			return err
+		}
+
+		return emit(v)
+	})
+}
+
+func builtinSetPath(ctx Context, in Value, args []Expr, emit Emit) error {
+	return cartesian(ctx.with(Input, in), args, nil, func(argv []Value) error {
+		path, err := pathArray(argv[0])
+		if err != nil {
+			return err
+		}
+
+		v, err := setPath(ctx, in, path, argv[1])
+		if err != nil {
+			return err
+		}
+
+		return emit(v)
+	})
+}
+
+func builtinDelPaths(ctx Context, in Value, args []Expr, emit Emit) error {
+	return eachArg(ctx, args[0], in, func(pv Value) error {
+		paths, err := pathArray(pv)
+		if err != nil {
+			return err
+		}
+
+		v, err := delPaths(ctx, in, paths)
+		if err != nil {
+			return err
+		}
+
+		return emit(v)
+	})
+}
+
+func builtinDel(ctx Context, in Value, args []Expr, emit Emit) error {
+	paths := Array{}
+	if err := evalPath(ctx.with(Input, in), args[0], Array{}, func(path Array, v Value) error {
+		paths = append(paths, path)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	v, err := delPaths(ctx, in, paths)
+	if err != nil {
+		return err
+	}
+
+	return emit(v)
+}
+
+// PathFunc implements a builtin function as a path expression, it is
+// like a BuiltinFunc but emits the paths of its outputs.
+type PathFunc func(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error
+
+// pathBuiltins holds the builtins that can be used in path expressions
+// by their name and arity.
+var pathBuiltins map[string]PathFunc
+
+func init() {
+	pathBuiltins = map[string]PathFunc{
+		"empty/0":   pathEmpty,
+		"error/0":   pathError,
+		"error/1":   pathError,
+		"select/1":  pathSelect,
+		"getpath/1": pathGetPath,
+		"first/0":   pathElem(0),
+		"last/0":    pathElem(-1),
+		"first/1":   pathFirst,
+		"last/1":    pathLast,
+		"recurse/0": pathRecurse,
+		"recurse/1": pathRecurse,
+		"recurse/2": pathRecurse,
+	}
+}
+
+func pathEmpty(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	return nil
+}
+
+func pathError(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	return builtinError(ctx, in, args, nil)
+}
+
+func pathSelect(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		if !truthy(v) {
+			return nil
+		}
+
+		return emit(path, in)
+	})
+}
+
+func pathGetPath(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	return eachArg(ctx, args[0], in, func(pv Value) error {
+		sub, err := pathArray(pv)
+		if err != nil {
+			return err
+		}
+
+		v, err := getPath(in, sub)
+		if err != nil {
+			return err
+		}
+
+		return emit(appendPath(path, sub...), v)
+	})
+}
+
+// pathElem creates the path versions of first and last, which are
+// the paths of the element at index 'i'
+func pathElem(i int) PathFunc {
+	return func(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+		v, err := getKey(in, Int(i))
+		if err != nil {
+			return err
+		}
+
+		return emit(appendPath(path, Int(i)), v)
+	}
+}
+
+func pathFirst(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	stop := &stopError{}
+	err := evalPath(ctx.with(Input, in), args[0], path, func(p Array, v Value) error {
+		if err := emit(p, v); err != nil {
+			return err
+		}
+
+		return stop
+	})
+
+	if err == stop {
+		return nil
+	}
+
+	return err
+}
+
+func pathLast(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	var last Array
+	var lastv Value
+	if err := evalPath(ctx.with(Input, in), args[0], path, func(p Array, v Value) error {
+		last, lastv = p, v
+		return nil
+	}); err != nil || last == nil {
+		return err
+	}
+
+	return emit(last, lastv)
+}
@@ -0,0 +1,32 @@
+package value
+
+// Pipe chains two filters: each output of the left expression
+// becomes the input '$' of the right expression
+type Pipe struct {
+	Left  Expr
+	Right Expr
+	Pos   int
+}
+
+// Eval will evaluate the right side for every output of the left side
+func (p *Pipe) Eval(ctx Context, emit Emit) error {
+	return p.Left.Eval(ctx, func(v Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		}
+
+		return p.Right.Eval(ctx.with(Input, v), emit)
+	})
+}
+
+// EvalPath will evaluate the right side as path expression for every
+// path of the left side
+func (p *Pipe) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return evalPath(ctx, p.Left, path, func(lp Array, v Value) error {
+		if err := ctx.Budget.step(); err != nil {
+			return err
+		}
+
+		return evalPath(ctx.with(Input, v), p.Right, lp, emit)
+	})
+}
@@ -1,49 +1,178 @@
 package value
 
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
 // slicePortCargo is a cargo implementation that
-// provides range access for the index operator
-type slicePortCargo []interface{}
+// provides index and range access for the index operator
+type slicePortCargo struct {
+	s          []interface{}
+	bigNumbers bool // convert elements in the big number mode
+}
+
+func (p slicePortCargo) Get(k string) (Value, error) {
+	return nil, errors.New("get on slice port cargo is not supported")
+}
+
+func (p slicePortCargo) Index(i int) (Value, error) {
+	if i < 0 || i >= len(p.s) {
+		return nil, errors.New("index out of range: " + strconv.Itoa(i))
+	}
+
+	return fromNative(p.s[i], true, p.bigNumbers)
+}
+
+func (p slicePortCargo) Range(i, j int) (Value, error) {
+	return Port{slicePortCargo{p.s[i:j:j], p.bigNumbers}}, nil
+}
+
+func (p slicePortCargo) Len() int       { return len(p.s) }
+func (p slicePortCargo) IsArray() bool  { return true }
+func (p slicePortCargo) Keys() []string { return nil }
+func (p slicePortCargo) Iterate(emit Emit) error {
+	for i := range p.s {
+		v, err := p.Index(i)
+		if err != nil {
+			return err
+		}
 
-func (p slicePortCargo) Get(k string) Value   { panic("get on slice port cargo is not supported") }
-func (p slicePortCargo) Range(i, j int) Value { return FromNative(p[i], true) }
+		if err = emit(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p slicePortCargo) Set(k string, v Value) error {
+	return errors.New("set on slice port cargo is not supported")
+}
+
+func (p slicePortCargo) SetIndex(i int, v Value) error {
+	if i < 0 || i >= len(p.s) {
+		return errors.New("index out of range: " + strconv.Itoa(i))
+	}
+
+	nv, err := ToNativeAs(v, nativeNumbers(p.bigNumbers))
+	if err != nil {
+		return err
+	}
+
+	p.s[i] = nv
+	return nil
+}
+
+func (p slicePortCargo) Delete(k string) error {
+	return errors.New("delete on slice port cargo is not supported")
+}
+
+func (p slicePortCargo) native() interface{} { return p.s }
 
 var _ PortCargo = slicePortCargo{}
 
 // mapPortCargo provides a concrete port cargo
 // for interface values mapped by strings
-type mapPortCargo map[string]interface{}
+type mapPortCargo struct {
+	m          map[string]interface{}
+	bigNumbers bool // convert fields in the big number mode
+}
 
-func (p mapPortCargo) Range(i, j int) Value { panic("range on map port cargo is not supported") }
-func (p mapPortCargo) Get(k string) Value   { return FromNative(p[k], true) }
+func (p mapPortCargo) Index(i int) (Value, error) {
+	return nil, errors.New("index on map port cargo is not supported")
+}
 
-var _ PortCargo = mapPortCargo{}
+func (p mapPortCargo) Range(i, j int) (Value, error) {
+	return nil, errors.New("range on map port cargo is not supported")
+}
 
-// provides a cargo implementation such that the dot
-// string operant can be upgraded for the operators
-// implementation
-type stringPortCargo String
+func (p mapPortCargo) Get(k string) (Value, error) {
+	v, ok := p.m[k]
+	if !ok {
+		return nil, noKeyError(k)
+	}
 
-func (p stringPortCargo) Range(i, j int) Value { panic("range on int port cargo is not supported") }
-func (p stringPortCargo) Get(k string) Value {
-	return String(p)
+	return fromNative(v, true, p.bigNumbers)
 }
 
-var _ PortCargo = stringPortCargo("")
+func (p mapPortCargo) Len() int      { return len(p.m) }
+func (p mapPortCargo) IsArray() bool { return false }
+func (p mapPortCargo) Keys() []string {
+	keys := make([]string, 0, len(p.m))
+	for k := range p.m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
 
-// provides a cargo implementation such that the index
-// int operant can be upgraded for the operators
-// implementation
-type intPortCargo Int
+func (p mapPortCargo) Iterate(emit Emit) error {
+	for _, k := range p.Keys() {
+		v, err := p.Get(k)
+		if err != nil {
+			return err
+		}
 
-func (p intPortCargo) Range(i, j int) Value { panic("range on int port cargo is not supported") }
-func (p intPortCargo) Get(k string) Value   { return Int(p) }
+		if err = emit(v); err != nil {
+			return err
+		}
+	}
 
-var _ PortCargo = intPortCargo(0)
+	return nil
+}
+
+func (p mapPortCargo) Set(k string, v Value) error {
+	nv, err := ToNativeAs(v, nativeNumbers(p.bigNumbers))
+	if err != nil {
+		return err
+	}
+
+	p.m[k] = nv
+	return nil
+}
+
+func (p mapPortCargo) SetIndex(i int, v Value) error {
+	return errors.New("set index on map port cargo is not supported")
+}
+
+func (p mapPortCargo) Delete(k string) error {
+	delete(p.m, k)
+	return nil
+}
+
+func (p mapPortCargo) native() interface{} { return p.m }
+
+var _ PortCargo = mapPortCargo{}
+
+// noKeyError is returned when a field is read that doesn't exist, path
+// expressions read such fields as null
+type noKeyError string
+
+func (k noKeyError) Error() string { return "object doesn't have key: " + string(k) }
 
 // PortCargo is the value held in the port
 type PortCargo interface {
-	Range(i, j int) Value
-	Get(k string) Value
+	Get(k string) (Value, error)   // read the field with key 'k'
+	Index(i int) (Value, error)    // read the element at index 'i'
+	Range(i, j int) (Value, error) // read the elements from 'i' up to 'j'
+	Len() int                      // number of elements or fields
+	Iterate(emit Emit) error       // emit every element or field value
+	IsArray() bool                 // whether the cargo holds elements instead of fields
+	Keys() []string                // sorted keys of the fields, nil for elements
+
+	Set(k string, v Value) error   // write the field with key 'k' in place
+	SetIndex(i int, v Value) error // write the element at index 'i' in place
+	Delete(k string) error         // remove the field with key 'k' in place
+}
+
+// nativeCargo is implemented by port cargo that can return the
+// native value it reads from, such that ports can be converted
+// back to the native type system
+type nativeCargo interface {
+	native() interface{}
 }
 
 // Port is a value type that holds a reference to
@@ -53,15 +182,84 @@ type Port struct{ cargo PortCargo }
 
 var _ Value = Port{}
 
-func (o Port) String() string         { return "{ port }" }
-func (o Port) Eval(ctx Context) Value { return o }
+func (o Port) String() string                    { return "{ port }" }
+func (o Port) Eval(ctx Context, emit Emit) error { return emit(o) }
+
+// expand converts a port into an array or a map of which the elements
+// are read through the cargo, such that operations that need the whole
+// value can treat ports like any other value. Nested ports are kept.
+// Other values are returned as-is.
+func expand(v Value) (Value, error) {
+	p, ok := v.(Port)
+	if !ok {
+		return v, nil
+	}
+
+	if p.cargo.IsArray() {
+		arr := make(Array, 0, p.cargo.Len())
+		if err := p.cargo.Iterate(func(ev Value) error {
+			arr = append(arr, ev)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		return arr, nil
+	}
+
+	keys := p.cargo.Keys()
+	m := make(Map, len(keys))
+	for _, k := range keys {
+		ev, err := p.cargo.Get(k)
+		if err != nil {
+			return nil, err
+		}
+
+		m[k] = ev
+	}
+
+	return m, nil
+}
+
+// expandAll expands the value and every port that is nested in it, such
+// that ported values are read through their cargo as 'keys' and
+// iteration read them
+func expandAll(v Value) (Value, error) {
+	v, err := expand(v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch vt := v.(type) {
+	case Array:
+		res := make(Array, len(vt))
+		for i, ev := range vt {
+			if res[i], err = expandAll(ev); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	case Map:
+		res := make(Map, len(vt))
+		for k, ev := range vt {
+			if res[k], err = expandAll(ev); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	default:
+		return v, nil
+	}
+}
 
 func (o Port) whichType() valueType { return portType }
-func (o Port) toType(which valueType) Value {
+func (o Port) toType(which valueType) (Value, error) {
 	switch which {
 	case portType:
-		return o
+		return o, nil
 	default:
-		panic("type coversion from '" + o.whichType().String() + "' to '" + which.String() + "' not implemented")
+		return nil, conversionError(o, which)
 	}
 }
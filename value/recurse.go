@@ -0,0 +1,129 @@
+package value
+
+import (
+	"os"
+	"strings"
+)
+
+// isContainer reports whether the value has children to recurse into
+func isContainer(v Value) bool {
+	switch v.(type) {
+	case Array, Map, Port:
+		return true
+	default:
+		return false
+	}
+}
+
+// builtinRecurse emits the input and then recursively the outputs of f for
+// every value it emits, as long as cond holds for them. Without arguments
+// it emits every value in the input depth first, ports are read one level
+// at a time while they are visited.
+func builtinRecurse(ctx Context, in Value, args []Expr, emit Emit) error {
+	if err := emit(in); err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		if !isContainer(in) {
+			return nil
+		}
+
+		return eachElem(ctx, in, func(ev Value) error {
+			return builtinRecurse(ctx, ev, args, emit)
+		})
+	}
+
+	return eachArg(ctx, args[0], in, func(v Value) error {
+		if len(args) == 1 {
+			return builtinRecurse(ctx, v, args, emit)
+		}
+
+		return eachArg(ctx, args[1], v, func(ok Value) error {
+			if !truthy(ok) {
+				return nil
+			}
+
+			return builtinRecurse(ctx, v, args, emit)
+		})
+	})
+}
+
+// pathRecurse is the path version of recurse
+func pathRecurse(ctx Context, in Value, path Array, args []Expr, emit EmitPath) error {
+	if len(args) == 0 {
+		return eachChildPath(in, path, emit)
+	}
+
+	if err := emit(path, in); err != nil {
+		return err
+	}
+
+	return evalPath(ctx.with(Input, in), args[0], path, func(p Array, v Value) error {
+		if len(args) == 1 {
+			return pathRecurse(ctx, v, p, args, emit)
+		}
+
+		return eachArg(ctx, args[1], v, func(ok Value) error {
+			if !truthy(ok) {
+				return nil
+			}
+
+			return pathRecurse(ctx, v, p, args, emit)
+		})
+	})
+}
+
+// builtinWalk applies f to every value in the input bottom up: the
+// children of arrays and objects are walked before f is applied to the
+// container that holds them. Like map, all outputs for an element are
+// kept while only the first output for the value of a key is kept.
+func builtinWalk(ctx Context, in Value, args []Expr, emit Emit) error {
+	in, err := expand(in)
+	if err != nil {
+		return err
+	}
+
+	switch vt := in.(type) {
+	case Array:
+		res := make(Array, 0, len(vt))
+		for _, ev := range vt {
+			if err := builtinWalk(ctx, ev, args, func(v Value) error {
+				res = append(res, v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		in = res
+	case Map:
+		res := make(Map, len(vt))
+		for _, k := range vt.keys() {
+			stop := &stopError{}
+			if err := builtinWalk(ctx, vt[k], args, func(v Value) error {
+				res[k] = v
+				return stop
+			}); err != nil && err != stop {
+				return err
+			}
+		}
+
+		in = res
+	}
+
+	return eachArg(ctx, args[0], in, emit)
+}
+
+// builtinEnv emits an object with the environment variables of the process
+func builtinEnv(ctx Context, in Value, args []Expr, emit Emit) error {
+	env := os.Environ()
+	res := make(Map, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			res[kv[:i]] = String(kv[i+1:])
+		}
+	}
+
+	return emit(res)
+}
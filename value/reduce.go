@@ -0,0 +1,77 @@
+package value
+
+// Reduce folds the outputs of the source into a single value, as in
+// 'reduce .[] as $x (0; . + $x)'. For every output of Init the state
+// starts at that output, the Update is then evaluated with the state as
+// its input and the pattern bound to the next output of the source. Its
+// last output becomes the new state, or null if it outputs nothing.
+type Reduce struct {
+	Source  Expr
+	Pattern Pattern
+	Init    Expr
+	Update  Expr
+	Pos     int
+}
+
+// Eval will emit the final state for every output of Init
+func (r *Reduce) Eval(ctx Context, emit Emit) error {
+	return r.Init.Eval(ctx, func(state Value) error {
+		if err := r.Source.Eval(ctx, func(v Value) error {
+			if err := ctx.Budget.step(); err != nil {
+				return err
+			}
+
+			return r.Pattern.Bind(ctx, v, func(bctx Context) error {
+				var last Value = Null{}
+				if err := r.Update.Eval(bctx.with(Input, state), func(v Value) error {
+					last = v
+					return nil
+				}); err != nil {
+					return err
+				}
+
+				state = last
+				return nil
+			})
+		}); err != nil {
+			return err
+		}
+
+		return emit(state)
+	})
+}
+
+// Foreach is like Reduce but emits the intermediate states, as in
+// 'foreach .[] as $x (0; . + $x; [$x, .])'. Every output of Update
+// becomes the state and is passed to Extract, whose outputs are
+// emitted. Without an Extract the states themselves are emitted.
+type Foreach struct {
+	Source  Expr
+	Pattern Pattern
+	Init    Expr
+	Update  Expr
+	Extract Expr
+	Pos     int
+}
+
+// Eval will emit the extracted states for every output of Init
+func (f *Foreach) Eval(ctx Context, emit Emit) error {
+	return f.Init.Eval(ctx, func(state Value) error {
+		return f.Source.Eval(ctx, func(v Value) error {
+			if err := ctx.Budget.step(); err != nil {
+				return err
+			}
+
+			return f.Pattern.Bind(ctx, v, func(bctx Context) error {
+				return f.Update.Eval(bctx.with(Input, state), func(v Value) error {
+					state = v
+					if f.Extract == nil {
+						return emit(v)
+					}
+
+					return f.Extract.Eval(bctx.with(Input, v), emit)
+				})
+			})
+		})
+	})
+}
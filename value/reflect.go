@@ -0,0 +1,527 @@
+package value
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fromReflect converts Go values of types that FromNative doesn't know
+// about. Structs, maps, slices and arrays are not converted up-front but
+// are exposed lazily through a reflection port, pointers and interfaces
+// are followed and nil becomes null.
+func fromReflect(rv reflect.Value, port, bigNumbers bool) (Value, error) {
+	if rv.IsValid() && rv.CanInterface() {
+		switch nv := rv.Interface().(type) {
+		case Value, []interface{}, map[string]interface{}, json.Number, *big.Int, *big.Float, *big.Rat,
+			func(...interface{}) interface{}, func(...interface{}) (interface{}, error):
+			return fromNative(nv, port, bigNumbers)
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return Null{}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return Null{}, nil
+		}
+
+		return fromReflect(rv.Elem(), port, bigNumbers)
+	case reflect.Bool:
+		return Bool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fromUint(rv.Uint(), bigNumbers), nil
+	case reflect.Float32:
+		return fromFloat32(float32(rv.Float())), nil
+	case reflect.Float64:
+		return Float(rv.Float()), nil
+	case reflect.String:
+		return String(rv.String()), nil
+	case reflect.Slice:
+		if rv.IsNil() {
+			return Null{}, nil
+		}
+
+		return Port{reflectPortCargo{rv, bigNumbers}}, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return Null{}, nil
+		}
+
+		if !isMapKeyKind(rv.Type().Key().Kind()) {
+			return nil, errors.New("jqp/value: cant convert map with key type '" + rv.Type().Key().String() + "' from native")
+		}
+
+		return Port{reflectPortCargo{rv, bigNumbers}}, nil
+	case reflect.Struct, reflect.Array:
+		return Port{reflectPortCargo{rv, bigNumbers}}, nil
+	default:
+		return nil, errors.New("jqp/value: cant convert this type from native: " + rv.Type().String())
+	}
+}
+
+// isMapKeyKind reports whether map keys of the kind can be used as
+// object keys, like encoding/json these are strings and integers
+func isMapKeyKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
+// reflectPortCargo provides access to the fields of structs, the entries
+// of maps and the elements of slices and arrays through reflection. The
+// elements are only converted when they are read.
+type reflectPortCargo struct {
+	rv         reflect.Value
+	bigNumbers bool // convert elements in the big number mode
+}
+
+var _ PortCargo = reflectPortCargo{}
+
+func (p reflectPortCargo) Get(k string) (Value, error) {
+	switch p.rv.Kind() {
+	case reflect.Struct:
+		idx, ok := cachedFields(p.rv.Type()).byName[k]
+		if !ok {
+			return nil, noKeyError(k)
+		}
+
+		fv, ok := fieldByIndex(p.rv, idx)
+		if !ok {
+			return Null{}, nil // embedded through a nil pointer
+		}
+
+		return fromReflect(fv, true, p.bigNumbers)
+	case reflect.Map:
+		kv, ok := mapKey(p.rv.Type().Key(), k)
+		if !ok {
+			return nil, noKeyError(k)
+		}
+
+		ev := p.rv.MapIndex(kv)
+		if !ev.IsValid() {
+			return nil, noKeyError(k)
+		}
+
+		return fromReflect(ev, true, p.bigNumbers)
+	default:
+		return nil, errors.New("get on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+func (p reflectPortCargo) Index(i int) (Value, error) {
+	switch p.rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if i < 0 || i >= p.rv.Len() {
+			return nil, errors.New("index out of range: " + strconv.Itoa(i))
+		}
+
+		return fromReflect(p.rv.Index(i), true, p.bigNumbers)
+	default:
+		return nil, errors.New("index on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+func (p reflectPortCargo) Range(i, j int) (Value, error) {
+	switch p.rv.Kind() {
+	case reflect.Slice:
+		return Port{reflectPortCargo{p.rv.Slice3(i, j, j), p.bigNumbers}}, nil
+	case reflect.Array:
+		// arrays can only be sliced when addressable, copy instead
+		sl := reflect.MakeSlice(reflect.SliceOf(p.rv.Type().Elem()), j-i, j-i)
+		for k := i; k < j; k++ {
+			sl.Index(k - i).Set(p.rv.Index(k))
+		}
+
+		return Port{reflectPortCargo{sl, p.bigNumbers}}, nil
+	default:
+		return nil, errors.New("range on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+// copiesRange reports whether slicing the value copies its elements,
+// ports only do so for Go arrays as these cannot be sliced in place
+func copiesRange(v Value) bool {
+	p, ok := v.(Port)
+	if !ok {
+		return false
+	}
+
+	rc, ok := p.cargo.(reflectPortCargo)
+	return ok && rc.rv.Kind() == reflect.Array
+}
+
+func (p reflectPortCargo) Len() int {
+	switch p.rv.Kind() {
+	case reflect.Struct:
+		return len(cachedFields(p.rv.Type()).names)
+	default:
+		return p.rv.Len()
+	}
+}
+
+func (p reflectPortCargo) IsArray() bool {
+	return p.rv.Kind() == reflect.Slice || p.rv.Kind() == reflect.Array
+}
+
+func (p reflectPortCargo) Keys() []string {
+	switch p.rv.Kind() {
+	case reflect.Struct:
+		return cachedFields(p.rv.Type()).names
+	case reflect.Map:
+		keys := make([]string, 0, p.rv.Len())
+		for _, kv := range p.rv.MapKeys() {
+			keys = append(keys, mapKeyString(kv))
+		}
+
+		sort.Strings(keys)
+		return keys
+	default:
+		return nil
+	}
+}
+
+func (p reflectPortCargo) Iterate(emit Emit) error {
+	if p.IsArray() {
+		for i := 0; i < p.rv.Len(); i++ {
+			v, err := p.Index(i)
+			if err != nil {
+				return err
+			}
+
+			if err = emit(v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, k := range p.Keys() {
+		v, err := p.Get(k)
+		if err != nil {
+			return err
+		}
+
+		if err = emit(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p reflectPortCargo) Set(k string, v Value) error {
+	switch p.rv.Kind() {
+	case reflect.Struct:
+		idx, ok := cachedFields(p.rv.Type()).byName[k]
+		if !ok {
+			return errors.New("object doesn't have key: " + k)
+		}
+
+		fv, ok := fieldByIndex(p.rv, idx)
+		if !ok {
+			return errors.New("cannot set field '" + k + "' embedded through a nil pointer")
+		}
+
+		if !fv.CanSet() {
+			return errors.New("cannot set field '" + k + "' of a struct that is not addressable, use a pointer")
+		}
+
+		nv, err := reflectAssignable(fv.Type(), v, p.bigNumbers)
+		if err != nil {
+			return err
+		}
+
+		fv.Set(nv)
+		return nil
+	case reflect.Map:
+		kv, ok := mapKey(p.rv.Type().Key(), k)
+		if !ok {
+			return errors.New("cannot use '" + k + "' as key of map with key type '" + p.rv.Type().Key().String() + "'")
+		}
+
+		nv, err := reflectAssignable(p.rv.Type().Elem(), v, p.bigNumbers)
+		if err != nil {
+			return err
+		}
+
+		p.rv.SetMapIndex(kv, nv)
+		return nil
+	default:
+		return errors.New("set on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+func (p reflectPortCargo) SetIndex(i int, v Value) error {
+	switch p.rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if i < 0 || i >= p.rv.Len() {
+			return errors.New("index out of range: " + strconv.Itoa(i))
+		}
+
+		ev := p.rv.Index(i)
+		if !ev.CanSet() {
+			return errors.New("cannot set element of an array that is not addressable, use a pointer")
+		}
+
+		nv, err := reflectAssignable(ev.Type(), v, p.bigNumbers)
+		if err != nil {
+			return err
+		}
+
+		ev.Set(nv)
+		return nil
+	default:
+		return errors.New("set index on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+func (p reflectPortCargo) Delete(k string) error {
+	switch p.rv.Kind() {
+	case reflect.Map:
+		if kv, ok := mapKey(p.rv.Type().Key(), k); ok {
+			p.rv.SetMapIndex(kv, reflect.Value{})
+		}
+
+		return nil
+	default:
+		return errors.New("delete on " + p.rv.Kind().String() + " port cargo is not supported")
+	}
+}
+
+func (p reflectPortCargo) native() interface{} { return p.rv.Interface() }
+
+// reflectAssignable converts the value into a Go value that can be
+// assigned to the type. Ports are converted back to the value they
+// read from, null becomes the zero value and values that don't fit
+// the type as-is are converted like encoding/json would. In the big
+// number mode decimals keep their precision.
+func reflectAssignable(typ reflect.Type, v Value, bigNumbers bool) (reflect.Value, error) {
+	nv, err := ToNativeAs(v, nativeNumbers(bigNumbers))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if nv == nil {
+		return reflect.Zero(typ), nil
+	}
+
+	rv := reflect.ValueOf(nv)
+	if rv.Type().AssignableTo(typ) {
+		return rv, nil
+	}
+
+	data, err := json.Marshal(nv)
+	if err != nil {
+		return reflect.Value{}, errors.New("cannot assign value of type '" + v.whichType().String() + "' to '" + typ.String() + "': " + err.Error())
+	}
+
+	ptr := reflect.New(typ)
+	if err = json.Unmarshal(data, ptr.Interface()); err != nil {
+		return reflect.Value{}, errors.New("cannot assign value of type '" + v.whichType().String() + "' to '" + typ.String() + "': " + err.Error())
+	}
+
+	return ptr.Elem(), nil
+}
+
+// mapKey converts the object key to a value of the map's key type
+func mapKey(typ reflect.Type, k string) (reflect.Value, bool) {
+	kv := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		kv.SetString(k)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(k, 10, 64)
+		if err != nil || kv.OverflowInt(n) {
+			return kv, false
+		}
+
+		kv.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(k, 10, 64)
+		if err != nil || kv.OverflowUint(n) {
+			return kv, false
+		}
+
+		kv.SetUint(n)
+	}
+
+	return kv, true
+}
+
+// mapKeyString formats a map key as an object key
+func mapKeyString(kv reflect.Value) string {
+	switch kv.Kind() {
+	case reflect.String:
+		return kv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(kv.Int(), 10)
+	default:
+		return strconv.FormatUint(kv.Uint(), 10)
+	}
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but reports false
+// instead of panicking when an embedded struct pointer is nil
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+
+			rv = rv.Elem()
+		}
+
+		rv = rv.Field(x)
+	}
+
+	return rv, true
+}
+
+// structFields are the object keys of a struct type
+type structFields struct {
+	byName map[string][]int // field index sequence by key
+	names  []string         // sorted keys
+}
+
+// fieldsCache maps struct types to their fields such that the
+// struct tags are only parsed once per type.
+var fieldsCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the keys of the struct type, following the rules
+// of encoding/json: exported fields are named by their 'json' tag or
+// their name, fields tagged with "-" are skipped and the fields of
+// embedded structs are promoted unless a shallower field has the same name
+func cachedFields(typ reflect.Type) *structFields {
+	if sf, ok := fieldsCache.Load(typ); ok {
+		return sf.(*structFields)
+	}
+
+	fields := map[string][]reflectField{}
+	visited := map[reflect.Type]bool{}
+	next := []reflectField{{}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+
+		found := map[string][]reflectField{}
+		for _, f := range current {
+			ft := typ
+			if len(f.index) > 0 {
+				ft = typ.FieldByIndex(f.index).Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+			}
+
+			if visited[ft] {
+				continue
+			}
+			visited[ft] = true
+
+			for i := 0; i < ft.NumField(); i++ {
+				sf := ft.Field(i)
+				index := append(append([]int{}, f.index...), i)
+				if sf.Anonymous {
+					st := sf.Type
+					if st.Kind() == reflect.Ptr {
+						st = st.Elem()
+					}
+
+					if sf.PkgPath != "" && st.Kind() != reflect.Struct {
+						continue // unexported non-struct embedding
+					}
+				} else if sf.PkgPath != "" {
+					continue // unexported
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+
+				name := tag
+				if i := strings.Index(tag, ","); i >= 0 {
+					name = tag[:i]
+				}
+
+				st := sf.Type
+				if st.Kind() == reflect.Ptr {
+					st = st.Elem()
+				}
+
+				if name == "" && sf.Anonymous && st.Kind() == reflect.Struct {
+					next = append(next, reflectField{index: index})
+					continue
+				}
+
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+
+				found[name] = append(found[name], reflectField{index: index, tagged: tagged})
+			}
+		}
+
+		for name, fs := range found {
+			if _, ok := fields[name]; !ok {
+				fields[name] = fs
+			}
+		}
+	}
+
+	sf := &structFields{byName: map[string][]int{}}
+	for name, fs := range fields {
+		if dominant, ok := dominantField(fs); ok {
+			sf.byName[name] = dominant.index
+			sf.names = append(sf.names, name)
+		}
+	}
+
+	sort.Strings(sf.names)
+	actual, _ := fieldsCache.LoadOrStore(typ, sf)
+	return actual.(*structFields)
+}
+
+// reflectField is a candidate struct field for an object key
+type reflectField struct {
+	index  []int
+	tagged bool
+}
+
+// dominantField picks the field for a key that is claimed by multiple
+// fields at the same depth, only a single tagged field can win
+func dominantField(fs []reflectField) (reflectField, bool) {
+	if len(fs) == 1 {
+		return fs[0], true
+	}
+
+	var dominant []reflectField
+	for _, f := range fs {
+		if f.tagged {
+			dominant = append(dominant, f)
+		}
+	}
+
+	if len(dominant) != 1 {
+		return reflectField{}, false
+	}
+
+	return dominant[0], true
+}
@@ -0,0 +1,321 @@
+package value
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// regexpFlags is a compiled regular expression with the jq flags that
+// change how it is matched
+type regexpFlags struct {
+	*regexp.Regexp
+	global  bool // g: find all matches
+	noEmpty bool // n: ignore empty matches
+}
+
+// compileRegexp compiles the pattern with the jq flags:
+//
+//	g: find all matches
+//	i: case insensitive
+//	x: extended, whitespace and comments in the pattern are ignored
+//	n: ignore empty matches
+//	p: both s and n
+//	s: single line mode, which is always the case in Go
+//	l: find the longest matches
+func compileRegexp(pattern, flags string) (*regexpFlags, error) {
+	re := &regexpFlags{}
+	var prefix string
+	var longest bool
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			re.global = true
+		case 'i':
+			prefix = "(?i)"
+		case 'x':
+			pattern = stripExtended(pattern)
+		case 'n', 'p':
+			re.noEmpty = true
+		case 's':
+		case 'l':
+			longest = true
+		default:
+			return nil, errors.New(strconv.Quote(flags) + " is not a valid modifier string")
+		}
+	}
+
+	var err error
+	if re.Regexp, err = regexp.Compile(prefix + pattern); err != nil {
+		return nil, errors.New("invalid regular expression " + strconv.Quote(pattern) + ": " + err.Error())
+	}
+
+	if longest {
+		re.Longest()
+	}
+
+	return re, nil
+}
+
+// stripExtended removes whitespace and comments from a pattern, escaped
+// characters and characters in classes are kept
+func stripExtended(pattern string) string {
+	var sb strings.Builder
+	var class bool
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			sb.WriteByte(c)
+			i++
+			c = pattern[i]
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			continue
+		case c == '#':
+			for i < len(pattern) && pattern[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		sb.WriteByte(c)
+	}
+
+	return sb.String()
+}
+
+// find returns the submatch indexes of the first match, or of all
+// matches when the global flag is set
+func (re *regexpFlags) find(s string) [][]int {
+	n := 1
+	if re.global {
+		n = -1
+	}
+
+	locs := re.FindAllStringSubmatchIndex(s, n)
+	if !re.noEmpty {
+		return locs
+	}
+
+	nonEmpty := locs[:0]
+	for _, loc := range locs {
+		if loc[1] > loc[0] {
+			nonEmpty = append(nonEmpty, loc)
+		}
+	}
+
+	return nonEmpty
+}
+
+// regexpArgs evaluates the regular expression argument, and the flags
+// argument if there is one, and calls fn with the compiled expression for
+// every combination of their outputs. Like jq the pattern may also be an
+// array that holds the pattern and the flags.
+func regexpArgs(ctx Context, name string, in Value, args []Expr, fn func(re *regexpFlags) error) error {
+	return cartesian(ctx.with(Input, in), args, nil, func(argv []Value) error {
+		pattern, err := expand(argv[0])
+		if err != nil {
+			return err
+		}
+
+		var flags Value = Null{}
+		if arr, ok := pattern.(Array); ok && len(argv) == 1 && len(arr) > 0 && len(arr) <= 2 {
+			pattern = arr[0]
+			if len(arr) > 1 {
+				flags = arr[1]
+			}
+		} else if len(argv) > 1 {
+			flags = argv[1]
+		}
+
+		ps, ok := pattern.(String)
+		if !ok {
+			return errors.New(name + " pattern must be a string, got value of type '" + pattern.whichType().String() + "'")
+		}
+
+		var fs String
+		switch ft := flags.(type) {
+		case Null:
+		case String:
+			fs = ft
+		default:
+			return errors.New(name + " flags must be a string, got value of type '" + flags.whichType().String() + "'")
+		}
+
+		re, err := compileRegexp(string(ps), string(fs))
+		if err != nil {
+			return err
+		}
+
+		return fn(re)
+	})
+}
+
+// regexpInput returns the input as a string that can be matched
+func regexpInput(in Value) (string, error) {
+	s, ok := in.(String)
+	if !ok {
+		return "", errors.New("value of type '" + in.whichType().String() + "' cannot be matched, as it is not a string")
+	}
+
+	return string(s), nil
+}
+
+// matchObject describes a match like jq does, with offsets and
+// lengths in codepoints
+func matchObject(re *regexpFlags, s string, loc []int) Map {
+	pos := func(i int) Int { return Int(utf8.RuneCountInString(s[:i])) }
+	m := Map{
+		"offset": pos(loc[0]),
+		"length": pos(loc[1]) - pos(loc[0]),
+		"string": String(s[loc[0]:loc[1]]),
+	}
+
+	captures := Array{}
+	for i, name := range re.SubexpNames()[1:] {
+		c := Map{"name": Null{}, "offset": Int(-1), "length": Int(0), "string": Null{}}
+		if name != "" {
+			c["name"] = String(name)
+		}
+
+		if start, end := loc[2*i+2], loc[2*i+3]; start >= 0 {
+			c["offset"], c["length"], c["string"] = pos(start), pos(end)-pos(start), String(s[start:end])
+		}
+
+		captures = append(captures, c)
+	}
+
+	m["captures"] = captures
+	return m
+}
+
+// captureObject holds the strings of the named groups of a match
+func captureObject(re *regexpFlags, s string, loc []int) Map {
+	m := Map{}
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+
+		if start, end := loc[2*i], loc[2*i+1]; start >= 0 {
+			m[name] = String(s[start:end])
+		} else {
+			m[name] = Null{}
+		}
+	}
+
+	return m
+}
+
+// eachMatch creates the regular expression builtins that emit something
+// for every match, such as match and capture. With 'all' set every match
+// is found regardless of the global flag.
+func eachMatch(name string, all bool, fn func(re *regexpFlags, s string, loc []int, emit Emit) error) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		s, err := regexpInput(in)
+		if err != nil {
+			return err
+		}
+
+		return regexpArgs(ctx, name, in, args, func(re *regexpFlags) error {
+			re.global = re.global || all
+			for _, loc := range re.find(s) {
+				if err := fn(re, s, loc, emit); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+func builtinTest(ctx Context, in Value, args []Expr, emit Emit) error {
+	s, err := regexpInput(in)
+	if err != nil {
+		return err
+	}
+
+	return regexpArgs(ctx, "test", in, args, func(re *regexpFlags) error {
+		re.global = false
+		return emit(Bool(len(re.find(s)) > 0))
+	})
+}
+
+func emitMatch(re *regexpFlags, s string, loc []int, emit Emit) error {
+	return emit(matchObject(re, s, loc))
+}
+
+func emitCapture(re *regexpFlags, s string, loc []int, emit Emit) error {
+	return emit(captureObject(re, s, loc))
+}
+
+// emitScan emits the matched string, or the strings of the groups
+// if the pattern has any
+func emitScan(re *regexpFlags, s string, loc []int, emit Emit) error {
+	if re.NumSubexp() == 0 {
+		return emit(String(s[loc[0]:loc[1]]))
+	}
+
+	groups := make(Array, re.NumSubexp())
+	for i := range groups {
+		if start, end := loc[2*i+2], loc[2*i+3]; start >= 0 {
+			groups[i] = String(s[start:end])
+		} else {
+			groups[i] = Null{}
+		}
+	}
+
+	return emit(groups)
+}
+
+// substitute creates the sub and gsub builtins. The replacement is
+// evaluated with the capture object of every match as its input, a
+// string is emitted for every combination of its outputs.
+func substitute(name string, global bool) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		s, err := regexpInput(in)
+		if err != nil {
+			return err
+		}
+
+		reArgs := []Expr{args[0]}
+		if len(args) > 2 {
+			reArgs = append(reArgs, args[2])
+		}
+
+		return regexpArgs(ctx, name, in, reArgs, func(re *regexpFlags) error {
+			re.global = re.global || global
+			locs := re.find(s)
+
+			var replace func(i, start int, prefix string) error
+			replace = func(i, start int, prefix string) error {
+				if i == len(locs) {
+					return emit(String(prefix + s[start:]))
+				}
+
+				loc := locs[i]
+				return eachArg(ctx, args[1], captureObject(re, s, loc), func(v Value) error {
+					rs, ok := v.(String)
+					if !ok {
+						return errors.New(name + " replacement must be a string, got value of type '" + v.whichType().String() + "'")
+					}
+
+					if err := ctx.Budget.fits(len(prefix) + loc[0] - start + len(rs)); err != nil {
+						return err
+					}
+
+					return replace(i+1, loc[1], prefix+s[start:loc[0]]+string(rs))
+				})
+			}
+
+			return replace(0, 0, "")
+		})
+	}
+}
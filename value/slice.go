@@ -0,0 +1,155 @@
+package value
+
+import (
+	"errors"
+	"math"
+)
+
+// Slice reads a range of elements from an array or a range of
+// characters from a string. A nil From or To expression slices
+// from the start or to the end respectively.
+type Slice struct {
+	Left Expr
+	From Expr
+	To   Expr
+	Pos  int
+}
+
+// Eval will evaluate the slice for every combination of the outputs
+// of the bounds and the left side. The end bound varies the slowest.
+func (s *Slice) Eval(ctx Context, emit Emit) error {
+	return s.bound(ctx, s.To, func(to Value) error {
+		return s.bound(ctx, s.From, func(from Value) error {
+			return s.Left.Eval(ctx, func(v Value) error {
+				if err := ctx.Budget.step(); err != nil {
+					return err
+				}
+
+				res, err := slice(v, from, to)
+				if err != nil {
+					return evalError(s.Pos, s, err)
+				}
+
+				// slices share the elements of the value, except those of
+				// ports of Go arrays which are copied
+				if copiesRange(v) {
+					if err = ctx.Budget.alloc(res.(Port).cargo.Len()); err != nil {
+						return err
+					}
+				}
+
+				return emit(res)
+			})
+		})
+	})
+}
+
+// EvalPath will emit the path of the slice of every path of the left
+// side, the key of a slice holds its bounds
+func (s *Slice) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return s.bound(ctx, s.To, func(to Value) error {
+		return s.bound(ctx, s.From, func(from Value) error {
+			key := sliceKey(from, to)
+			return evalPath(ctx, s.Left, path, func(lp Array, v Value) error {
+				if err := ctx.Budget.step(); err != nil {
+					return err
+				}
+
+				res, err := getKey(v, key)
+				if err != nil {
+					return evalError(s.Pos, s, err)
+				}
+
+				return emit(appendPath(lp, key), res)
+			})
+		})
+	})
+}
+
+// bound evaluates an optional bound expression, a nil expression
+// emits a nil value
+func (s *Slice) bound(ctx Context, e Expr, emit Emit) error {
+	if e == nil {
+		return emit(nil)
+	}
+
+	return e.Eval(ctx, emit)
+}
+
+// slice the value 'v' between the bounds
+func slice(v, from, to Value) (Value, error) {
+	switch vt := v.(type) {
+	case Null:
+		return vt, nil
+	case Array:
+		i, j, err := sliceBounds(len(vt), from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		return vt[i:j:j], nil
+	case String:
+		runes := []rune(string(vt))
+		i, j, err := sliceBounds(len(runes), from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		return String(runes[i:j]), nil
+	case Port:
+		i, j, err := sliceBounds(vt.cargo.Len(), from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		return vt.cargo.Range(i, j)
+	default:
+		return nil, errors.New("cannot slice value of type '" + v.whichType().String() + "'")
+	}
+}
+
+// sliceBounds turns the optional bound values into indexes that are
+// within the length 'n'. Negative bounds count from the end.
+func sliceBounds(n int, from, to Value) (i, j int, err error) {
+	i, j = 0, n
+	if from != nil {
+		if i, err = boundIndex(n, from); err != nil {
+			return
+		}
+	}
+
+	if to != nil {
+		if j, err = boundIndex(n, to); err != nil {
+			return
+		}
+	}
+
+	if j < i {
+		j = i
+	}
+
+	return
+}
+
+// boundIndex clamps a single bound to the length 'n', fractional
+// bounds are floored
+func boundIndex(n int, b Value) (int, error) {
+	if !isNumber(b) {
+		return 0, errors.New("non integer slice bound")
+	}
+
+	f, _ := b.toType(floatType)
+	x := math.Floor(float64(f.(Float)))
+	if x < 0 {
+		x += float64(n)
+	}
+
+	switch {
+	case math.IsNaN(x) || x < 0:
+		return 0, nil
+	case x > float64(n):
+		return n, nil
+	default:
+		return int(x), nil
+	}
+}
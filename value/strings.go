@@ -0,0 +1,209 @@
+package value
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// stringArgs evaluates the arguments with 'in' as input and calls fn for
+// every combination of their outputs, which must all be strings.
+func stringArgs(ctx Context, name string, in Value, args []Expr, fn func(argv []string) error) error {
+	return cartesian(ctx.with(Input, in), args, nil, func(argv []Value) error {
+		ss := make([]string, len(argv))
+		for i, v := range argv {
+			s, ok := v.(String)
+			if !ok {
+				return errors.New(name + " argument must be a string, got value of type '" + v.whichType().String() + "'")
+			}
+
+			ss[i] = string(s)
+		}
+
+		return fn(ss)
+	})
+}
+
+// stringInput returns the input as a string, or an error that
+// names the builtin if it is not a string
+func stringInput(name string, in Value) (string, error) {
+	s, ok := in.(String)
+	if !ok {
+		return "", errors.New(name + " input must be a string, got value of type '" + in.whichType().String() + "'")
+	}
+
+	return string(s), nil
+}
+
+func builtinSplit(ctx Context, in Value, args []Expr, emit Emit) error {
+	s, err := stringInput("split", in)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 2 {
+		return regexpArgs(ctx, "split", in, args, func(re *regexpFlags) error {
+			re.global = true
+			res, start := Array{}, 0
+			for _, loc := range re.find(s) {
+				res = append(res, String(s[start:loc[0]]))
+				start = loc[1]
+			}
+
+			return emit(append(res, String(s[start:])))
+		})
+	}
+
+	return stringArgs(ctx, "split", in, args, func(argv []string) error {
+		if s == "" {
+			return emit(Array{})
+		}
+
+		return emit(stringArray(strings.Split(s, argv[0])))
+	})
+}
+
+func builtinJoin(ctx Context, in Value, args []Expr, emit Emit) error {
+	return stringArgs(ctx, "join", in, args, func(argv []string) error {
+		var sb strings.Builder
+		var i int
+		if err := eachElem(ctx, in, func(ev Value) error {
+			if i > 0 {
+				sb.WriteString(argv[0])
+			}
+			i++
+
+			switch evt := ev.(type) {
+			case Null:
+			case String:
+				sb.WriteString(string(evt))
+			case Bool, Int, Float, Decimal:
+				s, err := stringify(evt)
+				if err != nil {
+					return err
+				}
+
+				sb.WriteString(string(s))
+			default:
+				return errors.New("cannot join value of type '" + ev.whichType().String() + "'")
+			}
+
+			return ctx.Budget.fits(sb.Len())
+		}); err != nil {
+			return err
+		}
+
+		return emit(String(sb.String()))
+	})
+}
+
+// trimStr creates the ltrimstr and rtrimstr builtins, inputs and
+// arguments that are not strings leave the input as-is
+func trimStr(trim func(s, fix string) string) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		return eachArg(ctx, args[0], in, func(v Value) error {
+			s, ok1 := in.(String)
+			fix, ok2 := v.(String)
+			if !ok1 || !ok2 {
+				return emit(in)
+			}
+
+			res := trim(string(s), string(fix))
+			if err := ctx.Budget.fits(len(res)); err != nil {
+				return err
+			}
+
+			return emit(String(res))
+		})
+	}
+}
+
+// hasFix creates the startswith and endswith builtins
+func hasFix(name string, has func(s, fix string) bool) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		s, err := stringInput(name, in)
+		if err != nil {
+			return err
+		}
+
+		return stringArgs(ctx, name, in, args, func(argv []string) error {
+			return emit(Bool(has(s, argv[0])))
+		})
+	}
+}
+
+// asciiCase creates the ascii_downcase and ascii_upcase builtins, which
+// only change the case of ascii letters
+func asciiCase(name string, from, to byte) BuiltinFunc {
+	return func(ctx Context, in Value, args []Expr, emit Emit) error {
+		s, err := stringInput(name, in)
+		if err != nil {
+			return err
+		}
+
+		if err = ctx.Budget.fits(len(s)); err != nil {
+			return err
+		}
+
+		b := []byte(s)
+		for i, c := range b {
+			if c >= from && c < from+26 {
+				b[i] = c - from + to
+			}
+		}
+
+		return emit(String(b))
+	}
+}
+
+func builtinExplode(ctx Context, in Value, args []Expr, emit Emit) error {
+	s, err := stringInput("explode", in)
+	if err != nil {
+		return err
+	}
+
+	n := utf8.RuneCountInString(s)
+	if err = ctx.Budget.fits(n); err != nil {
+		return err
+	}
+
+	res := make(Array, 0, n)
+	for _, r := range s {
+		res = append(res, Int(r))
+	}
+
+	return emit(res)
+}
+
+func builtinImplode(ctx Context, in Value, args []Expr, emit Emit) error {
+	in, err := expand(in)
+	if err != nil {
+		return err
+	}
+
+	arr, ok := in.(Array)
+	if !ok {
+		return errors.New("implode input must be an array, got value of type '" + in.whichType().String() + "'")
+	}
+
+	if err = ctx.Budget.fits(len(arr)); err != nil {
+		return err
+	}
+
+	runes := make([]rune, len(arr))
+	for i, ev := range arr {
+		if !isNumber(ev) {
+			return errors.New("cannot implode value of type '" + ev.whichType().String() + "'")
+		}
+
+		f, _ := ev.toType(floatType)
+		cp := float64(f.(Float))
+		if cp < 0 || cp > utf8.MaxRune {
+			return errors.New("cannot implode invalid codepoint: " + ev.String())
+		}
+
+		runes[i] = rune(cp)
+	}
+
+	return emit(String(runes))
+}
@@ -0,0 +1,90 @@
+package value
+
+import (
+	"errors"
+	"strings"
+)
+
+// Try evaluates the body and stops at the first error it raises, which is
+// then passed to the Catch expression as its input. Without a Catch the
+// error is suppressed, as with the postfix '?'. Errors that are raised
+// after the body output a value, further down the pipe, are not caught.
+type Try struct {
+	Body  Expr
+	Catch Expr
+	Pos   int
+}
+
+// Eval will evaluate the body and the catch expression when it fails
+func (t *Try) Eval(ctx Context, emit Emit) error {
+	caught, err := catch(func(mark func(err error) error) error {
+		return t.Body.Eval(ctx, func(v Value) error {
+			return mark(emit(v))
+		})
+	})
+
+	if err != nil || caught == nil || t.Catch == nil {
+		return err
+	}
+
+	return t.Catch.Eval(ctx.with(Input, errorValue(caught)), emit)
+}
+
+// EvalPath will evaluate the body as path expression, the catch
+// expression is not a path expression
+func (t *Try) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	caught, err := catch(func(mark func(err error) error) error {
+		return evalPath(ctx, t.Body, path, func(p Array, v Value) error {
+			return mark(emit(p, v))
+		})
+	})
+
+	if err != nil || caught == nil || t.Catch == nil {
+		return err
+	}
+
+	if err = evalNoPath(ctx.with(Input, errorValue(caught)), t.Catch); err != nil {
+		return evalError(t.Pos, t, err)
+	}
+
+	return nil
+}
+
+// catch calls eval with a function that marks the errors returned by
+// emit, such that they can be told apart from the errors raised by the
+// expression itself. The latter are returned as 'caught', the errors of
+// emit are returned as 'err', as are errors that abort the evaluation.
+func catch(eval func(mark func(err error) error) error) (caught, err error) {
+	err = eval(func(err error) error {
+		if err != nil {
+			return &emitError{err}
+		}
+
+		return nil
+	})
+
+	if ee, ok := err.(*emitError); ok {
+		return nil, ee.err
+	} else if isAbort(err) {
+		return nil, err
+	}
+
+	return err, nil
+}
+
+// errorValue returns the value that a caught error is passed on as: the
+// value given to the error builtin, or else the message of the error
+// without its position.
+func errorValue(err error) Value {
+	var ve *ValueError
+	if errors.As(err, &ve) {
+		return ve.Value
+	}
+
+	var ee *EvalError
+	if errors.As(err, &ee) {
+		err = ee.Err
+	}
+
+	return String(strings.TrimPrefix(err.Error(), "jqp/value: "))
+}
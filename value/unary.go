@@ -1,6 +1,10 @@
 package value
 
 import (
+	"errors"
+	"math"
+	"math/big"
+
 	"github.com/advanderveer/jqp/token"
 )
 
@@ -8,8 +12,58 @@ import (
 type Unary struct {
 	Op    token.TokenType
 	Right Expr
+	Pos   int
+}
+
+// Eval will evaluate the unary operation for every output of the right side
+func (u *Unary) Eval(ctx Context, emit Emit) error {
+	impls, ok := unaryOps[u.Op]
+	if !ok {
+		return evalError(u.Pos, u, errors.New("unary op not implemented: "+u.Op.String()))
+	}
+
+	return u.Right.Eval(ctx, func(v Value) error {
+		impl := impls[v.whichType()]
+		if impl == nil {
+			return evalError(u.Pos, u, errors.New("no implementation for op: "+u.Op.String()+" and the type: "+v.whichType().String()))
+		}
+
+		res, err := impl(v)
+		if err != nil {
+			return evalError(u.Pos, u, err)
+		}
+
+		return emit(res)
+	})
 }
 
-func (u *Unary) Eval(ctx Context) Value {
-	return nil
+// unaryOps holds all implementations for the unary operations
+var unaryOps = map[token.TokenType][_numTypes]func(v Value) (Value, error){
+
+	// negation
+	token.Sub: {
+		intType: func(v Value) (Value, error) {
+			if v.(Int) == math.MinInt64 {
+				return -Float(v.(Int)), nil // doesn't fit an int64
+			}
+
+			return -v.(Int), nil
+		},
+		floatType: func(v Value) (Value, error) { return -v.(Float), nil },
+		decimalType: func(v Value) (Value, error) {
+			return Decimal{rat: new(big.Rat).Neg(v.(Decimal).r())}, nil
+		},
+	},
+
+	// logical not
+	token.Not: forAllTypes(func(v Value) (Value, error) { return Bool(!truthy(v)), nil }),
+}
+
+// forAllTypes uses the same implementation for all value types
+func forAllTypes(impl func(v Value) (Value, error)) (impls [_numTypes]func(v Value) (Value, error)) {
+	for i := range impls {
+		impls[i] = impl
+	}
+
+	return
 }
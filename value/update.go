@@ -0,0 +1,110 @@
+package value
+
+import (
+	"github.com/advanderveer/jqp/token"
+)
+
+// evalAssign implements 'lhs = rhs': for every output of the right side,
+// evaluated against the input, a copy of the input is emitted in which
+// all paths of the left side are set to that output.
+func (b *Binary) evalAssign(ctx Context, emit Emit) error {
+	in := ctx.input()
+	return b.Right.Eval(ctx, func(rhs Value) error {
+		res, err := b.update(ctx, in, func(Value) (Value, bool, error) {
+			return rhs, true, nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return emit(res)
+	})
+}
+
+// evalUpdate implements 'lhs |= f': a copy of the input is emitted in
+// which the value at every path of the left side is replaced by the first
+// output of the right side for that value. Paths for which the right side
+// outputs nothing are deleted.
+func (b *Binary) evalUpdate(ctx Context, emit Emit) error {
+	res, err := b.update(ctx, ctx.input(), func(old Value) (Value, bool, error) {
+		return firstOutput(ctx, b.Right, old)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return emit(res)
+}
+
+// arithUpdateOps maps the arithmetic update operators to their operator
+var arithUpdateOps = map[token.TokenType]token.TokenType{
+	token.AddAssign: token.Add,
+	token.SubAssign: token.Sub,
+	token.MulAssign: token.Mul,
+	token.QuoAssign: token.Quo,
+	token.RemAssign: token.Rem,
+	token.AltAssign: token.Alt,
+}
+
+// evalArithUpdate implements 'lhs op= rhs': for every output of the right
+// side, evaluated against the input, a copy of the input is emitted in
+// which the value at every path of the left side is updated to 'value op
+// output'. For '//=' values that are null or false are replaced.
+func (b *Binary) evalArithUpdate(ctx Context, emit Emit) error {
+	in, op := ctx.input(), arithUpdateOps[b.Op]
+	return b.Right.Eval(ctx, func(rhs Value) error {
+		res, err := b.update(ctx, in, func(old Value) (Value, bool, error) {
+			if op == token.Alt {
+				if truthy(old) {
+					return old, true, nil
+				}
+
+				return rhs, true, nil
+			}
+
+			nv, err := arith(ctx, op, old, rhs)
+			return nv, true, err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return emit(res)
+	})
+}
+
+// update replaces the value at every path of the left side with the
+// outcome of fn for the current value at that path, the paths for which
+// fn returns false are deleted afterwards. The input is not modified.
+func (b *Binary) update(ctx Context, in Value, fn func(old Value) (Value, bool, error)) (Value, error) {
+	res, del := in, Array{}
+	if err := evalPath(ctx.with(Input, in), b.Left, Array{}, func(path Array, _ Value) error {
+		old, err := getPath(res, path)
+		if err != nil {
+			return err
+		}
+
+		nv, ok, err := fn(old)
+		if err != nil {
+			return err
+		} else if !ok {
+			del = append(del, path)
+			return nil
+		}
+
+		res, err = setPath(ctx, res, path, nv)
+		return err
+	}); err != nil {
+		return nil, evalError(b.Pos, b, err)
+	}
+
+	res, err := delPaths(ctx, res, del)
+	if err != nil {
+		return nil, evalError(b.Pos, b, err)
+	}
+
+	return res, nil
+}
@@ -1,101 +1,220 @@
 package value
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
 )
 
 type valueType int
 
 // ToNative converts a value from the jqp type system
-// to the native go type system.
-func ToNative(v Value) interface{} {
+// to the native go type system. Numbers are converted
+// as described by IntNumbers.
+func ToNative(v Value) (interface{}, error) {
+	return ToNativeAs(v, IntNumbers)
+}
+
+// ToNativeAs is like ToNative but converts numbers to
+// the native type selected by 'nt'.
+func ToNativeAs(v Value, nt NumberType) (interface{}, error) {
 	switch vt := v.(type) {
-	case Int:
-		return int(vt)
+	case Null:
+		return nil, nil
+	case Bool:
+		return bool(vt), nil
+	case Int, Float, Decimal:
+		return nt.toNative(vt), nil
 	case String:
-		return string(vt)
-	case Float:
-		return float64(vt)
+		return string(vt), nil
 	case Array:
 		res := make([]interface{}, len(vt))
 		for i := range vt {
-			res[i] = ToNative(vt[i])
+			nv, err := ToNativeAs(vt[i], nt)
+			if err != nil {
+				return nil, err
+			}
+
+			res[i] = nv
 		}
-		return res
+		return res, nil
 	case Map:
 		res := make(map[string]interface{}, len(vt))
 		for k := range vt {
-			res[k] = ToNative(vt[k])
+			nv, err := ToNativeAs(vt[k], nt)
+			if err != nil {
+				return nil, err
+			}
+
+			res[k] = nv
 		}
-		return res
+		return res, nil
+	case Port:
+		if nc, ok := vt.cargo.(nativeCargo); ok {
+			return nc.native(), nil
+		}
+
+		return nil, errors.New("jqp/value: cant convert value type '" + v.whichType().String() + "' to a native type")
 	case Func:
-		return func(args ...interface{}) interface{} {
+		return func(args ...interface{}) (interface{}, error) {
 			res := make([]Value, len(args))
 			for i := range args {
-				res[i] = FromNative(args[i], false)
+				v, err := FromNative(args[i], false)
+				if err != nil {
+					return nil, err
+				}
+
+				res[i] = v
 			}
 
-			return ToNative(vt(res...))
-		}
+			out, err := vt(res...)
+			if err != nil {
+				return nil, err
+			}
+
+			return ToNativeAs(out, nt)
+		}, nil
 	default:
-		panic("jqp/value: cant convert value type '" + v.whichType().String() + "' to a native type")
+		return nil, errors.New("jqp/value: cant convert value type '" + v.whichType().String() + "' to a native type")
 	}
 }
 
 // FromNative transforms any native supported go types to
 // a value that can be evaluated in jqp. If 'port' is
 // set to true, map and slice will become ports values.
-// Else they will be converted to map and arrays.
-func FromNative(v interface{}, port bool) Value {
+// Else they will be converted to map and arrays. Numbers
+// of any kind are accepted, integers that don't fit an Int
+// become a Float. Other structs, maps, slices and arrays
+// always become ports that read their fields and elements
+// lazily through reflection.
+func FromNative(v interface{}, port bool) (Value, error) {
+	return fromNative(v, port, false)
+}
+
+// FromNativeAs is like FromNative but with BigNumbers numbers that
+// don't fit an Int or Float without losing precision become a Decimal,
+// also when they are read through a port later on. Other number types
+// convert numbers like FromNative.
+func FromNativeAs(v interface{}, port bool, nt NumberType) (Value, error) {
+	return fromNative(v, port, nt == BigNumbers)
+}
+
+func fromNative(v interface{}, port, bigNumbers bool) (Value, error) {
 	switch vt := v.(type) {
 	case Value:
-		return vt
+		return vt, nil
+	case nil:
+		return Null{}, nil
+	case bool:
+		return Bool(vt), nil
 	case int:
-		return Int(vt)
+		return Int(vt), nil
+	case int64:
+		return Int(vt), nil
 	case float64:
-		return Float(vt)
+		return Float(vt), nil
+	case float32:
+		return fromFloat32(vt), nil
+	case json.Number:
+		return fromJSONNumber(vt, bigNumbers)
+	case *big.Int:
+		if vt == nil {
+			return Null{}, nil
+		}
+
+		return fromRat(new(big.Rat).SetInt(vt), bigNumbers), nil
+	case *big.Float:
+		if vt == nil {
+			return Null{}, nil
+		}
+
+		if !bigNumbers {
+			f64, _ := vt.Float64()
+			return Float(f64), nil
+		}
+
+		if vt.IsInf() {
+			return nil, errors.New("jqp/value: cant convert infinite number from native")
+		}
+
+		r, _ := vt.Rat(nil)
+		return Decimal{rat: r}.shrink(), nil
+	case *big.Rat:
+		if vt == nil {
+			return Null{}, nil
+		}
+
+		return fromRat(new(big.Rat).Set(vt), bigNumbers), nil
 	case string:
-		return String(vt)
+		return String(vt), nil
 	case func(...interface{}) interface{}:
-		return Func(func(args ...Value) Value {
+		return fromNative(func(args ...interface{}) (interface{}, error) {
+			return vt(args...), nil
+		}, port, bigNumbers)
+	case func(...interface{}) (interface{}, error):
+		return Func(func(args ...Value) (Value, error) {
 			res := make([]interface{}, len(args))
 			for i := range args {
-				res[i] = ToNative(args[i])
+				nv, err := ToNative(args[i])
+				if err != nil {
+					return nil, err
+				}
+
+				res[i] = nv
 			}
 
-			return FromNative(vt(res...), port)
-		})
+			out, err := vt(res...)
+			if err != nil {
+				return nil, err
+			}
+
+			return fromNative(out, port, bigNumbers)
+		}), nil
 
 	case []interface{}:
 		if port {
-			return Port{slicePortCargo(vt)}
+			return Port{slicePortCargo{vt, bigNumbers}}, nil
 		}
 
 		v := make(Array, len(vt))
 		for i := range v {
-			v[i] = FromNative(vt[i], port)
+			ev, err := fromNative(vt[i], port, bigNumbers)
+			if err != nil {
+				return nil, err
+			}
+
+			v[i] = ev
 		}
 
-		return v
+		return v, nil
 	case map[string]interface{}:
 		if port {
-			return Port{mapPortCargo(vt)}
+			return Port{mapPortCargo{vt, bigNumbers}}, nil
 		}
 
 		v := make(Map, len(vt))
 		for k := range vt {
-			v[k] = FromNative(vt[k], port)
+			ev, err := fromNative(vt[k], port, bigNumbers)
+			if err != nil {
+				return nil, err
+			}
+
+			v[k] = ev
 		}
 
-		return v
+		return v, nil
 	default:
-		panic("jqp/value: cant convert this type from native:" + fmt.Sprintf("%T", vt))
+		return fromReflect(reflect.ValueOf(vt), port, bigNumbers)
 	}
 }
 
 const (
-	intType valueType = iota
+	nullType valueType = iota
+	boolType
+	intType
 	floatType
+	decimalType
 	stringType
 	arrayType
 	mapType
@@ -106,16 +225,106 @@ const (
 )
 
 func (vt valueType) String() string {
-	var typeName = [_numTypes]string{"int", "float", "string", "array", "map", "port", "func"}
+	var typeName = [_numTypes]string{"null", "bool", "int", "float", "decimal", "string", "array", "map", "port", "func"}
 	return typeName[vt]
 }
 
+// Input is the variable that holds the input of a filter
+const Input = Var("$")
+
+// Context holds the state that an expression is evaluated in
 type Context struct {
+
+	// Decl holds the variables that are declared before the evaluation
+	// starts, such as the input and external variables
 	Decl map[Var]Value
+
+	// BigNumbers enables the big number mode in which arithmetic
+	// is done on decimals, see Decimal
+	BigNumbers bool
+
+	// Modules holds the modules that were loaded when the query was
+	// compiled by their path, the modulemeta builtin describes them
+	Modules map[string]*Module
+
+	// Funcs holds the functions that are registered from Go by their
+	// name and arity, as in 'slugify/1'. They shadow the builtins.
+	Funcs map[string]BuiltinFunc
+
+	// Budget limits the resources of the evaluation and stops it when
+	// its context is done, the evaluation is not limited if it is nil
+	Budget *Budget
+
+	// depth is the nesting of the calls of functions that are defined
+	// in the query
+	depth int
+
+	// scope holds the variables and functions that are declared during
+	// the evaluation, it is searched before Decl
+	scope *scope
+}
+
+// scope is a link in the chain of lexical scopes, it declares either a
+// variable or a function and refers to the scope it is nested in.
+type scope struct {
+	parent *scope
+	name   Var
+	value  Value
+	fn     *closure
+}
+
+// with returns a copy of the context in which 'name' is declared
+// as 'v', the original context is left untouched.
+func (ctx Context) with(name Var, v Value) Context {
+	ctx.scope = &scope{parent: ctx.scope, name: name, value: v}
+	return ctx
 }
 
+// lookup returns the value of the variable from the innermost scope
+// that declares it
+func (ctx Context) lookup(name Var) (Value, bool) {
+	for s := ctx.scope; s != nil; s = s.parent {
+		if s.fn == nil && s.name == name {
+			return s.value, true
+		}
+	}
+
+	v, ok := ctx.Decl[name]
+	return v, ok
+}
+
+// input returns the value of the Input variable, or null if it
+// isn't declared
+func (ctx Context) input() Value {
+	in, ok := ctx.lookup(Input)
+	if !ok {
+		return Null{}
+	}
+
+	return in
+}
+
+// Emit is called by an expression for each value it outputs. A non-nil
+// error stops the evaluation and is returned by the expression as-is.
+type Emit func(v Value) error
+
+// Expr is evaluated into a stream of zero, one or many values that
+// are passed to emit one by one.
 type Expr interface {
-	Eval(ctx Context) Value
+	Eval(ctx Context, emit Emit) error
+}
+
+// Collect evaluates the expression and returns all of its outputs
+func Collect(ctx Context, e Expr) ([]Value, error) {
+	var vals []Value
+	if err := e.Eval(ctx, func(v Value) error {
+		vals = append(vals, v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return vals, nil
 }
 
 type Value interface {
@@ -123,7 +332,7 @@ type Value interface {
 	String() string
 
 	whichType() valueType
-	toType(valueType) Value
+	toType(valueType) (Value, error)
 }
 
 func binaryArithType(t1, t2 valueType) valueType {
@@ -1,86 +1,269 @@
 package value_test
 
 import (
-	"github.com/advanderveer/jqp/value"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/advanderveer/jqp/token"
+	"github.com/advanderveer/jqp/value"
 )
 
+func toNative(t *testing.T, v value.Value) interface{} {
+	nv, err := value.ToNative(v)
+	if err != nil {
+		t.Fatalf("failed to convert to native: %v", err)
+	}
+
+	return nv
+}
+
+func fromNative(t *testing.T, v interface{}, port bool) value.Value {
+	vv, err := value.FromNative(v, port)
+	if err != nil {
+		t.Fatalf("failed to convert from native: %v", err)
+	}
+
+	return vv
+}
+
 func TestToNative(t *testing.T) {
-	v := value.ToNative(value.String("abc"))
+	v := toNative(t, value.String("abc"))
 	if !reflect.DeepEqual(v, "abc") {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
 
-	v = value.ToNative(value.Float(1.5))
+	v = toNative(t, value.Float(1.5))
 	if !reflect.DeepEqual(v, 1.5) {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
 
-	v = value.ToNative(value.Int(2))
+	v = toNative(t, value.Bool(true))
+	if !reflect.DeepEqual(v, true) {
+		t.Fatalf("unexpected to value result, got: %#v", v)
+	}
+
+	v = toNative(t, value.Null{})
+	if v != nil {
+		t.Fatalf("unexpected to value result, got: %#v", v)
+	}
+
+	v = toNative(t, value.Int(2))
 	if !reflect.DeepEqual(v, 2) {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
 
-	v = value.ToNative(value.Array{value.Int(3), value.String("abc")})
+	v = toNative(t, value.Array{value.Int(3), value.String("abc")})
 	if !reflect.DeepEqual(v, []interface{}{3, "abc"}) {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
 
-	v = value.ToNative(value.Map{"a": value.Int(3), "b": value.String("abc")})
+	v = toNative(t, value.Map{"a": value.Int(3), "b": value.String("abc")})
 	if !reflect.DeepEqual(v, map[string]interface{}{"a": 3, "b": "abc"}) {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
 
-	v = value.ToNative(value.Func(func(args ...value.Value) value.Value {
-		return value.String(strings.ToUpper(string(args[0].(value.String))))
+	v = toNative(t, value.Func(func(args ...value.Value) (value.Value, error) {
+		return value.String(strings.ToUpper(string(args[0].(value.String)))), nil
 	}))
-	if !reflect.DeepEqual(v.(func(...interface{}) interface{})("foo"), "FOO") {
+	if res, err := v.(func(...interface{}) (interface{}, error))("foo"); err != nil || !reflect.DeepEqual(res, "FOO") {
 		t.Fatalf("unexpected to value result, got: %#v", v)
 	}
+
+	v = toNative(t, fromNative(t, map[string]interface{}{"a": 1}, true))
+	if !reflect.DeepEqual(v, map[string]interface{}{"a": 1}) {
+		t.Fatalf("expected port to convert to the value it reads from, got: %#v", v)
+	}
 }
 
 func TestFromNativeWithoutPorting(t *testing.T) {
-	v := value.FromNative("abc", false)
+	v := fromNative(t, "abc", false)
 	if v.String() != `abc` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative(10, false)
+	v = fromNative(t, false, false)
+	if v != value.Bool(false) {
+		t.Fatal("unexpected to value result, got: " + v.String())
+	}
+
+	v = fromNative(t, nil, false)
+	if v != (value.Null{}) {
+		t.Fatal("unexpected to value result, got: " + v.String())
+	}
+
+	v = fromNative(t, 10, false)
 	if v.String() != `10` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative(1.0, false)
+	v = fromNative(t, 1.0, false)
 	if v.String() != `1E+00` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative([]interface{}{1, "abc"}, false)
+	v = fromNative(t, []interface{}{1, "abc"}, false)
 	if v.String() != `[1, abc]` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative(map[string]interface{}{"foo": 1, "bar": "abc"}, false)
+	v = fromNative(t, map[string]interface{}{"foo": 1, "bar": "abc"}, false)
 	if v.String() != `{bar:abc, foo:1}` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative(value.String("foo"), false)
+	v = fromNative(t, value.String("foo"), false)
 	if v.String() != `foo` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
 
-	v = value.FromNative(func(args ...interface{}) interface{} { return 100 }, false)
+	v = fromNative(t, func(args ...interface{}) interface{} { return 100 }, false)
 	if v.String() != `func()` {
 		t.Fatal("unexpected to value result, got: " + v.String())
 	}
+
+	_, err := value.FromNative(make(chan int), false)
+	if err == nil {
+		t.Fatal("expected error for unsupported native type")
+	}
 }
 
 func TestFromNativeWithPorting(t *testing.T) {
-	v := value.FromNative(map[string]interface{}{"foo": 1, "bar": "abc"}, true)
+	v := fromNative(t, map[string]interface{}{"foo": 1, "bar": "abc"}, true)
 	if v.String() != `{ port }` {
 		t.Fatal("expected port value")
 	}
 }
+
+func TestFromNativeNumbers(t *testing.T) {
+	type myUint uint16
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for i, c := range []struct {
+		v   interface{}
+		exp value.Value
+	}{
+		{int8(-8), value.Int(-8)},
+		{int64(math.MaxInt64), value.Int(math.MaxInt64)},
+		{uint32(32), value.Int(32)},
+		{myUint(16), value.Int(16)},
+		{uint64(math.MaxUint64), value.Float(math.MaxUint64)},
+		{float32(0.1), value.Float(0.1)},
+		{json.Number("9007199254740993"), value.Int(9007199254740993)},
+		{json.Number("1.5e3"), value.Float(1500)},
+		{big.NewInt(-42), value.Int(-42)},
+		{huge, value.Float(1.2345678901234568e29)},
+		{big.NewFloat(2.5), value.Float(2.5)},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			v := fromNative(t, c.v, false)
+			if v != c.exp {
+				t.Fatalf("expected %#v to convert to %#v, got: %#v", c.v, c.exp, v)
+			}
+		})
+	}
+
+	_, err := value.FromNative(json.Number("1.2.3"), false)
+	if err == nil || err.Error() != `jqp/value: invalid json number: "1.2.3"` {
+		t.Fatalf("expected invalid json number error, got: %v", err)
+	}
+}
+
+func TestFromNativeAsDecimals(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for i, c := range []struct {
+		v   interface{}
+		exp string
+	}{
+		{int64(math.MaxInt64), "value.Int(9223372036854775807)"},
+		{uint64(math.MaxUint64), "value.Decimal(18446744073709551615)"},
+		{float32(0.1), "value.Float(1E-01)"},
+		{json.Number("9007199254740993"), "value.Int(9007199254740993)"},
+		{json.Number("1.50e3"), "value.Decimal(1.50e3)"},
+		{big.NewInt(-42), "value.Int(-42)"},
+		{huge, "value.Decimal(123456789012345678901234567890)"},
+		{big.NewFloat(2.5), "value.Decimal(2.5)"},
+		{big.NewRat(1, 3), "value.Decimal(0.3333333333333333333333333333333333)"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			v, err := value.FromNativeAs(c.v, false, value.BigNumbers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if act := fmt.Sprintf("%T(%s)", v, v); act != c.exp {
+				t.Fatalf("expected %#v to convert to %s, got: %s", c.v, c.exp, act)
+			}
+		})
+	}
+}
+
+func TestZeroDecimal(t *testing.T) {
+	var zero value.Decimal
+	if zero.String() != "0" || zero.Rat().Sign() != 0 {
+		t.Fatalf("expected the zero decimal to be 0, got: %s", zero)
+	}
+
+	res, err := value.Collect(value.Context{BigNumbers: true}, &value.Binary{Op: token.Sub, Left: zero, Right: value.Int(1)})
+	if err != nil || !reflect.DeepEqual(res, []value.Value{value.Int(-1)}) {
+		t.Fatalf("expected arithmetic on the zero decimal to work, got: %v (%v)", res, err)
+	}
+
+	if v, err := value.ToNativeAs(zero, value.BigNumbers); err != nil || v != json.Number("0") {
+		t.Fatalf("expected the zero decimal to convert to 0, got: %#v (%v)", v, err)
+	}
+}
+
+func TestToNativeAs(t *testing.T) {
+	arr := value.Array{value.Int(1), value.Float(1.5)}
+	for i, c := range []struct {
+		nt  value.NumberType
+		exp interface{}
+	}{
+		{value.IntNumbers, []interface{}{1, 1.5}},
+		{value.Int64Numbers, []interface{}{int64(1), 1.5}},
+		{value.Float64Numbers, []interface{}{1.0, 1.5}},
+		{value.JSONNumbers, []interface{}{json.Number("1"), json.Number("1.5")}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			v, err := value.ToNativeAs(arr, c.nt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v, c.exp) {
+				t.Fatalf("expected %#v, got: %#v", c.exp, v)
+			}
+		})
+	}
+}
+
+func TestToNativeAsDecimals(t *testing.T) {
+	dec, _ := value.ParseDecimal("1.50")
+	arr := value.Array{value.Int(1), value.Float(1.5), dec}
+	for i, c := range []struct {
+		nt  value.NumberType
+		exp interface{}
+	}{
+		{value.IntNumbers, []interface{}{1, 1.5, 1.5}},
+		{value.Int64Numbers, []interface{}{int64(1), 1.5, 1.5}},
+		{value.Float64Numbers, []interface{}{1.0, 1.5, 1.5}},
+		{value.JSONNumbers, []interface{}{json.Number("1"), json.Number("1.5"), json.Number("1.50")}},
+		{value.BigNumbers, []interface{}{1, 1.5, json.Number("1.50")}},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			v, err := value.ToNativeAs(arr, c.nt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v, c.exp) {
+				t.Fatalf("expected %#v, got: %#v", c.exp, v)
+			}
+		})
+	}
+}
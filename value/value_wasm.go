@@ -3,42 +3,47 @@
 package value
 
 import (
+	"errors"
+	"sort"
 	"syscall/js"
 )
 
 // FromJS turns a JavaScript value into a value of
 // our own type system. It wil always create port
 // values for javascript objects
-func FromJS(jsv js.Value) Value {
+func FromJS(jsv js.Value) (Value, error) {
 	switch jsv.Type() {
 	case js.TypeNull, js.TypeUndefined:
-		// @TODO implement no value
-		panic("js type was null/undefined")
+		return Null{}, nil
 	case js.TypeSymbol:
 		//https: //developer.mozilla.org/en-US/docs/Glossary/Symbol
-		panic("js symbols are not supported")
+		return nil, errors.New("jqp/value: js symbols are not supported")
 	case js.TypeBoolean:
-		// @TODO implement boolean type
-		panic("what to do with boolean values?")
+		return Bool(jsv.Bool()), nil
 	case js.TypeFunction:
-		return Func(func(args ...Value) Value {
+		return Func(func(args ...Value) (Value, error) {
 			res := make([]interface{}, len(args))
 			for i := range args {
-				res[i] = ToNative(args[i])
+				nv, err := ToNative(args[i])
+				if err != nil {
+					return nil, err
+				}
+
+				res[i] = nv
 			}
 
 			jsres := jsv.Invoke(res...)
 			return FromJS(jsres)
-		})
+		}), nil
 
 	case js.TypeObject:
-		return Port{jsPortCargo{jsv}}
+		return Port{jsPortCargo{jsv}}, nil
 	case js.TypeString:
-		return String(jsv.String())
+		return String(jsv.String()), nil
 	case js.TypeNumber:
-		return Float(jsv.Float()).shrink() //possible shrink to int
+		return Float(jsv.Float()).shrink(), nil //possible shrink to int
 	default:
-		panic("unexpected js type, cannot convert:" + jsv.Type().String())
+		return nil, errors.New("jqp/value: unexpected js type, cannot convert: " + jsv.Type().String())
 	}
 }
 
@@ -49,5 +54,126 @@ type jsPortCargo struct{ js.Value }
 
 var _ PortCargo = jsPortCargo{}
 
-func (p jsPortCargo) Get(k string) Value   { return FromJS(p.Value.Get(k)) }
-func (p jsPortCargo) Range(i, j int) Value { return FromJS(p.Value.Index(i)) }
+func (p jsPortCargo) Get(k string) (Value, error) { return FromJS(p.Value.Get(k)) }
+func (p jsPortCargo) Index(i int) (Value, error) {
+	if !p.IsArray() {
+		return nil, errors.New("index on non-array js object is not supported")
+	}
+
+	return FromJS(p.Value.Index(i))
+}
+
+// Range slices the js array, which creates a shallow copy of the elements
+func (p jsPortCargo) Range(i, j int) (Value, error) {
+	if !p.IsArray() {
+		return nil, errors.New("range on non-array js object is not supported")
+	}
+
+	return FromJS(p.Value.Call("slice", i, j))
+}
+
+// Len returns the length of js arrays or the number of keys of objects
+func (p jsPortCargo) Len() int {
+	if p.IsArray() {
+		return p.Value.Length()
+	}
+
+	return p.keys().Length()
+}
+
+// Iterate emits the elements of js arrays or the values of objects in
+// the sorted order of their keys, like the other ports
+func (p jsPortCargo) Iterate(emit Emit) error {
+	if p.IsArray() {
+		for i := 0; i < p.Value.Length(); i++ {
+			v, err := FromJS(p.Value.Index(i))
+			if err != nil {
+				return err
+			}
+
+			if err = emit(v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, k := range p.Keys() {
+		v, err := FromJS(p.Value.Get(k))
+		if err != nil {
+			return err
+		}
+
+		if err = emit(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p jsPortCargo) native() interface{} { return p.Value }
+
+// Set writes the property of the js object
+func (p jsPortCargo) Set(k string, v Value) error {
+	return setJS(v, func(jsv interface{}) { p.Value.Set(k, jsv) })
+}
+
+// SetIndex writes the element of the js array
+func (p jsPortCargo) SetIndex(i int, v Value) error {
+	if !p.IsArray() {
+		return errors.New("set index on non-array js object is not supported")
+	}
+
+	return setJS(v, func(jsv interface{}) { p.Value.SetIndex(i, jsv) })
+}
+
+// Delete removes the property from the js object
+func (p jsPortCargo) Delete(k string) error {
+	p.Value.Delete(k)
+	return nil
+}
+
+// setJS converts the value to a native value and passes it to set, values
+// that cannot be converted into a js value cause an error instead of a panic
+func setJS(v Value, set func(jsv interface{})) (err error) {
+	nv, err := ToNative(v)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("cannot convert value of type '" + v.whichType().String() + "' to a js value")
+		}
+	}()
+
+	set(nv)
+	return nil
+}
+
+// IsArray reports whether the js value is an array
+func (p jsPortCargo) IsArray() bool {
+	return js.Global().Get("Array").Call("isArray", p.Value).Bool()
+}
+
+// Keys returns the sorted keys of js objects
+func (p jsPortCargo) Keys() []string {
+	if p.IsArray() {
+		return nil
+	}
+
+	jskeys := p.keys()
+	keys := make([]string, jskeys.Length())
+	for i := range keys {
+		keys[i] = jskeys.Index(i).String()
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func (p jsPortCargo) keys() js.Value {
+	return js.Global().Get("Object").Call("keys", p.Value)
+}
@@ -3,6 +3,7 @@
 package value_test
 
 import (
+	"reflect"
 	"syscall/js"
 	"testing"
 
@@ -10,28 +11,113 @@ import (
 	"github.com/advanderveer/jqp/value"
 )
 
+func fromJS(t *testing.T, jsv js.Value) value.Value {
+	v, err := value.FromJS(jsv)
+	if err != nil {
+		t.Fatalf("failed to convert from js: %v", err)
+	}
+
+	return v
+}
+
 func TestJavaScriptQuery(t *testing.T) {
-	v := jqp.Query(`$.window.location.href`, value.FromJS(js.Global()))
-	if v.String() == "" {
-		t.Fatal("expected to js query to return something")
+	v, err := jqp.Query(`$.Math.PI`, fromJS(t, js.Global()))
+	if err != nil || v == nil {
+		t.Fatalf("expected to js query to return something, got: %v", err)
 	}
 
 	// should correctly convert js value to an int (shrinking from float)
 	// and execute the addition
-	v = jqp.Query(`$+11`, value.FromJS(js.ValueOf(10)))
-	if v.String() != "21" {
-		t.Fatalf("unexpected query result, got: %s", v)
+	v, err = jqp.Query(`$+11`, fromJS(t, js.ValueOf(10)))
+	if err != nil || v != 21 {
+		t.Fatalf("unexpected query result, got: %v (%v)", v, err)
 	}
 
-	v = jqp.Query(`$[0]`, value.FromJS(js.ValueOf([]interface{}{100})))
-	if v.String() != "100" {
-		t.Fatalf("unexpected query result, got: %s", v)
+	v, err = jqp.Query(`$[0]`, fromJS(t, js.ValueOf([]interface{}{100})))
+	if err != nil || v != 100 {
+		t.Fatalf("unexpected query result, got: %v (%v)", v, err)
+	}
+}
+
+func TestJavaScriptIterationAndSlicing(t *testing.T) {
+	arr := fromJS(t, js.ValueOf([]interface{}{1, 2, 3}))
+	vs, err := jqp.QueryAll(`$[]`, arr)
+	if err != nil || !reflect.DeepEqual(vs, []interface{}{1, 2, 3}) {
+		t.Fatalf("unexpected query result, got: %v (%v)", vs, err)
+	}
+
+	vs, err = jqp.QueryAll(`$[1:][], $[-1]`, arr)
+	if err != nil || !reflect.DeepEqual(vs, []interface{}{2, 3, 3}) {
+		t.Fatalf("unexpected query result, got: %v (%v)", vs, err)
+	}
+
+	obj := fromJS(t, js.Global().Get("JSON").Call("parse", `{"b": "x", "a": 1}`))
+	vs, err = jqp.QueryAll(`$[], [$[]] == [$[keys[]]]`, obj)
+	if err != nil || !reflect.DeepEqual(vs, []interface{}{1, "x", true}) {
+		t.Fatalf("unexpected query result, got: %v (%v)", vs, err)
+	}
+}
+
+func TestJavaScriptBoolAndNull(t *testing.T) {
+	obj := fromJS(t, js.Global().Get("JSON").Call("parse", `{"a": true, "b": null}`))
+	vs, err := jqp.QueryAll(`$.a, $.b, $.c`, obj)
+	if err != nil || !reflect.DeepEqual(vs, []interface{}{true, nil, nil}) {
+		t.Fatalf("unexpected query result, got: %v (%v)", vs, err)
 	}
 }
 
 func TestJavascriptCalling(t *testing.T) {
-	v := jqp.Query(`$.JSON.parse('{"foo": "bar"}').foo`, value.FromJS(js.Global()))
-	if v.String() != "bar" {
-		t.Fatalf("unexpected query result, got: %s", v)
+	v, err := jqp.Query(`$.JSON.parse('{"foo": "bar"}').foo`, fromJS(t, js.Global()))
+	if err != nil || v != "bar" {
+		t.Fatalf("unexpected query result, got: %v (%v)", v, err)
+	}
+}
+
+func TestJavaScriptSetAndDelete(t *testing.T) {
+	jsv := js.Global().Get("JSON").Call("parse", `{"a": {"b": 1, "c": [1, 2]}}`)
+	obj := fromJS(t, jsv)
+	if err := jqp.Set(`.a.b, .a.c[1]`, obj, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jqp.Set(`.d`, obj, map[string]interface{}{"e": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jqp.Delete(`.a.b`, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	res := js.Global().Get("JSON").Call("stringify", jsv).String()
+	if res != `{"a":{"c":[1,5]},"d":{"e":"x"}}` {
+		t.Fatalf("unexpected js object after writing, got: %s", res)
+	}
+
+	if err := jqp.Set(`.a.c`, obj, func(args ...interface{}) interface{} { return nil }); err == nil {
+		t.Fatal("expected setting a func to fail")
+	}
+}
+
+func TestJavaScriptRecurseIsLazy(t *testing.T) {
+	obj := js.Global().Get("Function").New(`
+		var reads = 0;
+		return {reads: function() { return reads }, tree: {a: {b: 1}, get c() { reads++; return {d: 2} }}};
+	`).Invoke()
+
+	v, err := jqp.Query(`first(.. | select(. == 1))`, fromJS(t, obj.Get("tree")))
+	if err != nil || v != 1 {
+		t.Fatalf("unexpected query result, got: %v (%v)", v, err)
+	}
+
+	if n := obj.Call("reads").Int(); n != 0 {
+		t.Fatalf("expected recursion to stop before reading the getter, got %d reads", n)
+	}
+
+	vs, err := jqp.QueryAll(`[.. | select(type == "number")], [paths]`, fromJS(t, obj.Get("tree")))
+	if err != nil || !reflect.DeepEqual(vs, []interface{}{
+		[]interface{}{1, 2},
+		[]interface{}{[]interface{}{"a"}, []interface{}{"a", "b"}, []interface{}{"c"}, []interface{}{"c", "d"}},
+	}) {
+		t.Fatalf("unexpected query result, got: %v (%v)", vs, err)
 	}
 }
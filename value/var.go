@@ -1,5 +1,9 @@
 package value
 
+import (
+	"errors"
+)
+
 var _ Expr = Var("")
 
 type Var string
@@ -8,11 +12,38 @@ func (s Var) String() string {
 	return string(s)
 }
 
-func (s Var) Eval(ctx Context) Value {
-	v, ok := ctx.Decl[s]
+func (s Var) Eval(ctx Context, emit Emit) error {
+	return (&VarRef{Name: s, Pos: -1}).Eval(ctx, emit)
+}
+
+// EvalPath will emit the path of the input, other variables are
+// not path expressions
+func (s Var) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	return (&VarRef{Name: s, Pos: -1}).EvalPath(ctx, path, emit)
+}
+
+// VarRef reads a variable like Var, but errors carry the position of the
+// variable in the query. The parser reads variables such as '$x' with it.
+type VarRef struct {
+	Name Var
+	Pos  int
+}
+
+func (r *VarRef) Eval(ctx Context, emit Emit) error {
+	v, ok := ctx.lookup(r.Name)
 	if !ok {
-		panic("var not declared in context: " + string(s))
+		return evalError(r.Pos, r, errors.New("var not declared in context: "+string(r.Name)))
+	}
+
+	return emit(v)
+}
+
+// EvalPath will emit the path of the input, other variables are
+// not path expressions
+func (r *VarRef) EvalPath(ctx Context, path Array, emit EmitPath) error {
+	if r.Name != Input {
+		return evalError(r.Pos, r, evalNoPath(ctx, r))
 	}
 
-	return v
+	return emit(path, ctx.input())
 }
@@ -0,0 +1,115 @@
+package value
+
+import (
+	"errors"
+)
+
+// CollectPaths evaluates the expression as a path expression and returns
+// the paths of all of its outputs, relative to the Input of the context.
+func CollectPaths(ctx Context, e Expr) ([]Array, error) {
+	var paths []Array
+	if err := evalPath(ctx, e, Array{}, func(path Array, v Value) error {
+		paths = append(paths, path)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// WritePath replaces the value at the path inside of 'root' with 'v'. Unlike
+// the update operators it modifies the native values in place, so the parent
+// of the value must be read through a port.
+func WritePath(root Value, path Array, v Value) error {
+	if err := writePath(root, path, v); err != nil {
+		return writeError(path, err)
+	}
+
+	return nil
+}
+
+func writePath(root Value, path Array, v Value) error {
+	parent, key, err := writeParent(root, path)
+	if err != nil {
+		return err
+	}
+
+	if k, ok := key.(String); ok && !parent.cargo.IsArray() {
+		return parent.cargo.Set(string(k), v)
+	}
+
+	if i, ok := indexKey(parent.cargo.Len(), key); ok && parent.cargo.IsArray() {
+		return parent.cargo.SetIndex(i, v)
+	}
+
+	return keyError(parent, key)
+}
+
+// RemovePaths deletes the fields at the paths inside of 'root', like
+// WritePath it modifies the native values in place. Only fields can be
+// deleted as the length of native arrays is fixed, fields that don't exist
+// are ignored. The longest paths are deleted first.
+func RemovePaths(root Value, paths []Array) error {
+	sorted := make(Array, len(paths))
+	for i, path := range paths {
+		sorted[i] = path
+	}
+
+	sorted, _, err := sortByKeys(sorted, sorted)
+	if err != nil {
+		return err
+	}
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		path := sorted[i].(Array)
+		if err := removePath(root, path); err != nil {
+			return writeError(path, err)
+		}
+	}
+
+	return nil
+}
+
+func removePath(root Value, path Array) error {
+	parent, key, err := writeParent(root, path)
+	if err != nil {
+		return err
+	}
+
+	k, ok := key.(String)
+	if !ok || parent.cargo.IsArray() {
+		return errors.New("cannot delete element at index of type '" + key.whichType().String() + "' from " + typeName(parent) + " in place")
+	}
+
+	return parent.cargo.Delete(string(k))
+}
+
+// writeError annotates an error of writing in place with the path
+func writeError(path Array, err error) error {
+	s, jerr := toJSON(path)
+	if jerr != nil {
+		s = path.String()
+	}
+
+	return errors.New("jqp/value: cannot write to path " + s + ": " + err.Error())
+}
+
+// writeParent reads the port that holds the last key of the path
+func writeParent(root Value, path Array) (Port, Value, error) {
+	if len(path) == 0 {
+		return Port{}, nil, errors.New("cannot write the root value in place")
+	}
+
+	parent, err := getPath(root, path[:len(path)-1])
+	if err != nil {
+		return Port{}, nil, err
+	}
+
+	p, ok := parent.(Port)
+	if !ok {
+		return Port{}, nil, errors.New("cannot write in place to value of type '" + parent.whichType().String() + "'")
+	}
+
+	return p, path[len(path)-1], nil
+}